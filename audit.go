@@ -0,0 +1,316 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const defaultAuditTable = "audit_logs"
+
+const (
+	// AuditActionCreate 新增
+	AuditActionCreate = "create"
+	// AuditActionUpdate 更新
+	AuditActionUpdate = "update"
+	// AuditActionDelete 删除
+	AuditActionDelete = "delete"
+)
+
+type (
+	// Auditor 审计, 记录变更前后的字段值, 审计记录和变更在同一个事务中写入
+	//
+	// 通过WithAudit按模型开启, 操作人来自WithActor, 原因来自WithAuditReason
+	Auditor struct {
+		table   string
+		exclude map[string]struct{}
+	}
+
+	AuditorOption func(*Auditor)
+
+	// AuditLog 审计记录
+	AuditLog struct {
+		ID        uint64       `gorm:"primaryKey" json:"id"`
+		Table     string       `gorm:"column:table_name;type:varchar(64);not null;index:idx_audit_entity,priority:1" json:"tableName"`
+		EntityID  uint32       `gorm:"column:entity_id;not null;index:idx_audit_entity,priority:2" json:"entityId"`
+		Action    string       `gorm:"column:action;type:varchar(16);not null" json:"action"`
+		Actor     string       `gorm:"column:actor;type:varchar(128);not null;default:''" json:"actor"`
+		Reason    string       `gorm:"column:reason;type:varchar(255);not null;default:''" json:"reason"`
+		Changes   AuditChanges `gorm:"column:changes;type:json" json:"changes"`
+		CreatedAt time.Time    `gorm:"column:created_at;not null" json:"createdAt"`
+	}
+
+	// AuditChange 字段变更前后的值, 新增时Before为nil, 删除时After为nil
+	AuditChange struct {
+		Before any `json:"before"`
+		After  any `json:"after"`
+	}
+
+	// AuditChanges 列名 -> 变更
+	AuditChanges map[string]AuditChange
+
+	auditReasonCtxKey struct{}
+)
+
+// NewAuditor 创建审计, 默认写入audit_logs表, 自动更新时间字段不记录
+func NewAuditor(opts ...AuditorOption) *Auditor {
+	a := &Auditor{
+		table:   defaultAuditTable,
+		exclude: make(map[string]struct{}),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// WithAuditTable 设置审计表名
+func WithAuditTable(table string) AuditorOption {
+	return func(a *Auditor) {
+		a.table = table
+	}
+}
+
+// WithAuditExcludeFields 设置不记录的字段, 列名或者字段名
+func WithAuditExcludeFields(fields ...string) AuditorOption {
+	return func(a *Auditor) {
+		for _, field := range fields {
+			a.exclude[field] = struct{}{}
+		}
+	}
+}
+
+// WithAuditReason 在上下文中设置变更原因
+func WithAuditReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, auditReasonCtxKey{}, reason)
+}
+
+// GetAuditReason 获取上下文中的变更原因
+func GetAuditReason(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	reason, _ := ctx.Value(auditReasonCtxKey{}).(string)
+	return reason
+}
+
+// Value 实现driver.Valuer
+func (c AuditChanges) Value() (driver.Value, error) {
+	if c == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(c)
+	return string(b), err
+}
+
+// Scan 实现sql.Scanner
+func (c *AuditChanges) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	default:
+		return fmt.Errorf("unsupported audit changes type %T", value)
+	}
+}
+
+// Migrate 创建或更新审计表
+func (a *Auditor) Migrate(db *gorm.DB) error {
+	return db.Table(a.table).AutoMigrate(&AuditLog{})
+}
+
+// History 查询实体的变更历史, 按时间倒序
+func (a *Auditor) History(ctx context.Context, db *gorm.DB, table string, entityID uint32, pgInfo Pagination) ([]*AuditLog, error) {
+	var logs []*AuditLog
+	tx := db.WithContext(ctx).Table(a.table).Where("table_name = ? AND entity_id = ?", table, entityID)
+	if pgInfo != nil {
+		var total int64
+		if err := tx.Count(&total).Error; err != nil {
+			return nil, err
+		}
+		pgInfo.SetTotal(total)
+	}
+	if err := tx.Scopes(Paginate(pgInfo)).Order("id DESC").Find(&logs).Error; err != nil {
+		return nil, err
+	}
+	return logs, nil
+}
+
+// EntityHistory 查询模型T的实体变更历史
+func EntityHistory[T any](ctx context.Context, a *Auditor, db *gorm.DB, entityID uint32, pgInfo Pagination) ([]*AuditLog, error) {
+	return a.History(ctx, db, tableNameOf[T](db), entityID, pgInfo)
+}
+
+// auditCreate 新增并记录插入的数据, a为nil时直接执行
+func auditCreate[T any](ctx context.Context, a *Auditor, db *gorm.DB, ms []*T, exec func(tx *gorm.DB) error) error {
+	if a == nil {
+		return exec(db)
+	}
+	return transaction(db, func(tx *gorm.DB) error {
+		if err := exec(tx); err != nil {
+			return err
+		}
+		s, pk, err := auditSchema[T](tx)
+		if err != nil {
+			return err
+		}
+		logs := make([]*AuditLog, 0, len(ms))
+		for _, m := range ms {
+			id, _ := pk(m)
+			if changes := a.diff(ctx, s, nil, m); len(changes) > 0 {
+				logs = append(logs, a.newLog(ctx, tableNameOf[T](tx), id, AuditActionCreate, changes))
+			}
+		}
+		return a.write(tx, logs)
+	})
+}
+
+// auditWrite 更新或删除并记录受影响数据变更前后的值, a为nil时直接执行
+//
+// 变更前的数据按wheres和策略条件加锁读取, 变更后的数据按主键重新读取
+func auditWrite[T any](ctx context.Context, a *Auditor, db *gorm.DB, action string, kind PolicyKind, wheres []ScopeMethod, exec func(tx *gorm.DB) error) error {
+	if a == nil {
+		return exec(db)
+	}
+	policies, err := policyScopes[T](ctx, kind)
+	if err != nil {
+		return err
+	}
+	return transaction(db, func(tx *gorm.DB) error {
+		var befores []*T
+		if err := tx.Scopes(wheres...).Scopes(policies...).Clauses(clause.Locking{Strength: "UPDATE"}).Find(&befores).Error; err != nil {
+			return err
+		}
+		if err := exec(tx); err != nil {
+			return err
+		}
+		if len(befores) == 0 {
+			return nil
+		}
+
+		s, pk, err := auditSchema[T](tx)
+		if err != nil {
+			return err
+		}
+		ids := make([]uint32, 0, len(befores))
+		for _, m := range befores {
+			if id, ok := pk(m); ok {
+				ids = append(ids, id)
+			}
+		}
+		afters := make(map[uint32]*T, len(ids))
+		if action == AuditActionUpdate && len(ids) > 0 {
+			var ms []*T
+			if err := tx.Session(&gorm.Session{NewDB: true}).Table(tableNameOf[T](tx)).Unscoped().Where(clause.IN{Column: clause.PrimaryColumn, Values: toAnySlice(ids)}).Find(&ms).Error; err != nil {
+				return err
+			}
+			for _, m := range ms {
+				if id, ok := pk(m); ok {
+					afters[id] = m
+				}
+			}
+		}
+
+		table := tableNameOf[T](tx)
+		logs := make([]*AuditLog, 0, len(befores))
+		for _, before := range befores {
+			id, _ := pk(before)
+			var after any
+			if m, ok := afters[id]; ok {
+				after = m
+			}
+			changes := a.diff(ctx, s, before, after)
+			if len(changes) == 0 {
+				continue
+			}
+			logs = append(logs, a.newLog(ctx, table, id, action, changes))
+		}
+		return a.write(tx, logs)
+	})
+}
+
+// auditSchema 解析模型T
+func auditSchema[T any](db *gorm.DB) (*schema.Schema, func(m *T) (uint32, bool), error) {
+	var m T
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&m); err != nil {
+		return nil, nil, err
+	}
+	return stmt.Schema, primaryKeyOf[T](db), nil
+}
+
+// diff 对比变更前后的字段, before或after为nil时记录另一侧的所有字段
+func (a *Auditor) diff(ctx context.Context, s *schema.Schema, before, after any) AuditChanges {
+	changes := make(AuditChanges)
+	for _, field := range s.Fields {
+		if field.DBName == "" || field.AutoUpdateTime > 0 || a.excluded(field) {
+			continue
+		}
+		var change AuditChange
+		if before != nil {
+			change.Before, _ = field.ValueOf(ctx, reflect.ValueOf(before).Elem())
+		}
+		if after != nil {
+			change.After, _ = field.ValueOf(ctx, reflect.ValueOf(after).Elem())
+		}
+		if before != nil && after != nil && reflect.DeepEqual(change.Before, change.After) {
+			continue
+		}
+		changes[field.DBName] = change
+	}
+	return changes
+}
+
+func (a *Auditor) excluded(field *schema.Field) bool {
+	if _, ok := a.exclude[field.DBName]; ok {
+		return true
+	}
+	_, ok := a.exclude[field.Name]
+	return ok
+}
+
+func (a *Auditor) newLog(ctx context.Context, table string, id uint32, action string, changes AuditChanges) *AuditLog {
+	var actor string
+	if v, ok := GetActor(ctx); ok {
+		actor = fmt.Sprint(v)
+	}
+	return &AuditLog{
+		Table:     table,
+		EntityID:  id,
+		Action:    action,
+		Actor:     actor,
+		Reason:    GetAuditReason(ctx),
+		Changes:   changes,
+		CreatedAt: time.Now(),
+	}
+}
+
+// write 写入审计记录, 使用新的会话避免继承业务语句的条件
+func (a *Auditor) write(tx *gorm.DB, logs []*AuditLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	if err := tx.Session(&gorm.Session{NewDB: true}).Table(a.table).Create(&logs).Error; err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	return nil
+}
+
+func toAnySlice[E any](s []E) []any {
+	res := make([]any, 0, len(s))
+	for _, v := range s {
+		res = append(res, v)
+	}
+	return res
+}
@@ -0,0 +1,129 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+)
+
+type auditDoc struct {
+	ID     uint32
+	Title  string
+	Status int
+}
+
+// auditLogs 写入的审计记录, 按列名返回参数
+func auditLogs(t *testing.T, fake *fakeDB) []map[string]any {
+	t.Helper()
+	var logs []map[string]any
+	for _, stmt := range fake.executed("INSERT INTO `audit_logs`") {
+		start := strings.Index(stmt.SQL, "(")
+		end := strings.Index(stmt.SQL, ")")
+		columns := strings.Split(strings.ReplaceAll(stmt.SQL[start+1:end], "`", ""), ",")
+		for i := 0; i+len(columns) <= len(stmt.Args); i += len(columns) {
+			log := make(map[string]any, len(columns))
+			for j, column := range columns {
+				log[column] = stmt.Args[i+j]
+			}
+			logs = append(logs, log)
+		}
+	}
+	return logs
+}
+
+// auditChanges 审计记录中的变更
+func auditChanges(t *testing.T, v any) AuditChanges {
+	t.Helper()
+	if changes, ok := v.(AuditChanges); ok {
+		return changes
+	}
+	var changes AuditChanges
+	if err := changes.Scan(v); err != nil {
+		t.Fatal(err)
+	}
+	return changes
+}
+
+func TestAuditUpdateDiff(t *testing.T) {
+	db, fake := newFakeDB(t)
+	columns := []string{"id", "title", "status"}
+	// 变更后按主键读取, 变更前加锁读取
+	fake.on("FROM `audit_docs`").returns(columns, []driver.Value{int64(1), "new", int64(1)})
+	fake.on("FOR UPDATE").returns(columns, []driver.Value{int64(1), "old", int64(1)})
+
+	ctx := WithAuditReason(WithActor(context.Background(), "alice"), "rename")
+	a := NewAction[auditDoc](WithDB[auditDoc](db), WithAudit[auditDoc](NewAuditor())).WithContext(ctx)
+	if err := a.UpdateMap(map[string]any{"title": "new"}, WhereID(1)); err != nil {
+		t.Fatalf("UpdateMap() = %v", err)
+	}
+
+	logs := auditLogs(t, fake)
+	if len(logs) != 1 {
+		t.Fatalf("audit logs = %v, want one", logs)
+	}
+	log := logs[0]
+	if log["table_name"] != "audit_docs" || log["entity_id"] != uint32(1) || log["action"] != AuditActionUpdate ||
+		log["actor"] != "alice" || log["reason"] != "rename" {
+		t.Fatalf("audit log = %v", log)
+	}
+	changes := auditChanges(t, log["changes"])
+	if len(changes) != 1 || changes["title"].Before != "old" || changes["title"].After != "new" {
+		t.Fatalf("changes = %v, want only title old -> new", changes)
+	}
+	if stmts := fake.executed("INSERT INTO `audit_logs`"); !stmts[0].InTx {
+		t.Fatal("audit log should be written in the mutation transaction")
+	}
+}
+
+func TestAuditDeleteDiff(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.on("FOR UPDATE").returns([]string{"id", "title", "status"}, []driver.Value{int64(2), "doc", int64(3)})
+
+	a := NewAction[auditDoc](WithDB[auditDoc](db), WithAudit[auditDoc](NewAuditor()))
+	if err := a.Delete(WhereID(2)); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+
+	logs := auditLogs(t, fake)
+	if len(logs) != 1 || logs[0]["action"] != AuditActionDelete || logs[0]["entity_id"] != uint32(2) {
+		t.Fatalf("audit logs = %v, want one delete", logs)
+	}
+	changes := auditChanges(t, logs[0]["changes"])
+	if len(changes) != 3 || changes["title"].Before != "doc" || changes["title"].After != nil {
+		t.Fatalf("changes = %v, want all fields with before values", changes)
+	}
+	// 删除不需要重新读取
+	if stmts := fake.executed("SELECT"); len(stmts) != 1 {
+		t.Fatalf("delete read %d times, want only the locked read", len(stmts))
+	}
+}
+
+func TestAuditDryRun(t *testing.T) {
+	db, fake := newFakeDB(t)
+	a := NewAction[auditDoc](WithDB[auditDoc](db), WithAudit[auditDoc](NewAuditor()))
+
+	stmts, err := a.ToSQL(func(a IAction[auditDoc]) error {
+		if err := a.Create(&auditDoc{Title: "doc"}); err != nil {
+			return err
+		}
+		if err := a.UpdateMap(map[string]any{"title": "new"}, WhereID(1)); err != nil {
+			return err
+		}
+		return a.Delete(WhereID(1))
+	})
+	if err != nil {
+		t.Fatalf("ToSQL() = %v", err)
+	}
+	if len(stmts) != 3 {
+		t.Fatalf("ToSQL() = %v, want only the three mutations", stmts)
+	}
+	for _, stmt := range stmts {
+		if strings.Contains(stmt.SQL, "audit_logs") || strings.Contains(stmt.SQL, "FOR UPDATE") {
+			t.Errorf("DryRun rendered audit statement %s", stmt.SQL)
+		}
+	}
+	if executed := fake.executed(""); len(executed) != 0 {
+		t.Fatalf("DryRun executed %v", executed)
+	}
+}
@@ -9,6 +9,8 @@ import (
 )
 
 // IBind 绑定操作, 用于链式操作
+//
+// 返回IAction的方法都返回新的动作, 不修改原动作, 同一个动作可以作为单例在多个请求和goroutine中使用
 type IBind[T any] interface {
 	// WithDB 设置DB
 	WithDB(db *gorm.DB) IAction[T]
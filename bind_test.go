@@ -0,0 +1,43 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBindDerive(t *testing.T) {
+	base := NewAction[User](WithDB[User](newOfflineDB(t)))
+
+	firstSQL := func(a IAction[User]) string {
+		stmts, err := a.ToSQL(func(a IAction[User]) error {
+			_, err := a.First()
+			return err
+		})
+		if err != nil || len(stmts) != 1 {
+			t.Errorf("ToSQL() = %v, %v", stmts, err)
+			return ""
+		}
+		return stmts[0].Rendered
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		wg.Add(1)
+		go func(id uint32) {
+			defer wg.Done()
+			a := base.WithContext(context.Background()).Scopes(WhereID(id)).Order("name").Asc()
+			got := firstSQL(a)
+			if want := fmt.Sprintf("WHERE id = %d AND", id); !strings.Contains(got, want) || strings.Count(got, "id =") != 1 {
+				t.Errorf("derived SQL = %q, want only %q", got, want)
+			}
+		}(uint32(i))
+	}
+	wg.Wait()
+
+	if got := firstSQL(base); strings.Contains(got, "id =") || strings.Contains(got, "`name`") {
+		t.Fatalf("base action changed by derived chains: %q", got)
+	}
+}
@@ -0,0 +1,253 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	gormBudgetTime   = "__gorm_budget_time"
+	budgetBeforeName = "budget:before"
+	budgetAfterName  = "budget:after"
+)
+
+// ErrBudgetExceeded 超出请求的查询预算
+var ErrBudgetExceeded = errors.New("query budget exceeded")
+
+// BudgetMode 超出预算后的处理方式
+type BudgetMode int8
+
+const (
+	// BudgetFail 超出预算后, 后续的语句直接返回ErrBudgetExceeded, 不再访问数据库
+	BudgetFail BudgetMode = iota
+	// BudgetLog 超出预算时输出一次警告日志, 语句照常执行
+	BudgetLog
+)
+
+type (
+	// QueryBudget 请求级别的查询预算, 通过WithQueryBudget放入上下文, 由BudgetPlugin统计和检查
+	//
+	// 一般在请求入口创建, 用于发现N+1查询和扫描过多数据的接口
+	QueryBudget struct {
+		maxQueries int
+		maxDBTime  time.Duration
+		maxRows    int64
+		mode       BudgetMode
+
+		mu       sync.Mutex
+		usage    BudgetUsage
+		exceeded error
+		logged   bool
+	}
+
+	QueryBudgetOption func(*QueryBudget)
+
+	// BudgetUsage 预算的使用情况
+	BudgetUsage struct {
+		// Queries 执行的语句数量
+		Queries int
+		// DBTime 语句的总耗时
+		DBTime time.Duration
+		// Rows 查询返回和变更影响的总行数
+		Rows int64
+	}
+
+	// BudgetPlugin 查询预算插件, 上下文中没有QueryBudget时不做任何处理
+	BudgetPlugin struct{}
+
+	queryBudgetCtxKey struct{}
+)
+
+// NewQueryBudget 创建查询预算, 未设置的项不限制, 默认超出后直接失败
+func NewQueryBudget(opts ...QueryBudgetOption) *QueryBudget {
+	b := &QueryBudget{}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// WithBudgetMaxQueries 设置最多执行的语句数量
+func WithBudgetMaxQueries(n int) QueryBudgetOption {
+	return func(b *QueryBudget) {
+		b.maxQueries = n
+	}
+}
+
+// WithBudgetMaxDBTime 设置语句的最大总耗时
+func WithBudgetMaxDBTime(d time.Duration) QueryBudgetOption {
+	return func(b *QueryBudget) {
+		b.maxDBTime = d
+	}
+}
+
+// WithBudgetMaxRows 设置查询返回和变更影响的最大总行数
+func WithBudgetMaxRows(n int64) QueryBudgetOption {
+	return func(b *QueryBudget) {
+		b.maxRows = n
+	}
+}
+
+// WithBudgetMode 设置超出预算后的处理方式
+func WithBudgetMode(mode BudgetMode) QueryBudgetOption {
+	return func(b *QueryBudget) {
+		b.mode = mode
+	}
+}
+
+// WithQueryBudget 在上下文中设置查询预算
+func WithQueryBudget(ctx context.Context, b *QueryBudget) context.Context {
+	return context.WithValue(ctx, queryBudgetCtxKey{}, b)
+}
+
+// GetQueryBudget 获取上下文中的查询预算
+func GetQueryBudget(ctx context.Context) *QueryBudget {
+	if ctx == nil {
+		return nil
+	}
+	b, _ := ctx.Value(queryBudgetCtxKey{}).(*QueryBudget)
+	return b
+}
+
+// Usage 获取预算的使用情况
+func (b *QueryBudget) Usage() BudgetUsage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.usage
+}
+
+// Err 超出预算时返回ErrBudgetExceeded, 否则返回nil
+func (b *QueryBudget) Err() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exceeded
+}
+
+// begin 语句执行前检查, BudgetFail模式下超出预算时返回错误
+func (b *QueryBudget) begin() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.usage.Queries++
+	b.check()
+	if b.mode == BudgetFail {
+		return b.exceeded
+	}
+	return nil
+}
+
+// end 语句执行后累计耗时和行数, 返回需要输出的警告
+func (b *QueryBudget) end(elapsed time.Duration, rows int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.usage.DBTime += elapsed
+	if rows > 0 {
+		b.usage.Rows += rows
+	}
+	b.check()
+	if b.mode == BudgetLog && b.exceeded != nil && !b.logged {
+		b.logged = true
+		return b.exceeded
+	}
+	return nil
+}
+
+// check 检查是否超出预算, 只记录第一次超出的原因
+func (b *QueryBudget) check() {
+	if b.exceeded != nil {
+		return
+	}
+	switch {
+	case b.maxQueries > 0 && b.usage.Queries > b.maxQueries:
+		b.exceeded = fmt.Errorf("%w: %d queries, limit %d", ErrBudgetExceeded, b.usage.Queries, b.maxQueries)
+	case b.maxDBTime > 0 && b.usage.DBTime > b.maxDBTime:
+		b.exceeded = fmt.Errorf("%w: db time %s, limit %s", ErrBudgetExceeded, b.usage.DBTime, b.maxDBTime)
+	case b.maxRows > 0 && b.usage.Rows > b.maxRows:
+		b.exceeded = fmt.Errorf("%w: %d rows, limit %d", ErrBudgetExceeded, b.usage.Rows, b.maxRows)
+	}
+}
+
+// NewBudgetPlugin 创建查询预算插件
+func NewBudgetPlugin() *BudgetPlugin {
+	return &BudgetPlugin{}
+}
+
+func (p *BudgetPlugin) Name() string {
+	return "budgetPlugin"
+}
+
+func (p *BudgetPlugin) Initialize(db *gorm.DB) (err error) {
+	cb := db.Callback()
+	if err = cb.Create().Before("gorm:before_create").Register(budgetBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Query().Before("gorm:query").Register(budgetBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Delete().Before("gorm:before_delete").Register(budgetBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Update().Before("gorm:setup_reflect_value").Register(budgetBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Row().Before("gorm:row").Register(budgetBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Raw().Before("gorm:raw").Register(budgetBeforeName, p.before); err != nil {
+		return err
+	}
+
+	if err = cb.Create().After("gorm:after_create").Register(budgetAfterName, p.after); err != nil {
+		return err
+	}
+	if err = cb.Query().After("gorm:after_query").Register(budgetAfterName, p.after); err != nil {
+		return err
+	}
+	if err = cb.Delete().After("gorm:after_delete").Register(budgetAfterName, p.after); err != nil {
+		return err
+	}
+	if err = cb.Update().After("gorm:after_update").Register(budgetAfterName, p.after); err != nil {
+		return err
+	}
+	if err = cb.Row().After("gorm:row").Register(budgetAfterName, p.after); err != nil {
+		return err
+	}
+	if err = cb.Raw().After("gorm:raw").Register(budgetAfterName, p.after); err != nil {
+		return err
+	}
+	return
+}
+
+func (p *BudgetPlugin) before(db *gorm.DB) {
+	b := GetQueryBudget(db.Statement.Context)
+	if b == nil || db.DryRun || db.Error != nil {
+		return
+	}
+	if err := b.begin(); err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	db.InstanceSet(gormBudgetTime, time.Now())
+}
+
+func (p *BudgetPlugin) after(db *gorm.DB) {
+	b := GetQueryBudget(db.Statement.Context)
+	if b == nil {
+		return
+	}
+	_start, isExist := db.InstanceGet(gormBudgetTime)
+	if !isExist {
+		return
+	}
+	start, ok := _start.(time.Time)
+	if !ok {
+		return
+	}
+	if err := b.end(time.Since(start), db.RowsAffected); err != nil {
+		db.Logger.Warn(db.Statement.Context, "%s, operation %s, usage %+v", err, GetOperationName(db.Statement.Context), b.Usage())
+	}
+}
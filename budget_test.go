@@ -0,0 +1,38 @@
+package query
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueryBudget(t *testing.T) {
+	b := NewQueryBudget(WithBudgetMaxQueries(2), WithBudgetMaxRows(100))
+	for i := 0; i < 2; i++ {
+		if err := b.begin(); err != nil {
+			t.Fatalf("query %d: unexpected error %v", i, err)
+		}
+		_ = b.end(time.Millisecond, 10)
+	}
+	if err := b.begin(); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected ErrBudgetExceeded, got %v", err)
+	}
+	if usage := b.Usage(); usage.Queries != 3 || usage.Rows != 20 || usage.DBTime != 2*time.Millisecond {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+
+	b = NewQueryBudget(WithBudgetMaxRows(5), WithBudgetMode(BudgetLog))
+	_ = b.begin()
+	if err := b.end(0, 10); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("expected a warning, got %v", err)
+	}
+	if err := b.begin(); err != nil {
+		t.Fatalf("log mode must not fail, got %v", err)
+	}
+	if err := b.end(0, 1); err != nil {
+		t.Fatalf("warning should be reported once, got %v", err)
+	}
+	if !errors.Is(b.Err(), ErrBudgetExceeded) {
+		t.Fatal("expected budget to be exceeded")
+	}
+}
@@ -0,0 +1,271 @@
+package query
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var _ ICache = (*lruCache)(nil)
+
+const (
+	defaultEntityCacheTTL = time.Minute
+	defaultLRUCacheSize   = 1024
+)
+
+type (
+	// ICache 缓存接口, 可以自定义实现(例如redis)
+	//
+	// tags用于批量失效, 例如以表名作为tag, 表上任意变更都可以失效该表相关的缓存
+	ICache interface {
+		// Get 获取缓存
+		Get(ctx context.Context, key string) (any, bool)
+		// Set 设置缓存, ttl<=0表示不过期
+		Set(ctx context.Context, key string, value any, ttl time.Duration, tags ...string)
+		// Delete 删除缓存
+		Delete(ctx context.Context, keys ...string)
+		// InvalidateTags 失效带有指定tag的所有缓存
+		InvalidateTags(ctx context.Context, tags ...string)
+	}
+
+	// EntityCache 根据ID查询的实体缓存
+	EntityCache struct {
+		cache       ICache
+		ttl         time.Duration
+		negativeTTL time.Duration
+		group       flightGroup
+	}
+
+	EntityCacheOption func(*EntityCache)
+
+	// entityNotFound 负缓存标记
+	entityNotFound struct{}
+
+	lruCache struct {
+		mu    sync.Mutex
+		size  int
+		ll    *list.List
+		items map[string]*list.Element
+		tags  map[string]map[string]struct{}
+	}
+
+	lruEntry struct {
+		key      string
+		value    any
+		expireAt time.Time
+		tags     []string
+	}
+)
+
+// NewLRUCache 创建内存LRU缓存, 超过size时淘汰最久未使用的数据
+func NewLRUCache(size int) ICache {
+	if size <= 0 {
+		size = defaultLRUCacheSize
+	}
+	return &lruCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+		tags:  make(map[string]map[string]struct{}),
+	}
+}
+
+func (l *lruCache) Get(_ context.Context, key string) (any, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*lruEntry)
+	if !entry.expireAt.IsZero() && time.Now().After(entry.expireAt) {
+		l.removeElement(el)
+		return nil, false
+	}
+	l.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+func (l *lruCache) Set(_ context.Context, key string, value any, ttl time.Duration, tags ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.items[key]; ok {
+		l.removeElement(el)
+	}
+	entry := &lruEntry{key: key, value: value, tags: tags}
+	if ttl > 0 {
+		entry.expireAt = time.Now().Add(ttl)
+	}
+	l.items[key] = l.ll.PushFront(entry)
+	for _, tag := range tags {
+		if l.tags[tag] == nil {
+			l.tags[tag] = make(map[string]struct{})
+		}
+		l.tags[tag][key] = struct{}{}
+	}
+	for l.ll.Len() > l.size {
+		l.removeElement(l.ll.Back())
+	}
+}
+
+func (l *lruCache) Delete(_ context.Context, keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := l.items[key]; ok {
+			l.removeElement(el)
+		}
+	}
+}
+
+func (l *lruCache) InvalidateTags(_ context.Context, tags ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, tag := range tags {
+		for key := range l.tags[tag] {
+			if el, ok := l.items[key]; ok {
+				l.removeElement(el)
+			}
+		}
+		delete(l.tags, tag)
+	}
+}
+
+// removeElement 删除元素, 调用方需持有锁
+func (l *lruCache) removeElement(el *list.Element) {
+	entry := l.ll.Remove(el).(*lruEntry)
+	delete(l.items, entry.key)
+	for _, tag := range entry.tags {
+		delete(l.tags[tag], entry.key)
+		if len(l.tags[tag]) == 0 {
+			delete(l.tags, tag)
+		}
+	}
+}
+
+// NewEntityCache 创建实体缓存, 默认缓存1分钟, 不开启负缓存
+func NewEntityCache(cache ICache, opts ...EntityCacheOption) *EntityCache {
+	c := &EntityCache{
+		cache: cache,
+		ttl:   defaultEntityCacheTTL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithEntityCacheTTL 设置缓存时间
+func WithEntityCacheTTL(ttl time.Duration) EntityCacheOption {
+	return func(c *EntityCache) {
+		c.ttl = ttl
+	}
+}
+
+// WithEntityCacheNegativeTTL 设置负缓存时间, 数据不存在时也缓存, 避免反复穿透到数据库
+func WithEntityCacheNegativeTTL(ttl time.Duration) EntityCacheOption {
+	return func(c *EntityCache) {
+		c.negativeTTL = ttl
+	}
+}
+
+// Invalidate 失效缓存, ids为空时失效整张表
+func (c *EntityCache) Invalidate(ctx context.Context, table string, ids ...uint32) {
+	if c == nil || c.cache == nil {
+		return
+	}
+	if len(ids) == 0 {
+		c.cache.InvalidateTags(ctx, entityCacheTag(table))
+		return
+	}
+	// 同一张表可能在多个数据库中缓存, 按ID的tag失效
+	tags := make([]string, 0, len(ids))
+	for _, id := range ids {
+		tags = append(tags, entityCacheIDTag(table, id))
+	}
+	c.cache.InvalidateTags(ctx, tags...)
+}
+
+// sharedCacheable db的结果是否可以放入共享的缓存
+//
+// 链上附加了子句、预加载、关联、字段选择, 或者在事务中(包括gorm原生的事务)时, 结果和调用链相关, 不读写共享缓存; DryRun时同样不走缓存
+func sharedCacheable(db *gorm.DB) bool {
+	if db.DryRun || inTransaction(db) {
+		return false
+	}
+	stmt := db.Statement
+	return len(stmt.Clauses) == 0 && len(stmt.Preloads) == 0 && len(stmt.Joins) == 0 &&
+		len(stmt.Selects) == 0 && len(stmt.Omits) == 0 && !stmt.Unscoped
+}
+
+// entityCacheKey 实体缓存key, pool为connPoolKey返回的连接池标识, 同一个缓存可能被连接不同数据库的IAction共享
+func entityCacheKey(pool, table string, id uint32) string {
+	return "entity:" + pool + ":" + table + ":" + strconv.FormatUint(uint64(id), 10)
+}
+
+// entityCacheTag 实体缓存tag
+func entityCacheTag(table string) string {
+	return "entity:" + table
+}
+
+// entityCacheIDTag 实体缓存按ID的tag, 用于失效所有连接池中该ID的缓存
+func entityCacheIDTag(table string, id uint32) string {
+	return "entity:" + table + ":" + strconv.FormatUint(uint64(id), 10)
+}
+
+// loadEntity 读穿缓存, 相同key的并发加载只会访问一次数据库
+//
+// 共享的加载使用不可取消的上下文执行, 某个等待者取消只会让它自己提前返回; 返回的是缓存数据的浅拷贝, 避免调用方修改缓存内容
+func loadEntity[T any](ctx context.Context, c *EntityCache, db *gorm.DB, id uint32, load func(ctx context.Context) (*T, error)) (*T, error) {
+	pool, ok := connPoolKey(db.Statement.ConnPool)
+	if !ok {
+		return load(ctx)
+	}
+	table := tableNameOf[T](db)
+	key := entityCacheKey(pool, table, id)
+	tags := []string{entityCacheTag(table), entityCacheIDTag(table, id)}
+	if v, ok := c.cache.Get(ctx, key); ok {
+		switch val := v.(type) {
+		case entityNotFound:
+			return nil, notFoundError(ctx, table)
+		case T:
+			return &val, nil
+		}
+	}
+
+	v, err, _ := c.group.Do(ctx, key, func() (any, error) {
+		lctx, cancel := detachContext(ctx)
+		defer cancel()
+		m, err := load(lctx)
+		switch {
+		case err == nil:
+			c.cache.Set(lctx, key, *m, c.ttl, tags...)
+		case errors.Is(err, gorm.ErrRecordNotFound) && c.negativeTTL > 0:
+			c.cache.Set(lctx, key, entityNotFound{}, c.negativeTTL, tags...)
+		}
+		return m, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	m := *(v.(*T))
+	return &m, nil
+}
+
+// invalidateAfterCommit 立即失效缓存, 在Transaction开启的事务中提交后再失效一次
+//
+// 提交前失效后, 并发的读取可能把提交前的数据重新放入缓存, 一直保留到过期; gorm原生的事务无法感知提交, 只能立即失效
+func invalidateAfterCommit(ctx context.Context, db *gorm.DB, invalidate func(ctx context.Context)) {
+	invalidate(ctx)
+	if afterCommitHooksOf(db) == nil {
+		return
+	}
+	// 提交时操作的上下文可能已经结束
+	detached := context.WithoutCancel(ctx)
+	_ = afterCommit(db, func() { invalidate(detached) })
+}
@@ -0,0 +1,187 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errRollback = errors.New("rollback")
+
+type (
+	cacheOwner struct {
+		ID   uint32
+		Name string
+		Pets []cachePet
+	}
+
+	cachePet struct {
+		ID           uint32
+		CacheOwnerID uint32
+	}
+)
+
+func TestLRUCache(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCache(2)
+	c.Set(ctx, "a", 1, 0, "users")
+	c.Set(ctx, "b", 2, 0)
+	c.Get(ctx, "a")
+	// 超过容量, 淘汰最久未使用的b
+	c.Set(ctx, "c", 3, 0)
+	if _, ok := c.Get(ctx, "b"); ok {
+		t.Fatal("b should be evicted")
+	}
+	if v, ok := c.Get(ctx, "a"); !ok || v.(int) != 1 {
+		t.Fatal("a should be cached")
+	}
+
+	c.InvalidateTags(ctx, "users")
+	if _, ok := c.Get(ctx, "a"); ok {
+		t.Fatal("a should be invalidated by tag")
+	}
+
+	c.Set(ctx, "d", 4, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.Get(ctx, "d"); ok {
+		t.Fatal("d should be expired")
+	}
+}
+
+func TestEntityCacheBypass(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.on("FROM `cache_owners`").returns([]string{"id", "name"}, []driver.Value{int64(1), "plain"})
+	a := NewAction[cacheOwner](WithDB[cacheOwner](db), WithEntityCache[cacheOwner](NewEntityCache(NewLRUCache(10))))
+	selects := func() int { return len(fake.executed("FROM `cache_owners`")) }
+
+	for i := 0; i < 2; i++ {
+		if m, err := a.FirstByID(1); err != nil || m.Name != "plain" {
+			t.Fatalf("FirstByID() = %+v, %v", m, err)
+		}
+	}
+	if n := selects(); n != 1 {
+		t.Fatalf("plain FirstByID executed %d times, want 1", n)
+	}
+
+	// 链上的状态改变了结果, 不能读写共享缓存
+	fake.on("FROM `cache_owners`").returns([]string{"id", "name"}, []driver.Value{int64(1), "chained"})
+	chains := map[string]IAction[cacheOwner]{
+		"scopes":  a.Scopes(func(db *gorm.DB) *gorm.DB { return db.Where("name <> ?", "") }),
+		"preload": a.Preload("Pets"),
+		"joins":   a.Joins("LEFT JOIN cache_pets ON cache_pets.cache_owner_id = cache_owners.id"),
+		"clauses": a.Clauses(clause.Locking{Strength: "UPDATE"}),
+	}
+	for name, chain := range chains {
+		before := selects()
+		if m, err := chain.FirstByID(1); err != nil || m.Name != "chained" {
+			t.Fatalf("%s: FirstByID() = %+v, %v, want fresh row", name, m, err)
+		}
+		if selects() != before+1 {
+			t.Fatalf("%s: FirstByID() should bypass the cache", name)
+		}
+	}
+	if m, _ := a.FirstByID(1); m.Name != "plain" {
+		t.Fatalf("chained reads overwrote the cache: %+v", m)
+	}
+
+	// 事务中读到的可能是未提交的数据, 不能写入共享缓存
+	fake.on("FROM `cache_owners`").returns([]string{"id", "name"}, []driver.Value{int64(2), "uncommitted"})
+	for _, tx := range []func(fn func(tx *gorm.DB) error) error{
+		func(fn func(tx *gorm.DB) error) error { return Transaction(context.Background(), db, fn) },
+		func(fn func(tx *gorm.DB) error) error { return db.Transaction(fn) },
+	} {
+		_ = tx(func(tx *gorm.DB) error {
+			if m, err := a.WithDB(tx).FirstByID(2); err != nil || m.Name != "uncommitted" {
+				t.Fatalf("FirstByID() in transaction = %+v, %v", m, err)
+			}
+			return errRollback
+		})
+	}
+	fake.on("FROM `cache_owners`").returns([]string{"id", "name"}, []driver.Value{int64(2), "committed"})
+	if m, err := a.FirstByID(2); err != nil || m.Name != "committed" {
+		t.Fatalf("FirstByID() after rollback = %+v, %v, want committed row", m, err)
+	}
+}
+
+func TestEntityCacheInvalidation(t *testing.T) {
+	db, fake := newFakeDB(t)
+	cache := NewEntityCache(NewLRUCache(10), WithEntityCacheNegativeTTL(time.Minute))
+	a := NewAction[cacheOwner](WithDB[cacheOwner](db), WithEntityCache[cacheOwner](cache))
+	columns := []string{"id", "name"}
+
+	// 新增的ID失效负缓存
+	if _, err := a.FirstByID(5); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("FirstByID() = %v, want ErrNotFound", err)
+	}
+	if err := a.Create(&cacheOwner{ID: 5, Name: "created"}); err != nil {
+		t.Fatal(err)
+	}
+	fake.on("FROM `cache_owners`").returns(columns, []driver.Value{int64(5), "created"})
+	if m, err := a.FirstByID(5); err != nil || m.Name != "created" {
+		t.Fatalf("FirstByID() after Create = %+v, %v, want the created row", m, err)
+	}
+
+	// 提交前并发读取到的旧数据在提交后失效
+	fake.on("FROM `cache_owners`").returns(columns, []driver.Value{int64(1), "old"})
+	err := Transaction(context.Background(), db, func(tx *gorm.DB) error {
+		if err := a.WithDB(tx).UpdateMap(map[string]any{"name": "new"}, WhereID(1)); err != nil {
+			return err
+		}
+		if m, err := a.FirstByID(1); err != nil || m.Name != "old" {
+			t.Errorf("FirstByID() before commit = %+v, %v", m, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.on("FROM `cache_owners`").returns(columns, []driver.Value{int64(1), "new"})
+	if m, err := a.FirstByID(1); err != nil || m.Name != "new" {
+		t.Fatalf("FirstByID() after commit = %+v, %v, want the committed row", m, err)
+	}
+}
+
+func TestEntityCacheLoadCancel(t *testing.T) {
+	db, fake := newFakeDB(t)
+	release := make(chan struct{})
+	fake.on("FROM `cache_owners`").returns([]string{"id", "name"}, []driver.Value{int64(1), "shared"}).blocks(release)
+	cache := NewEntityCache(NewLRUCache(10))
+	a := NewAction[cacheOwner](WithDB[cacheOwner](db), WithEntityCache[cacheOwner](cache))
+
+	// 第一个调用方发起共享的加载后取消, 不影响其他等待者
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := a.WithContext(ctx).FirstByID(1)
+		first <- err
+	}()
+	waitUntil(t, func() bool { return len(fake.executed("FROM `cache_owners`")) == 1 })
+	second := make(chan *cacheOwner, 1)
+	go func() {
+		m, _ := a.FirstByID(1)
+		second <- m
+	}()
+	waitUntil(t, func() bool {
+		cache.group.mu.Lock()
+		defer cache.group.mu.Unlock()
+		pool, _ := connPoolKey(db.Statement.ConnPool)
+		call := cache.group.m[entityCacheKey(pool, "cache_owners", 1)]
+		return call != nil && call.dups > 0
+	})
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled FirstByID() = %v, want context.Canceled", err)
+	}
+	close(release)
+	if m := <-second; m == nil || m.Name != "shared" {
+		t.Fatalf("waiting FirstByID() = %+v, want the shared row", m)
+	}
+	if n := len(fake.executed("FROM `cache_owners`")); n != 1 {
+		t.Fatalf("loaded %d times, want 1", n)
+	}
+}
@@ -0,0 +1,100 @@
+package query
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+type (
+	// Coalescer 请求合并, 相同SQL和参数的并发查询只访问一次数据库
+	//
+	// 共享查询使用不可取消的上下文执行, 某个等待者取消只会让它自己提前返回, 操作的默认超时仍然生效
+	Coalescer struct {
+		group flightGroup
+	}
+
+	// listResult 合并列表查询的结果
+	listResult[T any] struct {
+		items []*T
+		total int64
+	}
+)
+
+// NewCoalescer 创建请求合并器, 多个IAction可以共享同一个合并器
+func NewCoalescer() *Coalescer {
+	return &Coalescer{}
+}
+
+// coalesce 合并相同SQL的并发查询, render用于渲染SQL生成key, load执行真正的查询
+//
+// 结果被多个调用方共享时, 每个调用方拿到的是clone之后的副本; 不同连接池的查询不合并, 事务中和带预加载的查询不合并
+func coalesce[R any](ctx context.Context, c *Coalescer, db *gorm.DB, kind string, render func(tx *gorm.DB) *gorm.DB, load func(ctx context.Context) (R, error), clone func(R) R) (R, error) {
+	var zero R
+	if c == nil || !queryCacheable(db) {
+		return load(ctx)
+	}
+	pool, ok := connPoolKey(db.Statement.ConnPool)
+	if !ok {
+		return load(ctx)
+	}
+	key, _, err := renderKey(db, render)
+	if err != nil {
+		return load(ctx)
+	}
+
+	v, err, shared := c.group.Do(ctx, pool+":"+kind+":"+key, func() (any, error) {
+		lctx, cancel := detachContext(ctx)
+		defer cancel()
+		return load(lctx)
+	})
+	if err != nil {
+		return zero, err
+	}
+	r := v.(R)
+	if shared {
+		r = clone(r)
+	}
+	return r, nil
+}
+
+// connPoolKey 连接池的标识, 同一个合并器可能被连接不同数据库的IAction共享
+//
+// 连接池不是指针时无法区分, 返回false, 调用方不合并
+func connPoolKey(pool gorm.ConnPool) (string, bool) {
+	v := reflect.ValueOf(pool)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return "", false
+	}
+	return strconv.FormatUint(uint64(v.Pointer()), 16), true
+}
+
+// cloneEntity 复制实体
+func cloneEntity[T any](m *T) *T {
+	if m == nil {
+		return nil
+	}
+	c := *m
+	return &c
+}
+
+// cloneEntities 复制实体列表
+func cloneEntities[T any](ms []*T) []*T {
+	items := make([]*T, 0, len(ms))
+	for _, m := range ms {
+		items = append(items, cloneEntity(m))
+	}
+	return items
+}
+
+// cloneListResult 复制列表结果
+func cloneListResult[T any](r listResult[T]) listResult[T] {
+	return listResult[T]{items: cloneEntities(r.items), total: r.total}
+}
+
+// cloneCount 数量无需复制
+func cloneCount(total int64) int64 {
+	return total
+}
@@ -0,0 +1,82 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// waitUntil 等待cond成立, 超时失败
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	c := NewCoalescer()
+	release := make(chan struct{})
+	db1, f1 := newFakeDB(t)
+	f1.on("FROM `users`").returns([]string{"id", "name"}, []driver.Value{int64(1), "db1"}).blocks(release)
+	db2, f2 := newFakeDB(t)
+	f2.on("FROM `users`").returns([]string{"id", "name"}, []driver.Value{int64(2), "db2"})
+	a1 := NewAction[User](WithDB[User](db1), WithCoalescer[User](c))
+	a2 := NewAction[User](WithDB[User](db2), WithCoalescer[User](c))
+
+	var wg sync.WaitGroup
+	results := make([][]*User, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = a1.List(nil)
+		}(i)
+	}
+	waitUntil(t, func() bool { return len(f1.executed("FROM `users`")) == 1 })
+
+	// 相同SQL, 不同的连接池不合并
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ms, err := a2.WithContext(ctx).List(nil)
+	if err != nil || len(ms) != 1 || ms[0].Name != "db2" {
+		t.Fatalf("List() on another pool = %+v, %v, want its own rows", ms, err)
+	}
+
+	// 事务中的查询不合并
+	done := make(chan error, 1)
+	go func() {
+		done <- db1.Transaction(func(tx *gorm.DB) error {
+			_, err := a1.WithDB(tx).List(nil)
+			return err
+		})
+	}()
+	waitUntil(t, func() bool { return len(f1.executed("FROM `users`")) == 2 })
+	if stmts := f1.executed("FROM `users`"); !stmts[1].InTx {
+		t.Fatalf("second query should run in the transaction: %+v", stmts)
+	}
+
+	close(release)
+	wg.Wait()
+	if err := <-done; err != nil {
+		t.Fatalf("Transaction() = %v", err)
+	}
+	for i, r := range results {
+		if len(r) != 1 || r[0].Name != "db1" {
+			t.Fatalf("coalesced result %d = %+v", i, r)
+		}
+	}
+	if results[0][0] == results[1][0] {
+		t.Fatal("coalesced callers should get copies")
+	}
+	if got := len(f1.executed("FROM `users`")); got != 2 {
+		t.Fatalf("executed %d queries, want 2", got)
+	}
+}
@@ -0,0 +1,97 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testCtxKey struct{}
+
+func TestWithContextCancel(t *testing.T) {
+	base := NewAction[User](WithDB[User](newOfflineDB(t)))
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	a := base.WithContext(canceled)
+	if _, err := a.First(WhereID(1)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("First() = %v, want context.Canceled", err)
+	}
+	if _, err := a.UpdateMapRows(map[string]any{"name": "test"}, WhereID(1)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("UpdateMapRows() = %v, want context.Canceled", err)
+	}
+	if a.CountX(); !errors.Is(a.Err(), context.Canceled) {
+		t.Fatalf("CountX() err = %v, want context.Canceled", a.Err())
+	}
+	if base.Err() != nil {
+		t.Fatalf("base action err = %v, derived errors should not leak", base.Err())
+	}
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := base.WithContext(expired).List(NewPage(1, 10)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("List() = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestWithContextPropagation(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]any{}
+	base := NewAction[User](
+		WithDB[User](newOfflineDB(t)),
+		WithInterceptors[User](func(ctx context.Context, inv *Invocation, next Invoker) (any, error) {
+			mu.Lock()
+			seen[inv.Operation] = ctx.Value(testCtxKey{})
+			mu.Unlock()
+			if inv.Operation == "List" {
+				return []*User{}, nil
+			}
+			return nil, nil
+		}),
+	)
+	ctx := context.WithValue(context.Background(), testCtxKey{}, "request")
+	a := base.WithContext(ctx)
+	_, _ = a.First()
+	a.DeleteX(WhereID(1))
+
+	// 批次不随调用方取消, 但保留调用方上下文中的值
+	loadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	_, _ = NewLoader[User](base, WithLoaderWait[User](time.Millisecond)).Load(loadCtx, 1)
+
+	mu.Lock()
+	for _, op := range []string{"First", "DeleteX", "List"} {
+		if seen[op] != "request" {
+			t.Errorf("%s saw context value %v, want request", op, seen[op])
+		}
+	}
+	mu.Unlock()
+
+	stmts, err := NewAction[User](WithDB[User](newOfflineDB(t))).ToSQL(func(a IAction[User]) error {
+		_, err := a.WithContext(ctx).Count()
+		return err
+	})
+	if err != nil || len(stmts) != 1 {
+		t.Fatalf("ToSQL() with WithContext = %v, %v", stmts, err)
+	}
+}
+
+func TestLoaderCancel(t *testing.T) {
+	release := make(chan struct{})
+	a := NewAction[User](
+		WithDB[User](newOfflineDB(t)),
+		WithInterceptors[User](func(ctx context.Context, inv *Invocation, next Invoker) (any, error) {
+			<-release
+			return []*User{}, nil
+		}),
+	)
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := NewLoader[User](a, WithLoaderWait[User](time.Millisecond)).Load(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Load() = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,79 @@
+package query
+
+import (
+	"context"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+type (
+	// SQLStatement DryRun渲染出的语句
+	SQLStatement struct {
+		// SQL 带占位符的语句
+		SQL string
+		// Vars 语句的参数
+		Vars []any
+		// Rendered 按方言代入参数后的语句, 未经过脱敏, 只用于调试和测试
+		Rendered string
+	}
+
+	// IDryRun 预览操作将要执行的SQL
+	IDryRun[T any] interface {
+		// ToSQL 以DryRun方式执行fn中的操作, 按执行顺序返回渲染出的语句, 不访问数据库
+		ToSQL(fn func(a IAction[T]) error) ([]SQLStatement, error)
+	}
+
+	// sqlRecorder 记录ToSQL中渲染出的语句
+	sqlRecorder struct {
+		mu    sync.Mutex
+		stmts []SQLStatement
+	}
+
+	sqlRecorderCtxKey struct{}
+)
+
+// ToSQL 以DryRun方式执行fn中的操作, 按执行顺序返回渲染出的语句, 不访问数据库
+//
+// 分页的List依次返回count和分页两条语句; DryRun时跳过缓存、合并查询和事务, 变更只渲染本身的语句, 不执行审计、发件箱和生命周期事件
+func (a *action[T]) ToSQL(fn func(a IAction[T]) error) ([]SQLStatement, error) {
+	rec := &sqlRecorder{}
+	dry := a.derive(func(d *action[T]) {
+		d.dryRun = true
+		d.ctx = context.WithValue(a.GetCtx(), sqlRecorderCtxKey{}, rec)
+	})
+
+	err := fn(dry)
+	return rec.statements(), err
+}
+
+// dryRunSession DryRun会话, 同时跳过gorm默认开启的事务, 避免访问数据库
+func dryRunSession(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{DryRun: true, SkipDefaultTransaction: true})
+}
+
+// sqlRecorderOf 获取上下文中的sqlRecorder, 只在ToSQL中存在
+func sqlRecorderOf(ctx context.Context) *sqlRecorder {
+	if ctx == nil {
+		return nil
+	}
+	rec, _ := ctx.Value(sqlRecorderCtxKey{}).(*sqlRecorder)
+	return rec
+}
+
+// record 记录一条语句, vars为脱敏前的原始参数
+func (r *sqlRecorder) record(dialector gorm.Dialector, sql string, vars []any) {
+	stmt := SQLStatement{SQL: sql, Vars: append([]any(nil), vars...)}
+	if dialector != nil {
+		stmt.Rendered = dialector.Explain(sql, vars...)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stmts = append(r.stmts, stmt)
+}
+
+func (r *sqlRecorder) statements() []SQLStatement {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]SQLStatement(nil), r.stmts...)
+}
@@ -0,0 +1,51 @@
+package query
+
+import (
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newOfflineDB 不连接数据库的DB, 只用于渲染SQL
+func newOfflineDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "root@tcp(127.0.0.1:3306)/test",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DisableAutomaticPing: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestToSQL(t *testing.T) {
+	a := NewAction[User](WithDB[User](newOfflineDB(t)))
+	stmts, err := a.ToSQL(func(a IAction[User]) error {
+		if _, err := a.List(NewPage(2, 10), WhereID(1, 2)); err != nil {
+			return err
+		}
+		return a.UpdateMapByID(3, map[string]any{"name": "test"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"SELECT count(*) FROM `users` WHERE id in (1,2) AND `users`.`deleted_at` = 0",
+		"SELECT * FROM `users` WHERE id in (1,2) AND `users`.`deleted_at` = 0 LIMIT 10 OFFSET 10",
+		"UPDATE `users` SET `name`='test',`updated_at`=",
+	}
+	if len(stmts) != len(want) {
+		t.Fatalf("ToSQL() got %d statements, want %d: %+v", len(stmts), len(want), stmts)
+	}
+	for i, stmt := range stmts {
+		if len(stmt.Rendered) < len(want[i]) || stmt.Rendered[:len(want[i])] != want[i] {
+			t.Errorf("statement %d = %s, want prefix %s", i, stmt.Rendered, want[i])
+		}
+	}
+	if got := len(stmts[0].Vars); got != 3 {
+		t.Errorf("count vars = %d, want 3", got)
+	}
+}
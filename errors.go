@@ -0,0 +1,427 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	// ErrNotFound 数据不存在, 返回的*OpError同时满足errors.Is(err, gorm.ErrRecordNotFound)
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicateKey 唯一键冲突, 冲突的索引名见OpError.Constraint
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrForeignKeyViolation 违反外键约束, 约束名见OpError.Constraint
+	ErrForeignKeyViolation = errors.New("foreign key violation")
+	// ErrDeadlock 死锁, 事务已被数据库回滚
+	ErrDeadlock = errors.New("deadlock")
+	// ErrLockTimeout 等待锁超时
+	ErrLockTimeout = errors.New("lock timeout")
+	// ErrSerializationFailure 可串行化事务冲突, 需要重试整个事务
+	ErrSerializationFailure = errors.New("serialization failure")
+	// ErrDataTooLong 数据超出字段长度
+	ErrDataTooLong = errors.New("data too long")
+	// ErrConnection 连接失败或者连接中断
+	ErrConnection = errors.New("connection error")
+)
+
+type (
+	// OpError 操作错误, 通过errors.Is匹配ErrXxx, 通过errors.As获取驱动的原始错误
+	OpError struct {
+		// Op 操作名, 例如First、UpdateMap
+		Op string
+		// Table 表名
+		Table string
+		// SQL 按默认脱敏策略渲染的语句
+		SQL string
+		// Duration 操作开始到出错的耗时
+		Duration time.Duration
+		// Kind 错误类型, 见ErrXxx, 不能识别时为nil
+		Kind error
+		// Constraint 冲突的索引名或者约束名
+		Constraint string
+		// Err 原始错误
+		Err error
+	}
+
+	// ErrorTranslator 把驱动错误转换为ErrXxx, 不能识别时返回nil
+	ErrorTranslator interface {
+		Translate(err error) (kind error, constraint string)
+	}
+
+	// ErrorTranslatorFunc 函数形式的ErrorTranslator
+	ErrorTranslatorFunc func(err error) (kind error, constraint string)
+
+	// operationInfo 上下文中的操作信息
+	operationInfo struct {
+		name   string
+		start  time.Time
+		failed *failedStatement
+	}
+
+	// failedStatement 操作中最近一次出错的语句
+	failedStatement struct {
+		mu   sync.Mutex
+		sql  string
+		vars []any
+		// rendered 按默认脱敏策略渲染的SQL
+		rendered string
+	}
+
+	// errorSQLLogger 语句出错时把SQL记录到上下文的failedStatement中, 其他行为和内层logger一致
+	//
+	// gorm执行结束后会清空Statement.SQL, 只能在Trace中获取出错的语句.
+	// 内层logger看到的调用栈多了本文件的Trace, SlogLogger和慢查询插件通过callerLocation跳过本包的源码,
+	// gorm内置logger只跳过gorm自身的源码, 本来就会把本包当作调用位置
+	errorSQLLogger struct {
+		logger.Interface
+		dialector gorm.Dialector
+	}
+)
+
+var (
+	errorTranslatorMutex sync.RWMutex
+	// errorTranslators 方言名(gorm.Dialector.Name) -> 错误转换
+	errorTranslators = map[string]ErrorTranslator{
+		"mysql":    ErrorTranslatorFunc(translateMySQLError),
+		"postgres": ErrorTranslatorFunc(translatePostgresError),
+		"sqlite":   ErrorTranslatorFunc(translateSQLiteError),
+	}
+)
+
+func (e *OpError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Op)
+	if e.Table != "" {
+		b.WriteString(" " + e.Table)
+	}
+	b.WriteString(": ")
+	if e.Kind != nil {
+		b.WriteString(e.Kind.Error())
+		if e.Constraint != "" {
+			b.WriteString(" " + e.Constraint)
+		}
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+// Unwrap 同时返回错误类型和原始错误
+func (e *OpError) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Translate 实现ErrorTranslator
+func (f ErrorTranslatorFunc) Translate(err error) (error, string) {
+	return f(err)
+}
+
+// RegisterErrorTranslator 注册方言的错误转换, 覆盖内置的mysql、postgres、sqlite转换
+func RegisterErrorTranslator(dialect string, t ErrorTranslator) {
+	errorTranslatorMutex.Lock()
+	defer errorTranslatorMutex.Unlock()
+	errorTranslators[dialect] = t
+}
+
+// TranslateError 按db的方言识别错误类型, db为nil时依次尝试所有已注册的错误转换
+func TranslateError(db *gorm.DB, err error) (kind error, constraint string) {
+	var opErr *OpError
+	switch {
+	case err == nil:
+		return nil, ""
+	case errors.As(err, &opErr) && opErr.Kind != nil:
+		return opErr.Kind, opErr.Constraint
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound, ""
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		// 开启了gorm的TranslateError时, 驱动错误已经被转换, 无法获取索引名
+		return ErrDuplicateKey, ""
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		return ErrForeignKeyViolation, ""
+	}
+
+	for _, t := range translatorsFor(db) {
+		if kind, constraint = t.Translate(err); kind != nil {
+			return kind, constraint
+		}
+	}
+
+	if isConnectionError(err) {
+		return ErrConnection, ""
+	}
+	return nil, ""
+}
+
+func translatorsFor(db *gorm.DB) []ErrorTranslator {
+	errorTranslatorMutex.RLock()
+	defer errorTranslatorMutex.RUnlock()
+	if db != nil && db.Dialector != nil {
+		if t := errorTranslators[db.Dialector.Name()]; t != nil {
+			return []ErrorTranslator{t}
+		}
+		return nil
+	}
+	ts := make([]ErrorTranslator, 0, len(errorTranslators))
+	for _, t := range errorTranslators {
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+// isConnectionError 连接失败或者连接中断
+func isConnectionError(err error) bool {
+	// context的超时错误也实现了net.Error
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.As(err, &netErr)
+}
+
+// translateResult 把tx执行的错误转换为*OpError
+func translateResult[T any](ctx context.Context, tx *gorm.DB) error {
+	if tx.Error == nil {
+		return nil
+	}
+	return newOpError[T](ctx, tx, tx.Error)
+}
+
+// newOpError 创建*OpError, 已经是*OpError的错误原样返回
+func newOpError[T any](ctx context.Context, tx *gorm.DB, err error) error {
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		return err
+	}
+	kind, constraint := TranslateError(tx, err)
+	opErr = &OpError{
+		Op:         GetOperationName(ctx),
+		Table:      tableNameOf[T](tx),
+		Kind:       kind,
+		Constraint: constraint,
+		Err:        err,
+	}
+	if start := operationStart(ctx); !start.IsZero() {
+		opErr.Duration = time.Since(start)
+	}
+	if fs := failedStatementOf(ctx); fs != nil {
+		opErr.SQL = fs.take()
+	}
+	return opErr
+}
+
+// translateKnown 只转换能识别类型的错误, 用于审计、发件箱等附加语句返回的错误
+func translateKnown[T any](ctx context.Context, db *gorm.DB, err error) error {
+	if err == nil {
+		return nil
+	}
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		return err
+	}
+	if kind, _ := TranslateError(db, err); kind == nil {
+		return err
+	}
+	return newOpError[T](ctx, db, err)
+}
+
+// notFoundError 缓存或者批量加载未命中数据时返回的错误
+func notFoundError(ctx context.Context, table string) error {
+	return &OpError{Op: GetOperationName(ctx), Table: table, Kind: ErrNotFound, Err: gorm.ErrRecordNotFound}
+}
+
+// operationStart 获取上下文中操作的开始时间
+func operationStart(ctx context.Context) time.Time {
+	if ctx == nil {
+		return time.Time{}
+	}
+	info, _ := ctx.Value(operationNameCtxKey{}).(operationInfo)
+	return info.start
+}
+
+func failedStatementOf(ctx context.Context) *failedStatement {
+	if ctx == nil {
+		return nil
+	}
+	info, _ := ctx.Value(operationNameCtxKey{}).(operationInfo)
+	return info.failed
+}
+
+// operationDB 操作使用的DB, 绑定ctx并在语句出错时记录SQL
+func operationDB(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if _, ok := db.Logger.(*errorSQLLogger); ok || db.Logger == nil {
+		return db.WithContext(ctx)
+	}
+	return db.Session(&gorm.Session{
+		Context: ctx,
+		Logger:  &errorSQLLogger{Interface: db.Logger, dialector: db.Dialector},
+	})
+}
+
+// LogMode 实现logger.Interface
+func (l *errorSQLLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &errorSQLLogger{Interface: l.Interface.LogMode(level), dialector: l.dialector}
+}
+
+// Trace 实现logger.Interface, 出错或者在ToSQL中时先记录SQL再交给内层logger
+func (l *errorSQLLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	// fc会调用ParamsFilter, 由ParamsFilter记录原始SQL和参数, 只执行一次避免重复记录
+	var (
+		once sync.Once
+		sql  string
+		rows int64
+	)
+	render := func() (string, int64) {
+		once.Do(func() { sql, rows = fc() })
+		return sql, rows
+	}
+	if sqlRecorderOf(ctx) != nil {
+		render()
+	}
+	if fs := failedStatementOf(ctx); err != nil && fs != nil {
+		render()
+		fs.render(l.dialector)
+	}
+	l.Interface.Trace(ctx, begin, render, err)
+}
+
+// ParamsFilter 实现gorm.ParamsFilter, 记录原始SQL和参数后交给内层logger处理
+func (l *errorSQLLogger) ParamsFilter(ctx context.Context, sql string, params ...any) (string, []any) {
+	if fs := failedStatementOf(ctx); fs != nil {
+		fs.mu.Lock()
+		fs.sql, fs.vars = sql, params
+		fs.mu.Unlock()
+	}
+	if rec := sqlRecorderOf(ctx); rec != nil {
+		rec.record(l.dialector, sql, params)
+	}
+	if filter, ok := l.Interface.(gorm.ParamsFilter); ok {
+		return filter.ParamsFilter(ctx, sql, params...)
+	}
+	return sql, params
+}
+
+func (fs *failedStatement) render(dialector gorm.Dialector) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.rendered = GetDefaultRedactionPolicy().explain(dialector, fs.sql, "", fs.vars...)
+	fs.sql, fs.vars = "", nil
+}
+
+// take 取出出错的SQL
+func (fs *failedStatement) take() string {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	sql := fs.rendered
+	fs.rendered = ""
+	return sql
+}
+
+// mysqlConstraintPattern 匹配 for key 'idx' 或者 CONSTRAINT `fk`
+var mysqlConstraintPattern = regexp.MustCompile("for key '([^']+)'|CONSTRAINT `([^`]+)`")
+
+// translateMySQLError MySQL按错误码识别
+func translateMySQLError(err error) (error, string) {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return nil, ""
+	}
+	var kind error
+	switch myErr.Number {
+	case 1062, 1586:
+		kind = ErrDuplicateKey
+	case 1216, 1217, 1451, 1452:
+		kind = ErrForeignKeyViolation
+	case 1213:
+		kind = ErrDeadlock
+	case 1205:
+		kind = ErrLockTimeout
+	case 1406:
+		kind = ErrDataTooLong
+	case 1040, 1042, 1043, 1047, 1053, 1081, 1152, 1158, 1159, 1160, 1161:
+		kind = ErrConnection
+	default:
+		return nil, ""
+	}
+	return kind, lastSubmatch(mysqlConstraintPattern, myErr.Message)
+}
+
+// postgresConstraintPattern 匹配 constraint "name"
+var postgresConstraintPattern = regexp.MustCompile(`constraint "([^"]+)"`)
+
+// translatePostgresError Postgres按SQLSTATE识别, pgconn.PgError和pq.Error都实现了SQLState
+func translatePostgresError(err error) (error, string) {
+	var stateErr interface {
+		error
+		SQLState() string
+	}
+	if !errors.As(err, &stateErr) {
+		return nil, ""
+	}
+	state := stateErr.SQLState()
+	var kind error
+	switch {
+	case state == "23505":
+		kind = ErrDuplicateKey
+	case state == "23503":
+		kind = ErrForeignKeyViolation
+	case state == "40P01":
+		kind = ErrDeadlock
+	case state == "40001":
+		kind = ErrSerializationFailure
+	case state == "55P03":
+		kind = ErrLockTimeout
+	case state == "22001":
+		kind = ErrDataTooLong
+	case strings.HasPrefix(state, "08"):
+		kind = ErrConnection
+	default:
+		return nil, ""
+	}
+	return kind, lastSubmatch(postgresConstraintPattern, stateErr.Error())
+}
+
+// translateSQLiteError SQLite按错误信息识别, 冲突时返回 table.column 作为约束名
+func translateSQLiteError(err error) (error, string) {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "UNIQUE constraint failed: "), strings.Contains(msg, "PRIMARY KEY constraint failed: "):
+		_, columns, _ := strings.Cut(msg, "constraint failed: ")
+		return ErrDuplicateKey, strings.TrimSpace(columns)
+	case strings.Contains(msg, "FOREIGN KEY constraint failed"):
+		return ErrForeignKeyViolation, ""
+	case strings.Contains(msg, "database is locked"), strings.Contains(msg, "database table is locked"):
+		return ErrLockTimeout, ""
+	case strings.Contains(msg, "string or blob too big"):
+		return ErrDataTooLong, ""
+	}
+	return nil, ""
+}
+
+// lastSubmatch 返回最后一个非空的分组
+func lastSubmatch(re *regexp.Regexp, s string) string {
+	match := re.FindStringSubmatch(s)
+	for i := len(match) - 1; i > 0; i-- {
+		if match[i] != "" {
+			return match[i]
+		}
+	}
+	return ""
+}
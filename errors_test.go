@@ -0,0 +1,70 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+type sqlStateError struct {
+	state, msg string
+}
+
+func (e *sqlStateError) Error() string    { return e.msg }
+func (e *sqlStateError) SQLState() string { return e.state }
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		kind       error
+		constraint string
+	}{
+		{"not found", gorm.ErrRecordNotFound, ErrNotFound, ""},
+		{"mysql duplicate", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a' for key 'users.idx_email'"}, ErrDuplicateKey, "users.idx_email"},
+		{"mysql foreign key", &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row: a foreign key constraint fails (`db`.`orders`, CONSTRAINT `fk_orders_user` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))"}, ErrForeignKeyViolation, "fk_orders_user"},
+		{"mysql deadlock", fmt.Errorf("wrapped: %w", &mysql.MySQLError{Number: 1213}), ErrDeadlock, ""},
+		{"mysql lock timeout", &mysql.MySQLError{Number: 1205}, ErrLockTimeout, ""},
+		{"mysql data too long", &mysql.MySQLError{Number: 1406}, ErrDataTooLong, ""},
+		{"mysql bad conn", mysql.ErrInvalidConn, ErrConnection, ""},
+		{"postgres duplicate", &sqlStateError{"23505", `duplicate key value violates unique constraint "users_email_key"`}, ErrDuplicateKey, "users_email_key"},
+		{"postgres deadlock", &sqlStateError{"40P01", "deadlock detected"}, ErrDeadlock, ""},
+		{"postgres connection", &sqlStateError{"08006", "connection failure"}, ErrConnection, ""},
+		{"sqlite duplicate", errors.New("UNIQUE constraint failed: users.email"), ErrDuplicateKey, "users.email"},
+		{"sqlite busy", errors.New("database is locked"), ErrLockTimeout, ""},
+		{"deadline", fmt.Errorf("query: %w", context.DeadlineExceeded), nil, ""},
+		{"unknown", errors.New("boom"), nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind, constraint := TranslateError(nil, tt.err)
+			if kind != tt.kind || constraint != tt.constraint {
+				t.Fatalf("got (%v, %q), want (%v, %q)", kind, constraint, tt.kind, tt.constraint)
+			}
+		})
+	}
+}
+
+func TestOpErrorUnwrap(t *testing.T) {
+	cause := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a' for key 'idx_email'"}
+	var err error = &OpError{Op: "Create", Table: "users", Kind: ErrDuplicateKey, Constraint: "idx_email", Err: cause}
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Fatal("expected ErrDuplicateKey")
+	}
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) || myErr.Number != 1062 {
+		t.Fatal("expected driver error")
+	}
+	if got := ErrorClass(err); got != "duplicate_key" {
+		t.Fatalf("unexpected class %q", got)
+	}
+
+	err = notFoundError(WithOperationName(context.Background(), "First"), "users")
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatal("expected not found")
+	}
+}
@@ -0,0 +1,333 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+)
+
+const planLintBeforeName = "plan_lint:before"
+
+var (
+	// ErrExplainUnsupported 当前方言不支持解析执行计划
+	ErrExplainUnsupported = errors.New("explain unsupported")
+	// ErrPlanLint 执行计划中有超出阈值的全表扫描或者文件排序
+	ErrPlanLint = errors.New("query plan lint failed")
+)
+
+// LintMode 执行计划检查不通过时的处理方式
+type LintMode int8
+
+const (
+	// LintFail 查询直接返回ErrPlanLint, 不再执行
+	LintFail LintMode = iota
+	// LintWarn 输出警告日志, 查询照常执行
+	LintWarn
+)
+
+var _ gorm.Plugin = (*PlanLintPlugin)(nil)
+
+type (
+	// Plan 解析后的执行计划
+	Plan struct {
+		// SQL 被分析的语句, 按默认脱敏策略渲染
+		SQL string
+		// Rows 执行计划的每一步
+		Rows []PlanRow
+	}
+
+	// PlanRow 执行计划中的一步
+	PlanRow struct {
+		// Table 访问的表, 排序等步骤为空
+		Table string
+		// Access 访问方式, MySQL为type列(ALL、index、range、ref等), PostgreSQL为节点类型, SQLite为SCAN/SEARCH
+		Access string
+		// Key 使用的索引
+		Key string
+		// EstimatedRows 估算的行数, 数据库不提供时为-1
+		EstimatedRows int64
+		// Extra 附加信息, 例如MySQL的Using where、Using filesort
+		Extra []string
+		// FullScan 是否全表扫描
+		FullScan bool
+		// Filesort 是否需要额外排序
+		Filesort bool
+	}
+
+	// PlanLintPlugin 执行计划检查插件, 查询执行前先EXPLAIN, 全表扫描或者文件排序的估算行数超过阈值时按LintMode处理
+	//
+	// 每个查询都会多执行一次EXPLAIN, 一般只在测试和预发环境中开启, 用于保证查询条件能用上索引
+	PlanLintPlugin struct {
+		maxRows int64
+		mode    LintMode
+	}
+
+	PlanLintOption func(*PlanLintPlugin)
+
+	// planParser 方言对应的EXPLAIN前缀和结果解析
+	planParser struct {
+		prefix string
+		parse  func(raw []map[string]any) []PlanRow
+	}
+)
+
+var (
+	planParsers = map[string]planParser{
+		"mysql":    {prefix: "EXPLAIN ", parse: parseMySQLPlan},
+		"postgres": {prefix: "EXPLAIN ", parse: parsePostgresPlan},
+		"sqlite":   {prefix: "EXPLAIN QUERY PLAN ", parse: parseSQLitePlan},
+	}
+
+	// postgresPlanNode 匹配 -> Index Scan using idx on users u  (cost=0.15..8.17 rows=1 width=40)
+	postgresPlanNode = regexp.MustCompile(`^\s*(?:->\s*)?([A-Za-z][A-Za-z ]*?)(?: using (\S+))?(?: on (\S+)(?: \S+)?)?\s+\(cost=\S+ rows=(\d+)`)
+)
+
+// NewPlanLintPlugin 创建执行计划检查插件, 默认检查不通过时查询失败, 不限制行数即任何全表扫描和文件排序都不通过
+func NewPlanLintPlugin(opts ...PlanLintOption) *PlanLintPlugin {
+	p := &PlanLintPlugin{}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// WithPlanLintMaxRows 设置阈值, 估算行数不超过n的全表扫描和文件排序视为通过
+func WithPlanLintMaxRows(n int64) PlanLintOption {
+	return func(p *PlanLintPlugin) {
+		p.maxRows = n
+	}
+}
+
+// WithPlanLintMode 设置检查不通过时的处理方式
+func WithPlanLintMode(mode LintMode) PlanLintOption {
+	return func(p *PlanLintPlugin) {
+		p.mode = mode
+	}
+}
+
+func (p *PlanLintPlugin) Name() string {
+	return "planLintPlugin"
+}
+
+func (p *PlanLintPlugin) Initialize(db *gorm.DB) error {
+	return db.Callback().Query().Before("gorm:query").Register(planLintBeforeName, p.before)
+}
+
+// before 提前构建查询语句并EXPLAIN, gorm:query会直接使用构建好的语句
+func (p *PlanLintPlugin) before(db *gorm.DB) {
+	if db.DryRun || db.Error != nil {
+		return
+	}
+	if _, ok := planParsers[db.Dialector.Name()]; !ok {
+		return
+	}
+	callbacks.BuildQuerySQL(db)
+	if db.Error != nil || db.Statement.SQL.Len() == 0 {
+		return
+	}
+
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	plan, err := explainStatement(ctx, db, db.Statement.SQL.String(), db.Statement.Vars)
+	if err != nil {
+		db.Logger.Warn(ctx, "plan lint: explain failed, operation %s: %s", GetOperationName(ctx), err)
+		return
+	}
+	if err = plan.Lint(p.maxRows); err == nil {
+		return
+	}
+	if p.mode == LintWarn {
+		db.Logger.Warn(ctx, "%s, operation %s", err, GetOperationName(ctx))
+		return
+	}
+	_ = db.AddError(err)
+}
+
+// Lint 检查估算行数超过maxRows的全表扫描和文件排序, 估算行数未知时视为超过, 不通过时返回ErrPlanLint
+func (p *Plan) Lint(maxRows int64) error {
+	var problems []string
+	for _, row := range p.Rows {
+		if row.EstimatedRows >= 0 && row.EstimatedRows <= maxRows {
+			continue
+		}
+		if row.FullScan {
+			problems = append(problems, row.describe("full scan"))
+		}
+		if row.Filesort {
+			problems = append(problems, row.describe("filesort"))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s: %s", ErrPlanLint, strings.Join(problems, ", "), p.SQL)
+}
+
+// describe 检查不通过的描述
+func (r PlanRow) describe(problem string) string {
+	if r.Table != "" {
+		problem += " on " + r.Table
+	}
+	if r.EstimatedRows >= 0 {
+		problem += fmt.Sprintf(" (rows %d)", r.EstimatedRows)
+	}
+	return problem
+}
+
+// explainQuery 渲染db的查询语句并EXPLAIN, DryRun时返回gorm.ErrDryRunModeUnsupported
+func explainQuery[T any](ctx context.Context, db *gorm.DB) (*Plan, error) {
+	if db.DryRun {
+		return nil, gorm.ErrDryRunModeUnsupported
+	}
+	var ms []*T
+	stmt := db.Session(&gorm.Session{DryRun: true}).Find(&ms)
+	if stmt.Error != nil {
+		return nil, stmt.Error
+	}
+	plan, err := explainStatement(ctx, db, stmt.Statement.SQL.String(), stmt.Statement.Vars)
+	if err != nil {
+		return nil, translateKnown[T](ctx, db, err)
+	}
+	return plan, nil
+}
+
+// explainStatement 在db的连接上执行EXPLAIN并按方言解析, 在事务中时使用事务的连接
+func explainStatement(ctx context.Context, db *gorm.DB, sql string, vars []any) (*Plan, error) {
+	parser, ok := planParsers[db.Dialector.Name()]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrExplainUnsupported, db.Dialector.Name())
+	}
+	rows, err := db.Statement.ConnPool.QueryContext(ctx, parser.prefix+sql, vars...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	raw, err := scanPlanRows(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &Plan{
+		SQL:  GetDefaultRedactionPolicy().explain(db.Dialector, sql, db.Statement.Table, vars...),
+		Rows: parser.parse(raw),
+	}, nil
+}
+
+// parseMySQLPlan 解析MySQL的EXPLAIN, type为ALL时是全表扫描
+func parseMySQLPlan(raw []map[string]any) []PlanRow {
+	rows := make([]PlanRow, 0, len(raw))
+	for _, r := range raw {
+		row := PlanRow{
+			Table:         planString(r["table"]),
+			Access:        planString(r["type"]),
+			Key:           planString(r["key"]),
+			EstimatedRows: planInt(r["rows"]),
+		}
+		if extra := planString(r["Extra"]); extra != "" {
+			for _, e := range strings.Split(extra, ";") {
+				row.Extra = append(row.Extra, strings.TrimSpace(e))
+			}
+		}
+		row.FullScan = row.Access == "ALL"
+		row.Filesort = strings.Contains(planString(r["Extra"]), "Using filesort")
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// parsePostgresPlan 解析PostgreSQL文本格式的EXPLAIN, 每个节点一步, 节点下的Filter、Sort Key等作为Extra
+func parsePostgresPlan(raw []map[string]any) []PlanRow {
+	var rows []PlanRow
+	for _, r := range raw {
+		line := planString(r["QUERY PLAN"])
+		m := postgresPlanNode.FindStringSubmatch(line)
+		if m == nil {
+			if len(rows) > 0 && strings.TrimSpace(line) != "" {
+				rows[len(rows)-1].Extra = append(rows[len(rows)-1].Extra, strings.TrimSpace(line))
+			}
+			continue
+		}
+		row := PlanRow{
+			Access:        m[1],
+			Key:           m[2],
+			Table:         m[3],
+			EstimatedRows: planInt(m[4]),
+		}
+		row.FullScan = row.Access == "Seq Scan"
+		row.Filesort = row.Access == "Sort" || row.Access == "Incremental Sort"
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// parseSQLitePlan 解析SQLite的EXPLAIN QUERY PLAN, 不提供估算行数
+func parseSQLitePlan(raw []map[string]any) []PlanRow {
+	rows := make([]PlanRow, 0, len(raw))
+	for _, r := range raw {
+		detail := planString(r["detail"])
+		row := PlanRow{EstimatedRows: -1, Extra: []string{detail}}
+		fields := strings.Fields(detail)
+		switch {
+		case len(fields) >= 2 && (fields[0] == "SCAN" || fields[0] == "SEARCH"):
+			row.Access = fields[0]
+			row.Table = fields[1]
+			if row.Table == "TABLE" && len(fields) >= 3 {
+				row.Table = fields[2]
+			}
+			if i := strings.Index(detail, " INDEX "); i >= 0 {
+				if key := strings.Fields(detail[i+len(" INDEX "):]); len(key) > 0 {
+					row.Key = key[0]
+				}
+			} else if strings.Contains(detail, "PRIMARY KEY") {
+				row.Key = "PRIMARY"
+			}
+			row.FullScan = row.Access == "SCAN" && !strings.Contains(detail, " USING ")
+		case strings.HasPrefix(detail, "USE TEMP B-TREE FOR"):
+			row.Access = "TEMP B-TREE"
+			row.Filesort = strings.Contains(detail, "ORDER BY")
+		default:
+			row.Access = detail
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// planString 执行计划中的值转换为字符串, NULL为空字符串
+func planString(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// planInt 执行计划中的行数, 无法解析时为-1
+func planInt(v any) int64 {
+	switch val := v.(type) {
+	case int64:
+		return val
+	case uint64:
+		return int64(val)
+	case float64:
+		return int64(val)
+	default:
+		n, err := strconv.ParseInt(planString(v), 10, 64)
+		if err != nil {
+			return -1
+		}
+		return n
+	}
+}
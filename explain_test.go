@@ -0,0 +1,65 @@
+package query
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePlan(t *testing.T) {
+	mysqlPlan := parseMySQLPlan([]map[string]any{
+		{"table": "users", "type": "ALL", "key": nil, "rows": int64(5000), "Extra": "Using where; Using filesort"},
+		{"table": "roles", "type": "eq_ref", "key": "PRIMARY", "rows": "1", "Extra": nil},
+	})
+	if !mysqlPlan[0].FullScan || !mysqlPlan[0].Filesort || mysqlPlan[0].EstimatedRows != 5000 || len(mysqlPlan[0].Extra) != 2 {
+		t.Fatalf("mysql plan = %+v", mysqlPlan[0])
+	}
+	if mysqlPlan[1].FullScan || mysqlPlan[1].Key != "PRIMARY" || mysqlPlan[1].EstimatedRows != 1 {
+		t.Fatalf("mysql plan = %+v", mysqlPlan[1])
+	}
+
+	pgPlan := parsePostgresPlan([]map[string]any{
+		{"QUERY PLAN": "Sort  (cost=10.70..10.72 rows=8 width=40)"},
+		{"QUERY PLAN": "  Sort Key: name"},
+		{"QUERY PLAN": "  ->  Seq Scan on users  (cost=0.00..10.58 rows=8 width=40)"},
+		{"QUERY PLAN": "        Filter: ((name)::text = 'test'::text)"},
+		{"QUERY PLAN": "  ->  Index Scan using users_pkey on roles r  (cost=0.15..8.17 rows=1 width=40)"},
+	})
+	if len(pgPlan) != 3 || !pgPlan[0].Filesort || pgPlan[0].Extra[0] != "Sort Key: name" {
+		t.Fatalf("postgres plan = %+v", pgPlan)
+	}
+	if !pgPlan[1].FullScan || pgPlan[1].Table != "users" || pgPlan[1].EstimatedRows != 8 {
+		t.Fatalf("postgres plan = %+v", pgPlan[1])
+	}
+	if pgPlan[2].FullScan || pgPlan[2].Key != "users_pkey" || pgPlan[2].Table != "roles" {
+		t.Fatalf("postgres plan = %+v", pgPlan[2])
+	}
+
+	sqlitePlan := parseSQLitePlan([]map[string]any{
+		{"detail": "SCAN users"},
+		{"detail": "SEARCH roles USING INDEX idx_roles_name (name=?)"},
+		{"detail": "USE TEMP B-TREE FOR ORDER BY"},
+	})
+	if !sqlitePlan[0].FullScan || sqlitePlan[0].Table != "users" || sqlitePlan[0].EstimatedRows != -1 {
+		t.Fatalf("sqlite plan = %+v", sqlitePlan[0])
+	}
+	if sqlitePlan[1].FullScan || sqlitePlan[1].Key != "idx_roles_name" || !sqlitePlan[2].Filesort {
+		t.Fatalf("sqlite plan = %+v", sqlitePlan[1:])
+	}
+}
+
+func TestPlanLint(t *testing.T) {
+	plan := &Plan{Rows: []PlanRow{
+		{Table: "users", Access: "ALL", EstimatedRows: 100, FullScan: true},
+		{Table: "roles", Access: "ref", EstimatedRows: 5000, Key: "idx_user_id"},
+	}}
+	if err := plan.Lint(1000); err != nil {
+		t.Fatalf("Lint(1000) = %v, want nil", err)
+	}
+	if err := plan.Lint(10); !errors.Is(err, ErrPlanLint) {
+		t.Fatalf("Lint(10) = %v, want ErrPlanLint", err)
+	}
+	unknown := &Plan{Rows: []PlanRow{{Table: "users", EstimatedRows: -1, FullScan: true}}}
+	if err := unknown.Lint(1000); !errors.Is(err, ErrPlanLint) {
+		t.Fatalf("Lint() with unknown rows = %v, want ErrPlanLint", err)
+	}
+}
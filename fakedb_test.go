@@ -0,0 +1,268 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type (
+	// fakeDB 测试用的数据库, 按SQL片段返回预设的结果并记录执行过的语句
+	//
+	// 使用MySQL方言, 不需要真实的数据库, 用于验证查询、变更和事务的真实执行路径
+	fakeDB struct {
+		mu        sync.Mutex
+		rules     []*fakeRule
+		stmts     []fakeStmt
+		lastID    int64
+		open      int
+		commits   int
+		rollbacks int
+	}
+
+	// fakeRule 匹配SQL片段的预设结果, 后注册的规则优先
+	fakeRule struct {
+		match    string
+		columns  []string
+		rows     [][]driver.Value
+		affected int64
+		err      error
+		wait     chan struct{}
+	}
+
+	// fakeStmt 执行过的语句
+	fakeStmt struct {
+		SQL  string
+		Args []driver.Value
+		InTx bool
+	}
+
+	fakeConnector struct{ db *fakeDB }
+
+	fakeConn struct {
+		db   *fakeDB
+		inTx bool
+	}
+
+	fakeTx struct{ conn *fakeConn }
+
+	fakeRows struct {
+		columns []string
+		rows    [][]driver.Value
+		i       int
+	}
+
+	fakeResult struct{ lastID, affected int64 }
+)
+
+// newFakeDB 创建使用fakeDB的gorm.DB
+func newFakeDB(t *testing.T) (*gorm.DB, *fakeDB) {
+	f := &fakeDB{}
+	sqlDB := sql.OpenDB(fakeConnector{db: f})
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      sqlDB,
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DisableAutomaticPing: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, f
+}
+
+// on 注册匹配SQL片段的规则, 默认查询返回空结果, 变更影响1行
+func (f *fakeDB) on(match string) *fakeRule {
+	r := &fakeRule{match: match, affected: 1}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, r)
+	return r
+}
+
+// returns 设置查询返回的列和行
+func (r *fakeRule) returns(columns []string, rows ...[]driver.Value) *fakeRule {
+	r.columns, r.rows = columns, rows
+	return r
+}
+
+// affects 设置变更影响的行数
+func (r *fakeRule) affects(n int64) *fakeRule {
+	r.affected = n
+	return r
+}
+
+// fails 设置执行返回的错误
+func (r *fakeRule) fails(err error) *fakeRule {
+	r.err = err
+	return r
+}
+
+// blocks 执行时等待ch关闭或者上下文结束
+func (r *fakeRule) blocks(ch chan struct{}) *fakeRule {
+	r.wait = ch
+	return r
+}
+
+// executed 执行过的包含match的语句
+func (f *fakeDB) executed(match string) []fakeStmt {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var stmts []fakeStmt
+	for _, s := range f.stmts {
+		if strings.Contains(s.SQL, match) {
+			stmts = append(stmts, s)
+		}
+	}
+	return stmts
+}
+
+// txCounts 提交和回滚的次数
+func (f *fakeDB) txCounts() (commits, rollbacks int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.commits, f.rollbacks
+}
+
+// openTxs 还没有提交或回滚的事务数量
+func (f *fakeDB) openTxs() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.open
+}
+
+// exec 记录语句并返回匹配的规则
+func (f *fakeDB) exec(ctx context.Context, inTx bool, query string, args []driver.NamedValue) (*fakeRule, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	f.mu.Lock()
+	f.stmts = append(f.stmts, fakeStmt{SQL: query, Args: values, InTx: inTx})
+	rule := &fakeRule{affected: 1}
+	for i := len(f.rules) - 1; i >= 0; i-- {
+		if strings.Contains(query, f.rules[i].match) {
+			rule = f.rules[i]
+			break
+		}
+	}
+	f.mu.Unlock()
+
+	if rule.wait != nil {
+		select {
+		case <-rule.wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return rule, rule.err
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: c.db}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return nil
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fakedb: prepare not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.inTx = true
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.open++
+	return fakeTx{conn: c}, nil
+}
+
+// CheckNamedValue 接受任意参数, 原样记录
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error {
+	return nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rule, err := c.db.exec(ctx, c.inTx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: rule.columns, rows: rule.rows}, nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rule, err := c.db.exec(ctx, c.inTx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.lastID++
+	return fakeResult{lastID: c.db.lastID, affected: rule.affected}, nil
+}
+
+func (t fakeTx) Commit() error {
+	t.conn.inTx = false
+	t.conn.db.mu.Lock()
+	defer t.conn.db.mu.Unlock()
+	t.conn.db.open--
+	t.conn.db.commits++
+	return nil
+}
+
+func (t fakeTx) Rollback() error {
+	t.conn.inTx = false
+	t.conn.db.mu.Lock()
+	defer t.conn.db.mu.Unlock()
+	t.conn.db.open--
+	t.conn.db.rollbacks++
+	return nil
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.i])
+	r.i++
+	return nil
+}
+
+func (r fakeResult) LastInsertId() (int64, error) {
+	return r.lastID, nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return r.affected, nil
+}
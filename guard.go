@@ -0,0 +1,241 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const allowUnboundedSettingKey = "gorm-normalize:allow_unbounded"
+
+var (
+	// ErrUnboundedWrite Update*/Delete*没有任何条件, 会影响整张表
+	ErrUnboundedWrite = errors.New("update or delete without where conditions")
+	// ErrUnboundedRead 不分页的List返回的行数超出上限
+	ErrUnboundedRead = errors.New("list without pagination exceeds max rows")
+)
+
+// GuardMode 触发防护后的处理方式
+type GuardMode int8
+
+const (
+	// GuardError 拒绝执行并返回错误
+	GuardError GuardMode = iota
+	// GuardLog 输出警告日志, 语句照常执行
+	GuardLog
+	// GuardAllow 不做检查
+	GuardAllow
+)
+
+func (m GuardMode) String() string {
+	switch m {
+	case GuardError:
+		return "error"
+	case GuardLog:
+		return "log"
+	case GuardAllow:
+		return "allow"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseGuardMode 解析error、log、allow, 用于按环境从配置中读取
+func ParseGuardMode(s string) (GuardMode, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "error":
+		return GuardError, nil
+	case "log":
+		return GuardLog, nil
+	case "allow":
+		return GuardAllow, nil
+	default:
+		return GuardError, fmt.Errorf("unknown guard mode %q", s)
+	}
+}
+
+type (
+	// Guard 无界读写的防护
+	//
+	// Update*/Delete*的条件为空时(例如WhereID传入空切片)拒绝执行, 软删除和行级策略附加的条件不算在内;
+	// 设置了maxListRows时, 不分页的List最多返回maxListRows行
+	Guard struct {
+		mode        GuardMode
+		maxListRows int
+	}
+
+	GuardOption func(*Guard)
+)
+
+var (
+	defaultGuardMutex sync.RWMutex
+	defaultGuard      = NewGuard()
+)
+
+// NewGuard 创建防护, 默认拒绝没有条件的Update*/Delete*, 不限制List的行数
+func NewGuard(opts ...GuardOption) *Guard {
+	g := &Guard{mode: GuardError}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// WithGuardMode 设置触发防护后的处理方式
+func WithGuardMode(mode GuardMode) GuardOption {
+	return func(g *Guard) {
+		g.mode = mode
+	}
+}
+
+// WithGuardMaxListRows 设置不分页的List最多返回的行数, 为0时不限制
+func WithGuardMaxListRows(n int) GuardOption {
+	return func(g *Guard) {
+		g.maxListRows = n
+	}
+}
+
+// SetDefaultGuard 设置全局默认的防护, 未通过WithGuard设置的操作使用
+func SetDefaultGuard(g *Guard) {
+	if g == nil {
+		return
+	}
+	defaultGuardMutex.Lock()
+	defer defaultGuardMutex.Unlock()
+	defaultGuard = g
+}
+
+// GetDefaultGuard 获取全局默认的防护
+func GetDefaultGuard() *Guard {
+	defaultGuardMutex.RLock()
+	defer defaultGuardMutex.RUnlock()
+	return defaultGuard
+}
+
+// AllowUnbounded 显式允许本次操作不带条件更新、删除整张表, 或者不分页查询时不限制行数
+func AllowUnbounded(db *gorm.DB) *gorm.DB {
+	return db.Set(allowUnboundedSettingKey, true)
+}
+
+// orDefault 未设置时使用全局默认的防护
+func (g *Guard) orDefault() *Guard {
+	if g == nil {
+		return GetDefaultGuard()
+	}
+	return g
+}
+
+// report 按处理方式返回错误或者输出警告
+func (g *Guard) report(ctx context.Context, db *gorm.DB, err error) error {
+	if g.mode == GuardLog {
+		db.Logger.Warn(ctx, "%s", err)
+		return nil
+	}
+	return err
+}
+
+// boundList 不分页的List按上限附加LIMIT, 多查询一行用于判断是否超出, 返回需要检查的上限, 为0时不检查
+//
+// GuardLog时不附加LIMIT, 只在超出时输出警告
+func (g *Guard) boundList(db *gorm.DB, pgInfo Pagination, wheres []ScopeMethod) (*gorm.DB, int) {
+	g = g.orDefault()
+	if pgInfo != nil || g.maxListRows <= 0 || g.mode == GuardAllow || unboundedAllowed(db, wheres) {
+		return db, 0
+	}
+	if g.mode == GuardError {
+		db = db.Limit(g.maxListRows + 1)
+	}
+	return db, g.maxListRows
+}
+
+// guardList 检查不分页的List返回的行数, max为boundList返回的上限
+func guardList[T any](ctx context.Context, g *Guard, db *gorm.DB, rows, max int) error {
+	if max <= 0 || rows <= max {
+		return nil
+	}
+	g = g.orDefault()
+	detail := fmt.Errorf("%d rows, limit %d", rows, max)
+	if g.mode == GuardError {
+		detail = fmt.Errorf("more than %d rows", max)
+	}
+	return g.report(ctx, db, &OpError{
+		Op:    GetOperationName(ctx),
+		Table: tableNameOf[T](db),
+		Kind:  ErrUnboundedRead,
+		Err:   detail,
+	})
+}
+
+// guardWrite 检查Update*/Delete*是否带有条件, 更新的值中的主键由write通过primaryKeyWhere附加到wheres
+//
+// 返回true表示允许不带条件执行, 由write开启gorm的AllowGlobalUpdate, 否则gorm会返回gorm.ErrMissingWhereClause
+func guardWrite[T any](ctx context.Context, g *Guard, db *gorm.DB, wheres []ScopeMethod) (bool, error) {
+	if hasWhere(db, wheres) {
+		return false, nil
+	}
+	g = g.orDefault()
+	if g.mode == GuardAllow || unboundedAllowed(db, wheres) {
+		return true, nil
+	}
+	err := g.report(ctx, db, &OpError{
+		Op:    GetOperationName(ctx),
+		Table: tableNameOf[T](db),
+		Kind:  ErrUnboundedWrite,
+		Err:   errors.New("use AllowUnbounded to update or delete all rows"),
+	})
+	return err == nil, err
+}
+
+// primaryKeyWhere 更新的值为*T且主键不为零时, 返回按主键限定的条件
+//
+// gorm在Model和更新的值不是同一个对象时会把主键写入SET而不是WHERE, 这里显式附加
+func primaryKeyWhere[T any](db *gorm.DB, values any) (ScopeMethod, bool) {
+	m, ok := values.(*T)
+	if !ok || m == nil {
+		return nil, false
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(m); err != nil || stmt.Schema.PrioritizedPrimaryField == nil {
+		return nil, false
+	}
+	field := stmt.Schema.PrioritizedPrimaryField
+	id, ok := primaryKeyOf[T](db)(m)
+	if !ok {
+		return nil, false
+	}
+	return WhereInColumn(field.DBName, id), true
+}
+
+// dryScopes 在DryRun会话上应用wheres, 用于在执行前检查条件和设置
+func dryScopes(db *gorm.DB, wheres []ScopeMethod) *gorm.DB {
+	tx := db.Session(&gorm.Session{DryRun: true})
+	for _, where := range wheres {
+		tx = where(tx)
+	}
+	return tx
+}
+
+// unboundedAllowed wheres中是否设置了AllowUnbounded
+func unboundedAllowed(db *gorm.DB, wheres []ScopeMethod) bool {
+	v, ok := dryScopes(db, wheres).Get(allowUnboundedSettingKey)
+	if !ok {
+		return false
+	}
+	allowed, _ := v.(bool)
+	return allowed
+}
+
+// hasWhere db和wheres是否产生了WHERE条件, 软删除条件在执行时才附加, 不算在内
+func hasWhere(db *gorm.DB, wheres []ScopeMethod) bool {
+	c, ok := dryScopes(db, wheres).Statement.Clauses["WHERE"]
+	if !ok {
+		return false
+	}
+	where, ok := c.Expression.(clause.Where)
+	return ok && len(where.Exprs) > 0
+}
@@ -0,0 +1,126 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestGuardWrite(t *testing.T) {
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		DSN:                       "root@tcp(127.0.0.1:3306)/test",
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{DisableAutomaticPing: true, Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithOperationName(context.Background(), "Delete")
+	db = db.Model(&User{}).Session(&gorm.Session{Context: ctx})
+
+	tests := []struct {
+		name   string
+		guard  *Guard
+		wheres []ScopeMethod
+		want   error
+	}{
+		{"empty ids", nil, []ScopeMethod{WhereID()}, ErrUnboundedWrite},
+		{"only trashed", nil, []ScopeMethod{WhereID(), WithTrashed}, ErrUnboundedWrite},
+		{"with id", nil, []ScopeMethod{WhereID(1)}, nil},
+		{"allow unbounded", nil, []ScopeMethod{WhereID(), AllowUnbounded}, nil},
+		{"log mode", NewGuard(WithGuardMode(GuardLog)), nil, nil},
+		{"allow mode", NewGuard(WithGuardMode(GuardAllow)), nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unbounded, err := guardWrite[User](ctx, tt.guard, db, tt.wheres)
+			if !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("guardWrite() = %v, want %v", err, tt.want)
+			}
+			if want := tt.want == nil && tt.name != "with id"; unbounded != want {
+				t.Fatalf("guardWrite() unbounded = %v, want %v", unbounded, want)
+			}
+		})
+	}
+
+	bounded, max := NewGuard(WithGuardMaxListRows(10)).boundList(db, nil, nil)
+	if max != 10 || bounded.Statement.Clauses["LIMIT"].Expression == nil {
+		t.Fatalf("boundList() max = %d, want 10 with limit", max)
+	}
+	if _, max := NewGuard(WithGuardMaxListRows(10)).boundList(db, nil, []ScopeMethod{AllowUnbounded}); max != 0 {
+		t.Fatalf("boundList() with AllowUnbounded max = %d, want 0", max)
+	}
+	if err := guardList[User](ctx, nil, db, 11, 10); !errors.Is(err, ErrUnboundedRead) {
+		t.Fatalf("guardList() = %v, want ErrUnboundedRead", err)
+	}
+}
+
+func TestParseGuardMode(t *testing.T) {
+	for _, mode := range []GuardMode{GuardError, GuardLog, GuardAllow} {
+		got, err := ParseGuardMode(" " + mode.String() + " ")
+		if err != nil || got != mode {
+			t.Fatalf("ParseGuardMode(%q) = %v, %v", mode, got, err)
+		}
+	}
+	if _, err := ParseGuardMode("strict"); err == nil {
+		t.Fatal("ParseGuardMode(strict) should fail")
+	}
+}
+
+func TestGuardWritePrimaryKey(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.on("UPDATE `users`").affects(1)
+	a := NewAction[User](WithDB[User](db))
+
+	user := &User{Name: "tom"}
+	if err := a.Update(user); !errors.Is(err, ErrUnboundedWrite) {
+		t.Fatalf("Update() without primary key = %v, want ErrUnboundedWrite", err)
+	}
+
+	user.ID = 7
+	if err := a.Update(user); err != nil {
+		t.Fatalf("Update() with primary key = %v", err)
+	}
+	stmts := fake.executed("UPDATE `users`")
+	if len(stmts) != 1 || !strings.Contains(stmts[0].SQL, "WHERE id = ?") || !containsArg(stmts[0].Args, uint32(7)) {
+		t.Fatalf("update = %v, want bound by the primary key", stmts)
+	}
+}
+
+func TestGuardWriteUnbounded(t *testing.T) {
+	tests := []struct {
+		name   string
+		guard  *Guard
+		wheres []ScopeMethod
+	}{
+		{"allow unbounded", nil, []ScopeMethod{AllowUnbounded}},
+		{"allow mode", NewGuard(WithGuardMode(GuardAllow)), nil},
+		{"log mode", NewGuard(WithGuardMode(GuardLog)), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t)
+			fake.on("UPDATE `users`").affects(3)
+			fake.on("DELETE FROM `users`").affects(3)
+			a := NewAction[User](WithDB[User](db), WithGuard[User](tt.guard))
+
+			// 允许的全表变更需要真正执行, 不能被gorm的ErrMissingWhereClause拦下
+			if rows, err := a.UpdateMapRows(map[string]any{"name": "new"}, tt.wheres...); err != nil || rows != 3 {
+				t.Fatalf("UpdateMapRows() = %d, %v, want 3 rows", rows, err)
+			}
+			if rows, err := a.DeleteRows(tt.wheres...); err != nil || rows != 3 {
+				t.Fatalf("DeleteRows() = %d, %v, want 3 rows", rows, err)
+			}
+			if rows, err := a.ForcedDeleteRows(tt.wheres...); err != nil || rows != 3 {
+				t.Fatalf("ForcedDeleteRows() = %d, %v, want 3 rows", rows, err)
+			}
+			if updates, deletes := fake.executed("UPDATE `users`"), fake.executed("DELETE FROM `users`"); len(updates) != 2 || len(deletes) != 1 {
+				t.Fatalf("updates %v, deletes %v, want both updates and the delete executed", updates, deletes)
+			}
+		})
+	}
+}
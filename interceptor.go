@@ -0,0 +1,590 @@
+package query
+
+import (
+	"context"
+)
+
+var _ IOperation[any] = (*interceptedOperation[any])(nil)
+var _ IOperationX[any] = (*interceptedOperationX[any])(nil)
+
+type (
+	// Invocation 一次操作调用的信息, 拦截器可以读取, 也可以修改后再交给next执行
+	Invocation struct {
+		// Operation 操作名称, 例如First、UpdateMapByIDX
+		Operation string
+		// Model 模型类型
+		Model string
+		// Table 表名
+		Table string
+		// ID ByID类操作的ID, 其他操作为0
+		ID uint32
+		// Scopes 调用方传入的条件
+		Scopes []ScopeMethod
+		// Pagination 分页信息, 只有List类操作有
+		Pagination Pagination
+		// Entity 写入的数据, *T、[]*T或者map[string]any
+		Entity any
+		// BatchSize 批量创建的批次大小
+		BatchSize int
+	}
+
+	// Invoker 执行操作, 返回操作结果(*T、[]*T、int64或nil)和错误
+	Invoker func(ctx context.Context, inv *Invocation) (any, error)
+
+	// Interceptor 拦截器, 在next前后处理横切逻辑, 也可以不调用next直接返回结果(短路)
+	//
+	// 短路时返回的结果类型需要和操作的返回类型一致, 否则调用方拿到的是零值
+	Interceptor func(ctx context.Context, inv *Invocation, next Invoker) (any, error)
+
+	// interceptorChain 拦截器链, 按注册顺序由外到内执行
+	//
+	// 拦截器传给next的上下文会用于执行操作, 替换上下文(例如设置超时、操作者)和替换调用信息一样生效
+	interceptorChain[T any] struct {
+		interceptors []Interceptor
+		bind         IBind[T]
+		ctx          ICtx
+	}
+
+	// interceptedOperation 在IOperation外包裹拦截器链
+	interceptedOperation[T any] struct {
+		*interceptorChain[T]
+		inner IOperation[T]
+	}
+
+	// interceptedOperationX 在IOperation外包裹拦截器链, 以X方法的形式暴露
+	//
+	// X方法基于内部的IOperation实现, 拦截器看到的错误就是X方法记录的错误, 记录到错误后后续的X方法不再进入拦截器链
+	interceptedOperationX[T any] struct {
+		*interceptorChain[T]
+		inner IOperation[T]
+		state *xState
+	}
+)
+
+// newInterceptorChain 创建拦截器链
+func newInterceptorChain[T any](bind IBind[T], ctx ICtx, interceptors ...Interceptor) *interceptorChain[T] {
+	return &interceptorChain[T]{
+		interceptors: interceptors,
+		bind:         bind,
+		ctx:          ctx,
+	}
+}
+
+// contextOperationBinder 可以创建绑定到指定上下文的操作, 由action实现
+type contextOperationBinder[T any] interface {
+	operationWithContext(ctx context.Context) IOperation[T]
+}
+
+// NewInterceptedOperation 使用拦截器包裹IOperation
+func NewInterceptedOperation[T any](inner IOperation[T], bind IBind[T], ctx ICtx, interceptors ...Interceptor) IOperation[T] {
+	return &interceptedOperation[T]{
+		interceptorChain: newInterceptorChain[T](bind, ctx, interceptors...),
+		inner:            inner,
+	}
+}
+
+// NewInterceptedOperationX 使用拦截器包裹IOperation, 并以X方法的形式暴露
+func NewInterceptedOperationX[T any](inner IOperation[T], bind IBind[T], ctx ICtx, interceptors ...Interceptor) IOperationX[T] {
+	return &interceptedOperationX[T]{
+		interceptorChain: newInterceptorChain[T](bind, ctx, interceptors...),
+		inner:            inner,
+		state:            newXState(),
+	}
+}
+
+// baseCtx 拦截器链开始时的上下文
+func (c *interceptorChain[T]) baseCtx() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx.GetCtx()
+}
+
+// operationAt 使用拦截器传下来的ctx执行的操作
+//
+// ctx没有被替换时直接使用inner, 否则由bind创建绑定到ctx的操作; bind不支持时只能使用inner, 上下文的替换不生效
+func (c *interceptorChain[T]) operationAt(ctx context.Context, inner IOperation[T]) IOperation[T] {
+	if ctx == nil || ctx == c.baseCtx() {
+		return inner
+	}
+	if b, ok := c.bind.(contextOperationBinder[T]); ok {
+		return b.operationWithContext(ctx)
+	}
+	return inner
+}
+
+// invoke 补全调用信息并执行拦截器链
+func (c *interceptorChain[T]) invoke(inv *Invocation, final Invoker) (any, error) {
+	inv.Model = modelType[T]().String()
+	if c.bind != nil {
+		inv.Table = tableNameOf[T](c.bind.DB())
+	}
+	ctx := c.baseCtx()
+
+	next := final
+	for i := len(c.interceptors) - 1; i >= 0; i-- {
+		interceptor, n := c.interceptors[i], next
+		next = func(ctx context.Context, inv *Invocation) (any, error) {
+			return interceptor(ctx, inv, n)
+		}
+	}
+	return next(ctx, inv)
+}
+
+// anyResult 把操作的返回值转换为Invoker的返回值
+func anyResult[R any](r R, err error) (any, error) {
+	return r, err
+}
+
+// intercept 通过拦截器链执行inv描述的操作, call使用拦截器传下来的上下文和调用信息执行内部操作
+//
+// 拦截器短路返回的结果类型和R不一致时, 返回R的零值
+func intercept[T, R any](c *interceptorChain[T], inner IOperation[T], inv *Invocation, call func(op IOperation[T], inv *Invocation) (R, error)) (R, error) {
+	res, err := c.invoke(inv, func(ctx context.Context, inv *Invocation) (any, error) {
+		return anyResult(call(c.operationAt(ctx, inner), inv))
+	})
+	r, _ := res.(R)
+	return r, err
+}
+
+// interceptX 以X方法的形式执行intercept, 已经记录错误时跳过, 错误以调用时的操作名称记录
+func interceptX[T, R any](o *interceptedOperationX[T], mutation bool, inv *Invocation, call func(op IOperation[T], inv *Invocation) (R, error)) R {
+	op := inv.Operation
+	if o.state.skip(op, mutation) {
+		var zero R
+		return zero
+	}
+	r, err := intercept(o.interceptorChain, o.inner, inv, call)
+	o.state.record(op, mutation, err)
+	return r
+}
+
+func (o *interceptedOperationX[T]) GetQueryErr() error {
+	return o.state.queryErr()
+}
+
+func (o *interceptedOperationX[T]) GetMutationErr() error {
+	return o.state.mutationErr()
+}
+
+func (o *interceptedOperationX[T]) Err() error {
+	return o.state.err()
+}
+
+func (o *interceptedOperationX[T]) ResetErr() {
+	o.state.reset()
+}
+
+func (o *interceptedOperationX[T]) Run(fn func(x IOperationX[T])) error {
+	x := o.withXState(newXState())
+	fn(x)
+	return x.Err()
+}
+
+// withXState 返回使用s记录错误的副本
+func (o *interceptedOperationX[T]) withXState(s *xState) IOperationX[T] {
+	x := *o
+	x.state = s
+	return &x
+}
+
+func (o *interceptedOperationX[T]) getXState() *xState {
+	return o.state
+}
+
+func (o *interceptedOperation[T]) First(wheres ...ScopeMethod) (*T, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "First", Scopes: wheres}, callFirst[T])
+}
+
+func (o *interceptedOperation[T]) FirstWithTrashed(wheres ...ScopeMethod) (*T, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "FirstWithTrashed", Scopes: wheres}, callFirstWithTrashed[T])
+}
+
+func (o *interceptedOperation[T]) FirstByID(id uint32, wheres ...ScopeMethod) (*T, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "FirstByID", ID: id, Scopes: wheres}, callFirstByID[T])
+}
+
+func (o *interceptedOperation[T]) FirstByIDWithTrashed(id uint32, wheres ...ScopeMethod) (*T, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "FirstByIDWithTrashed", ID: id, Scopes: wheres}, callFirstByIDWithTrashed[T])
+}
+
+func (o *interceptedOperation[T]) Last(wheres ...ScopeMethod) (*T, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "Last", Scopes: wheres}, callLast[T])
+}
+
+func (o *interceptedOperation[T]) LastWithTrashed(wheres ...ScopeMethod) (*T, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "LastWithTrashed", Scopes: wheres}, callLastWithTrashed[T])
+}
+
+func (o *interceptedOperation[T]) LastByID(id uint32, wheres ...ScopeMethod) (*T, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "LastByID", ID: id, Scopes: wheres}, callLastByID[T])
+}
+
+func (o *interceptedOperation[T]) LastByIDWithTrashed(id uint32, wheres ...ScopeMethod) (*T, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "LastByIDWithTrashed", ID: id, Scopes: wheres}, callLastByIDWithTrashed[T])
+}
+
+func (o *interceptedOperation[T]) List(pgInfo Pagination, wheres ...ScopeMethod) ([]*T, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "List", Pagination: pgInfo, Scopes: wheres}, callList[T])
+}
+
+func (o *interceptedOperation[T]) ListWithTrashed(pgInfo Pagination, wheres ...ScopeMethod) ([]*T, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "ListWithTrashed", Pagination: pgInfo, Scopes: wheres}, callListWithTrashed[T])
+}
+
+func (o *interceptedOperation[T]) Count(wheres ...ScopeMethod) (int64, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "Count", Scopes: wheres}, callCount[T])
+}
+
+func (o *interceptedOperation[T]) CountWithTrashed(wheres ...ScopeMethod) (int64, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "CountWithTrashed", Scopes: wheres}, callCountWithTrashed[T])
+}
+
+func (o *interceptedOperation[T]) Explain(wheres ...ScopeMethod) (*Plan, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "Explain", Scopes: wheres}, callExplain[T])
+}
+
+func (o *interceptedOperation[T]) ExplainList(pgInfo Pagination, wheres ...ScopeMethod) (*Plan, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "ExplainList", Pagination: pgInfo, Scopes: wheres}, callExplainList[T])
+}
+
+func (o *interceptedOperation[T]) Create(m *T) error {
+	_, err := intercept(o.interceptorChain, o.inner, &Invocation{Operation: "Create", Entity: m}, callCreate[T])
+	return err
+}
+
+func (o *interceptedOperation[T]) BatchCreate(m []*T, batchSize int) error {
+	_, err := intercept(o.interceptorChain, o.inner, &Invocation{Operation: "BatchCreate", Entity: m, BatchSize: batchSize}, callBatchCreate[T])
+	return err
+}
+
+func (o *interceptedOperation[T]) Update(m *T, wheres ...ScopeMethod) error {
+	_, err := intercept(o.interceptorChain, o.inner, &Invocation{Operation: "Update", Entity: m, Scopes: wheres}, callUpdate[T])
+	return err
+}
+
+func (o *interceptedOperation[T]) UpdateMap(m map[string]any, wheres ...ScopeMethod) error {
+	_, err := intercept(o.interceptorChain, o.inner, &Invocation{Operation: "UpdateMap", Entity: m, Scopes: wheres}, callUpdateMap[T])
+	return err
+}
+
+func (o *interceptedOperation[T]) UpdateByID(id uint32, m *T, wheres ...ScopeMethod) error {
+	_, err := intercept(o.interceptorChain, o.inner, &Invocation{Operation: "UpdateByID", ID: id, Entity: m, Scopes: wheres}, callUpdateByID[T])
+	return err
+}
+
+func (o *interceptedOperation[T]) UpdateMapByID(id uint32, m map[string]any, wheres ...ScopeMethod) error {
+	_, err := intercept(o.interceptorChain, o.inner, &Invocation{Operation: "UpdateMapByID", ID: id, Entity: m, Scopes: wheres}, callUpdateMapByID[T])
+	return err
+}
+
+func (o *interceptedOperation[T]) Delete(wheres ...ScopeMethod) error {
+	_, err := intercept(o.interceptorChain, o.inner, &Invocation{Operation: "Delete", Scopes: wheres}, callDelete[T])
+	return err
+}
+
+func (o *interceptedOperation[T]) DeleteByID(id uint32, wheres ...ScopeMethod) error {
+	_, err := intercept(o.interceptorChain, o.inner, &Invocation{Operation: "DeleteByID", ID: id, Scopes: wheres}, callDeleteByID[T])
+	return err
+}
+
+func (o *interceptedOperation[T]) ForcedDelete(wheres ...ScopeMethod) error {
+	_, err := intercept(o.interceptorChain, o.inner, &Invocation{Operation: "ForcedDelete", Scopes: wheres}, callForcedDelete[T])
+	return err
+}
+
+func (o *interceptedOperation[T]) ForcedDeleteByID(id uint32, wheres ...ScopeMethod) error {
+	_, err := intercept(o.interceptorChain, o.inner, &Invocation{Operation: "ForcedDeleteByID", ID: id, Scopes: wheres}, callForcedDeleteByID[T])
+	return err
+}
+
+func (o *interceptedOperation[T]) UpdateRows(m *T, wheres ...ScopeMethod) (int64, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "UpdateRows", Entity: m, Scopes: wheres}, callUpdateRows[T])
+}
+
+func (o *interceptedOperation[T]) UpdateMapRows(m map[string]any, wheres ...ScopeMethod) (int64, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "UpdateMapRows", Entity: m, Scopes: wheres}, callUpdateMapRows[T])
+}
+
+func (o *interceptedOperation[T]) UpdateByIDRows(id uint32, m *T, wheres ...ScopeMethod) (int64, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "UpdateByIDRows", ID: id, Entity: m, Scopes: wheres}, callUpdateByIDRows[T])
+}
+
+func (o *interceptedOperation[T]) UpdateMapByIDRows(id uint32, m map[string]any, wheres ...ScopeMethod) (int64, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "UpdateMapByIDRows", ID: id, Entity: m, Scopes: wheres}, callUpdateMapByIDRows[T])
+}
+
+func (o *interceptedOperation[T]) DeleteRows(wheres ...ScopeMethod) (int64, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "DeleteRows", Scopes: wheres}, callDeleteRows[T])
+}
+
+func (o *interceptedOperation[T]) DeleteByIDRows(id uint32, wheres ...ScopeMethod) (int64, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "DeleteByIDRows", ID: id, Scopes: wheres}, callDeleteByIDRows[T])
+}
+
+func (o *interceptedOperation[T]) ForcedDeleteRows(wheres ...ScopeMethod) (int64, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "ForcedDeleteRows", Scopes: wheres}, callForcedDeleteRows[T])
+}
+
+func (o *interceptedOperation[T]) ForcedDeleteByIDRows(id uint32, wheres ...ScopeMethod) (int64, error) {
+	return intercept(o.interceptorChain, o.inner, &Invocation{Operation: "ForcedDeleteByIDRows", ID: id, Scopes: wheres}, callForcedDeleteByIDRows[T])
+}
+
+func (o *interceptedOperationX[T]) FirstX(wheres ...ScopeMethod) *T {
+	return interceptX(o, false, &Invocation{Operation: "FirstX", Scopes: wheres}, callFirst[T])
+}
+
+func (o *interceptedOperationX[T]) FirstWithTrashedX(wheres ...ScopeMethod) *T {
+	return interceptX(o, false, &Invocation{Operation: "FirstWithTrashedX", Scopes: wheres}, callFirstWithTrashed[T])
+}
+
+func (o *interceptedOperationX[T]) FirstByIDX(id uint32, wheres ...ScopeMethod) *T {
+	return interceptX(o, false, &Invocation{Operation: "FirstByIDX", ID: id, Scopes: wheres}, callFirstByID[T])
+}
+
+func (o *interceptedOperationX[T]) FirstByIDWithTrashedX(id uint32, wheres ...ScopeMethod) *T {
+	return interceptX(o, false, &Invocation{Operation: "FirstByIDWithTrashedX", ID: id, Scopes: wheres}, callFirstByIDWithTrashed[T])
+}
+
+func (o *interceptedOperationX[T]) LastX(wheres ...ScopeMethod) *T {
+	return interceptX(o, false, &Invocation{Operation: "LastX", Scopes: wheres}, callLast[T])
+}
+
+func (o *interceptedOperationX[T]) LastWithTrashedX(wheres ...ScopeMethod) *T {
+	return interceptX(o, false, &Invocation{Operation: "LastWithTrashedX", Scopes: wheres}, callLastWithTrashed[T])
+}
+
+func (o *interceptedOperationX[T]) LastByIDX(id uint32, wheres ...ScopeMethod) *T {
+	return interceptX(o, false, &Invocation{Operation: "LastByIDX", ID: id, Scopes: wheres}, callLastByID[T])
+}
+
+func (o *interceptedOperationX[T]) LastByIDWithTrashedX(id uint32, wheres ...ScopeMethod) *T {
+	return interceptX(o, false, &Invocation{Operation: "LastByIDWithTrashedX", ID: id, Scopes: wheres}, callLastByIDWithTrashed[T])
+}
+
+func (o *interceptedOperationX[T]) ListX(pgInfo Pagination, wheres ...ScopeMethod) []*T {
+	return interceptX(o, false, &Invocation{Operation: "ListX", Pagination: pgInfo, Scopes: wheres}, callList[T])
+}
+
+func (o *interceptedOperationX[T]) ListWithTrashedX(pgInfo Pagination, wheres ...ScopeMethod) []*T {
+	return interceptX(o, false, &Invocation{Operation: "ListWithTrashedX", Pagination: pgInfo, Scopes: wheres}, callListWithTrashed[T])
+}
+
+func (o *interceptedOperationX[T]) CountX(wheres ...ScopeMethod) int64 {
+	return interceptX(o, false, &Invocation{Operation: "CountX", Scopes: wheres}, callCount[T])
+}
+
+func (o *interceptedOperationX[T]) CountWithTrashedX(wheres ...ScopeMethod) int64 {
+	return interceptX(o, false, &Invocation{Operation: "CountWithTrashedX", Scopes: wheres}, callCountWithTrashed[T])
+}
+
+func (o *interceptedOperationX[T]) CreateX(m *T) {
+	interceptX(o, true, &Invocation{Operation: "CreateX", Entity: m}, callCreate[T])
+}
+
+func (o *interceptedOperationX[T]) BatchCreateX(m []*T, batchSize int) {
+	interceptX(o, true, &Invocation{Operation: "BatchCreateX", Entity: m, BatchSize: batchSize}, callBatchCreate[T])
+}
+
+func (o *interceptedOperationX[T]) UpdateX(m *T, wheres ...ScopeMethod) {
+	interceptX(o, true, &Invocation{Operation: "UpdateX", Entity: m, Scopes: wheres}, callUpdate[T])
+}
+
+func (o *interceptedOperationX[T]) UpdateMapX(m map[string]any, wheres ...ScopeMethod) {
+	interceptX(o, true, &Invocation{Operation: "UpdateMapX", Entity: m, Scopes: wheres}, callUpdateMap[T])
+}
+
+func (o *interceptedOperationX[T]) UpdateByIDX(id uint32, m *T, wheres ...ScopeMethod) {
+	interceptX(o, true, &Invocation{Operation: "UpdateByIDX", ID: id, Entity: m, Scopes: wheres}, callUpdateByID[T])
+}
+
+func (o *interceptedOperationX[T]) UpdateMapByIDX(id uint32, m map[string]any, wheres ...ScopeMethod) {
+	interceptX(o, true, &Invocation{Operation: "UpdateMapByIDX", ID: id, Entity: m, Scopes: wheres}, callUpdateMapByID[T])
+}
+
+func (o *interceptedOperationX[T]) DeleteX(wheres ...ScopeMethod) {
+	interceptX(o, true, &Invocation{Operation: "DeleteX", Scopes: wheres}, callDelete[T])
+}
+
+func (o *interceptedOperationX[T]) DeleteByIDX(id uint32, wheres ...ScopeMethod) {
+	interceptX(o, true, &Invocation{Operation: "DeleteByIDX", ID: id, Scopes: wheres}, callDeleteByID[T])
+}
+
+func (o *interceptedOperationX[T]) ForcedDeleteX(wheres ...ScopeMethod) {
+	interceptX(o, true, &Invocation{Operation: "ForcedDeleteX", Scopes: wheres}, callForcedDelete[T])
+}
+
+func (o *interceptedOperationX[T]) ForcedDeleteByIDX(id uint32, wheres ...ScopeMethod) {
+	interceptX(o, true, &Invocation{Operation: "ForcedDeleteByIDX", ID: id, Scopes: wheres}, callForcedDeleteByID[T])
+}
+
+func (o *interceptedOperationX[T]) UpdateRowsX(m *T, wheres ...ScopeMethod) int64 {
+	return interceptX(o, true, &Invocation{Operation: "UpdateRowsX", Entity: m, Scopes: wheres}, callUpdateRows[T])
+}
+
+func (o *interceptedOperationX[T]) UpdateMapRowsX(m map[string]any, wheres ...ScopeMethod) int64 {
+	return interceptX(o, true, &Invocation{Operation: "UpdateMapRowsX", Entity: m, Scopes: wheres}, callUpdateMapRows[T])
+}
+
+func (o *interceptedOperationX[T]) UpdateByIDRowsX(id uint32, m *T, wheres ...ScopeMethod) int64 {
+	return interceptX(o, true, &Invocation{Operation: "UpdateByIDRowsX", ID: id, Entity: m, Scopes: wheres}, callUpdateByIDRows[T])
+}
+
+func (o *interceptedOperationX[T]) UpdateMapByIDRowsX(id uint32, m map[string]any, wheres ...ScopeMethod) int64 {
+	return interceptX(o, true, &Invocation{Operation: "UpdateMapByIDRowsX", ID: id, Entity: m, Scopes: wheres}, callUpdateMapByIDRows[T])
+}
+
+func (o *interceptedOperationX[T]) DeleteRowsX(wheres ...ScopeMethod) int64 {
+	return interceptX(o, true, &Invocation{Operation: "DeleteRowsX", Scopes: wheres}, callDeleteRows[T])
+}
+
+func (o *interceptedOperationX[T]) DeleteByIDRowsX(id uint32, wheres ...ScopeMethod) int64 {
+	return interceptX(o, true, &Invocation{Operation: "DeleteByIDRowsX", ID: id, Scopes: wheres}, callDeleteByIDRows[T])
+}
+
+func (o *interceptedOperationX[T]) ForcedDeleteRowsX(wheres ...ScopeMethod) int64 {
+	return interceptX(o, true, &Invocation{Operation: "ForcedDeleteRowsX", Scopes: wheres}, callForcedDeleteRows[T])
+}
+
+func (o *interceptedOperationX[T]) ForcedDeleteByIDRowsX(id uint32, wheres ...ScopeMethod) int64 {
+	return interceptX(o, true, &Invocation{Operation: "ForcedDeleteByIDRowsX", ID: id, Scopes: wheres}, callForcedDeleteByIDRows[T])
+}
+
+// callFirst 按调用信息执行内部操作, 和下面的callXxx一样由IOperation和X方法的包裹共用
+func callFirst[T any](op IOperation[T], inv *Invocation) (*T, error) {
+	return op.First(inv.Scopes...)
+}
+
+func callFirstWithTrashed[T any](op IOperation[T], inv *Invocation) (*T, error) {
+	return op.FirstWithTrashed(inv.Scopes...)
+}
+
+func callFirstByID[T any](op IOperation[T], inv *Invocation) (*T, error) {
+	return op.FirstByID(inv.ID, inv.Scopes...)
+}
+
+func callFirstByIDWithTrashed[T any](op IOperation[T], inv *Invocation) (*T, error) {
+	return op.FirstByIDWithTrashed(inv.ID, inv.Scopes...)
+}
+
+func callLast[T any](op IOperation[T], inv *Invocation) (*T, error) {
+	return op.Last(inv.Scopes...)
+}
+
+func callLastWithTrashed[T any](op IOperation[T], inv *Invocation) (*T, error) {
+	return op.LastWithTrashed(inv.Scopes...)
+}
+
+func callLastByID[T any](op IOperation[T], inv *Invocation) (*T, error) {
+	return op.LastByID(inv.ID, inv.Scopes...)
+}
+
+func callLastByIDWithTrashed[T any](op IOperation[T], inv *Invocation) (*T, error) {
+	return op.LastByIDWithTrashed(inv.ID, inv.Scopes...)
+}
+
+func callList[T any](op IOperation[T], inv *Invocation) ([]*T, error) {
+	return op.List(inv.Pagination, inv.Scopes...)
+}
+
+func callListWithTrashed[T any](op IOperation[T], inv *Invocation) ([]*T, error) {
+	return op.ListWithTrashed(inv.Pagination, inv.Scopes...)
+}
+
+func callCount[T any](op IOperation[T], inv *Invocation) (int64, error) {
+	return op.Count(inv.Scopes...)
+}
+
+func callCountWithTrashed[T any](op IOperation[T], inv *Invocation) (int64, error) {
+	return op.CountWithTrashed(inv.Scopes...)
+}
+
+func callExplain[T any](op IOperation[T], inv *Invocation) (*Plan, error) {
+	return op.Explain(inv.Scopes...)
+}
+
+func callExplainList[T any](op IOperation[T], inv *Invocation) (*Plan, error) {
+	return op.ExplainList(inv.Pagination, inv.Scopes...)
+}
+
+func callCreate[T any](op IOperation[T], inv *Invocation) (any, error) {
+	entity, _ := inv.Entity.(*T)
+	return nil, op.Create(entity)
+}
+
+func callBatchCreate[T any](op IOperation[T], inv *Invocation) (any, error) {
+	entities, _ := inv.Entity.([]*T)
+	return nil, op.BatchCreate(entities, inv.BatchSize)
+}
+
+func callUpdate[T any](op IOperation[T], inv *Invocation) (any, error) {
+	entity, _ := inv.Entity.(*T)
+	return nil, op.Update(entity, inv.Scopes...)
+}
+
+func callUpdateMap[T any](op IOperation[T], inv *Invocation) (any, error) {
+	values, _ := inv.Entity.(map[string]any)
+	return nil, op.UpdateMap(values, inv.Scopes...)
+}
+
+func callUpdateByID[T any](op IOperation[T], inv *Invocation) (any, error) {
+	entity, _ := inv.Entity.(*T)
+	return nil, op.UpdateByID(inv.ID, entity, inv.Scopes...)
+}
+
+func callUpdateMapByID[T any](op IOperation[T], inv *Invocation) (any, error) {
+	values, _ := inv.Entity.(map[string]any)
+	return nil, op.UpdateMapByID(inv.ID, values, inv.Scopes...)
+}
+
+func callDelete[T any](op IOperation[T], inv *Invocation) (any, error) {
+	return nil, op.Delete(inv.Scopes...)
+}
+
+func callDeleteByID[T any](op IOperation[T], inv *Invocation) (any, error) {
+	return nil, op.DeleteByID(inv.ID, inv.Scopes...)
+}
+
+func callForcedDelete[T any](op IOperation[T], inv *Invocation) (any, error) {
+	return nil, op.ForcedDelete(inv.Scopes...)
+}
+
+func callForcedDeleteByID[T any](op IOperation[T], inv *Invocation) (any, error) {
+	return nil, op.ForcedDeleteByID(inv.ID, inv.Scopes...)
+}
+
+func callUpdateRows[T any](op IOperation[T], inv *Invocation) (int64, error) {
+	entity, _ := inv.Entity.(*T)
+	return op.UpdateRows(entity, inv.Scopes...)
+}
+
+func callUpdateMapRows[T any](op IOperation[T], inv *Invocation) (int64, error) {
+	values, _ := inv.Entity.(map[string]any)
+	return op.UpdateMapRows(values, inv.Scopes...)
+}
+
+func callUpdateByIDRows[T any](op IOperation[T], inv *Invocation) (int64, error) {
+	entity, _ := inv.Entity.(*T)
+	return op.UpdateByIDRows(inv.ID, entity, inv.Scopes...)
+}
+
+func callUpdateMapByIDRows[T any](op IOperation[T], inv *Invocation) (int64, error) {
+	values, _ := inv.Entity.(map[string]any)
+	return op.UpdateMapByIDRows(inv.ID, values, inv.Scopes...)
+}
+
+func callDeleteRows[T any](op IOperation[T], inv *Invocation) (int64, error) {
+	return op.DeleteRows(inv.Scopes...)
+}
+
+func callDeleteByIDRows[T any](op IOperation[T], inv *Invocation) (int64, error) {
+	return op.DeleteByIDRows(inv.ID, inv.Scopes...)
+}
+
+func callForcedDeleteRows[T any](op IOperation[T], inv *Invocation) (int64, error) {
+	return op.ForcedDeleteRows(inv.Scopes...)
+}
+
+func callForcedDeleteByIDRows[T any](op IOperation[T], inv *Invocation) (int64, error) {
+	return op.ForcedDeleteByIDRows(inv.ID, inv.Scopes...)
+}
@@ -0,0 +1,90 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestInterceptorContext(t *testing.T) {
+	RegisterPolicy[policyDoc](PolicyFunc(ownerPolicy))
+	defer ResetPolicy[policyDoc]()
+
+	db, fake := newFakeDB(t)
+	// 拦截器设置操作者, 行级策略从操作实际使用的上下文中读取
+	a := NewAction[policyDoc](WithDB[policyDoc](db), WithInterceptors[policyDoc](func(ctx context.Context, inv *Invocation, next Invoker) (any, error) {
+		return next(WithActor(ctx, uint32(7)), inv)
+	}))
+	if _, err := a.Count(); err != nil {
+		t.Fatalf("Count() = %v", err)
+	}
+	x := a.WithContext(context.Background())
+	if x.DeleteX(WhereID(1)); x.Err() != nil {
+		t.Fatalf("DeleteX() = %v", x.Err())
+	}
+	for _, prefix := range []string{"SELECT count(*)", "DELETE"} {
+		stmts := fake.executed(prefix)
+		if len(stmts) != 1 || !strings.Contains(stmts[0].SQL, "owner_id = ?") {
+			t.Fatalf("%s should use the actor set by the interceptor: %+v", prefix, stmts)
+		}
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	b := NewAction[User](WithDB[User](db), WithInterceptors[User](func(ctx context.Context, inv *Invocation, next Invoker) (any, error) {
+		return next(canceled, inv)
+	}))
+	if _, err := b.First(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("First() = %v, want context.Canceled from the interceptor context", err)
+	}
+	if stmts := fake.executed("FROM `users`"); len(stmts) != 0 {
+		t.Fatalf("canceled operation executed %v", stmts)
+	}
+}
+
+func TestInterceptorScopes(t *testing.T) {
+	db, fake := newFakeDB(t)
+	a := NewAction[User](WithDB[User](db), WithInterceptors[User](func(ctx context.Context, inv *Invocation, next Invoker) (any, error) {
+		inv.Scopes = append(inv.Scopes, WhereID(5))
+		return next(ctx, inv)
+	}))
+	_, _ = a.List(nil)
+	a.CountX()
+	stmts := fake.executed("FROM `users`")
+	if len(stmts) != 2 {
+		t.Fatalf("executed %v, want List and CountX", stmts)
+	}
+	for _, stmt := range stmts {
+		if !strings.Contains(stmt.SQL, "WHERE id = ?") || stmt.Args[0] != uint32(5) {
+			t.Errorf("statement without the interceptor scope: %s %v", stmt.SQL, stmt.Args)
+		}
+	}
+}
+
+func TestInterceptorShortCircuit(t *testing.T) {
+	db, fake := newFakeDB(t)
+	cached := &User{Name: "cached"}
+	a := NewAction[User](WithDB[User](db), WithInterceptors[User](func(ctx context.Context, inv *Invocation, next Invoker) (any, error) {
+		switch inv.Operation {
+		case "FirstByID", "FirstByIDX":
+			return cached, nil
+		case "Delete":
+			return nil, ErrPermissionDenied
+		}
+		return next(ctx, inv)
+	}))
+
+	if m, err := a.FirstByID(1); err != nil || m != cached {
+		t.Fatalf("FirstByID() = %+v, %v, want the short-circuit result", m, err)
+	}
+	if x := a.WithContext(context.Background()); x.FirstByIDX(1) != cached || x.Err() != nil {
+		t.Fatalf("FirstByIDX() err = %v, want the short-circuit result", x.Err())
+	}
+	if err := a.Delete(WhereID(1)); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("Delete() = %v, want the short-circuit error", err)
+	}
+	if stmts := fake.executed(""); len(stmts) != 0 {
+		t.Fatalf("short-circuited operations executed %v", stmts)
+	}
+}
@@ -0,0 +1,222 @@
+package query
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// EntityEventKind 实体生命周期事件类型
+type EntityEventKind int8
+
+const (
+	// OnCreated Create/BatchCreate之后
+	OnCreated EntityEventKind = iota + 1
+	// OnUpdated Update*之后
+	OnUpdated
+	// OnDeleted Delete*/ForcedDelete*之后
+	OnDeleted
+	// OnRestored 通过UpdateMap把软删除字段置为零值之后
+	OnRestored
+)
+
+type (
+	// EntityEvent 实体生命周期事件
+	EntityEvent[T any] struct {
+		Kind EntityEventKind
+		// Entities 新增的实体, 或者Update/UpdateByID传入的实体
+		Entities []*T
+		// IDs 受影响的ID, Create时为新增数据的ID
+		IDs []uint32
+		// Values UpdateMap传入的字段
+		Values map[string]any
+	}
+
+	// EntityEventHandler 事件处理, 同步执行时返回错误会回滚本次变更
+	EntityEventHandler[T any] func(ctx context.Context, event *EntityEvent[T]) error
+
+	SubscribeOption func(*subscription)
+
+	subscription struct {
+		id          uint64
+		handler     any
+		afterCommit bool
+	}
+)
+
+var (
+	subscriptionMutex sync.RWMutex
+	subscriptionSeq   uint64
+	subscriptions     = make(map[reflect.Type]map[EntityEventKind][]*subscription)
+)
+
+// String 事件名称
+func (k EntityEventKind) String() string {
+	switch k {
+	case OnCreated:
+		return "created"
+	case OnUpdated:
+		return "updated"
+	case OnDeleted:
+		return "deleted"
+	case OnRestored:
+		return "restored"
+	default:
+		return "unknown"
+	}
+}
+
+// WithAfterCommit 事务提交后再执行, 返回的错误被忽略; 不在事务中时变更成功后立即执行, 在gorm原生的事务中变更返回ErrNativeTransaction
+func WithAfterCommit() SubscribeOption {
+	return func(s *subscription) {
+		s.afterCommit = true
+	}
+}
+
+// Subscribe 订阅模型T的生命周期事件, 默认在变更所在的事务中同步执行, 返回取消订阅的函数
+func Subscribe[T any](kind EntityEventKind, handler EntityEventHandler[T], opts ...SubscribeOption) (unsubscribe func()) {
+	s := &subscription{handler: handler}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	typ := modelType[T]()
+	subscriptionMutex.Lock()
+	defer subscriptionMutex.Unlock()
+	subscriptionSeq++
+	s.id = subscriptionSeq
+	if subscriptions[typ] == nil {
+		subscriptions[typ] = make(map[EntityEventKind][]*subscription)
+	}
+	subscriptions[typ][kind] = append(subscriptions[typ][kind], s)
+
+	return func() {
+		subscriptionMutex.Lock()
+		defer subscriptionMutex.Unlock()
+		subs := subscriptions[typ][kind]
+		for i, sub := range subs {
+			if sub.id == s.id {
+				subscriptions[typ][kind] = append(subs[:i:i], subs[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// hasSubscriptions 模型T是否订阅了kinds中的任意一种事件
+func hasSubscriptions[T any](kinds ...EntityEventKind) bool {
+	typ := modelType[T]()
+	subscriptionMutex.RLock()
+	defer subscriptionMutex.RUnlock()
+	for _, kind := range kinds {
+		if len(subscriptions[typ][kind]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// publishEntityEvent 发布事件, 同步订阅立即执行, 提交后订阅注册到事务的提交后回调
+func publishEntityEvent[T any](ctx context.Context, tx *gorm.DB, event *EntityEvent[T]) error {
+	subscriptionMutex.RLock()
+	subs := append([]*subscription(nil), subscriptions[modelType[T]()][event.Kind]...)
+	subscriptionMutex.RUnlock()
+
+	for _, s := range subs {
+		handler := s.handler.(EntityEventHandler[T])
+		if s.afterCommit {
+			if err := afterCommit(tx, func() { _ = handler(ctx, event) }); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := handler(ctx, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lifecycleCreate 新增并发布OnCreated
+func lifecycleCreate[T any](ctx context.Context, db *gorm.DB, ms []*T, exec func(tx *gorm.DB) error) error {
+	if !hasSubscriptions[T](OnCreated) {
+		return exec(db)
+	}
+	return transaction(db, func(tx *gorm.DB) error {
+		if err := exec(tx); err != nil {
+			return err
+		}
+		pk := primaryKeyOf[T](tx)
+		ids := make([]uint32, 0, len(ms))
+		for _, m := range ms {
+			if id, ok := pk(m); ok {
+				ids = append(ids, id)
+			}
+		}
+		return publishEntityEvent(ctx, tx, &EntityEvent[T]{Kind: OnCreated, Entities: ms, IDs: ids})
+	})
+}
+
+// lifecycleWrite 更新或删除并发布事件, 受影响的ID在变更前按wheres和策略条件读取
+func lifecycleWrite[T any](ctx context.Context, db *gorm.DB, action string, kind PolicyKind, wheres []ScopeMethod, values any, exec func(tx *gorm.DB) error) error {
+	event := &EntityEvent[T]{Kind: OnDeleted}
+	if action == AuditActionUpdate {
+		event.Kind = OnUpdated
+		switch v := values.(type) {
+		case *T:
+			event.Entities = []*T{v}
+		case map[string]any:
+			event.Values = v
+			if isRestore[T](db, v) {
+				event.Kind = OnRestored
+			}
+		}
+	}
+	if !hasSubscriptions[T](event.Kind) {
+		return exec(db)
+	}
+
+	policies, err := policyScopes[T](ctx, kind)
+	if err != nil {
+		return err
+	}
+	return transaction(db, func(tx *gorm.DB) error {
+		var ms []*T
+		if err := tx.Scopes(wheres...).Scopes(policies...).Find(&ms).Error; err != nil {
+			return err
+		}
+		pk := primaryKeyOf[T](tx)
+		for _, m := range ms {
+			if id, ok := pk(m); ok {
+				event.IDs = append(event.IDs, id)
+			}
+		}
+		if err := exec(tx); err != nil {
+			return err
+		}
+		return publishEntityEvent(ctx, tx, event)
+	})
+}
+
+// isRestore values是否把软删除字段置为零值
+func isRestore[T any](db *gorm.DB, values map[string]any) bool {
+	var m T
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&m); err != nil {
+		return false
+	}
+	for _, field := range stmt.Schema.Fields {
+		if _, ok := reflect.New(field.FieldType).Interface().(schema.DeleteClausesInterface); !ok {
+			continue
+		}
+		for _, key := range []string{field.DBName, field.Name} {
+			if v, ok := values[key]; ok {
+				return v == nil || reflect.ValueOf(v).IsZero()
+			}
+		}
+	}
+	return false
+}
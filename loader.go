@@ -0,0 +1,223 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLoaderWait     = 2 * time.Millisecond
+	defaultLoaderMaxBatch = 100
+)
+
+type (
+	// Loader 按ID批量加载, 解决逐条FirstByID带来的N+1问题
+	//
+	// 在wait时间窗口内(或达到maxBatch)的Load调用会合并为一次 WHERE id IN (...) 查询
+	//
+	// 批次使用第一个调用方的上下文执行(不随调用方取消), 行级策略的操作者不同的调用不会合并到同一批次
+	Loader[T any] struct {
+		action   IAction[T]
+		wait     time.Duration
+		maxBatch int
+
+		mu      sync.Mutex
+		batches map[any]*loaderBatch[T]
+
+		pkOnce sync.Once
+		pk     func(m *T) (uint32, bool)
+	}
+
+	LoaderOption[T any] func(*Loader[T])
+
+	loaderBatch[T any] struct {
+		ctx     context.Context
+		ids     []uint32
+		keys    map[uint32]struct{}
+		done    chan struct{}
+		results map[uint32]*T
+		err     error
+	}
+
+	// loaderMemo 请求级别的缓存, 同一个请求内相同ID只加载一次, 加载失败的批次不缓存
+	loaderMemo struct {
+		mu sync.Mutex
+		m  map[loaderMemoKey]any
+	}
+
+	loaderMemoKey struct {
+		loader any
+		id     uint32
+	}
+
+	loaderMemoCtxKey struct{}
+)
+
+// NewLoader 创建批量加载器, 默认窗口2ms, 单批最多100个ID
+func NewLoader[T any](action IAction[T], opts ...LoaderOption[T]) *Loader[T] {
+	l := &Loader[T]{
+		action:   action,
+		wait:     defaultLoaderWait,
+		maxBatch: defaultLoaderMaxBatch,
+		batches:  make(map[any]*loaderBatch[T]),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// WithLoaderWait 设置合并窗口
+func WithLoaderWait[T any](wait time.Duration) LoaderOption[T] {
+	return func(l *Loader[T]) {
+		l.wait = wait
+	}
+}
+
+// WithLoaderMaxBatch 设置单批最大ID数量
+func WithLoaderMaxBatch[T any](maxBatch int) LoaderOption[T] {
+	return func(l *Loader[T]) {
+		if maxBatch > 0 {
+			l.maxBatch = maxBatch
+		}
+	}
+}
+
+// WithLoaderMemo 在上下文中开启请求级别的缓存, 一般在请求入口处调用
+func WithLoaderMemo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loaderMemoCtxKey{}, &loaderMemo{m: make(map[loaderMemoKey]any)})
+}
+
+// Load 加载单条数据, 数据不存在时返回ErrNotFound, 同时满足errors.Is(err, gorm.ErrRecordNotFound)
+func (l *Loader[T]) Load(ctx context.Context, id uint32) (*T, error) {
+	batch := l.batchFor(ctx, id)
+	select {
+	case <-batch.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if batch.err != nil {
+		return nil, batch.err
+	}
+	m, ok := batch.results[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: id %d", notFoundError(WithOperationName(ctx, "Load"), tableNameOf[T](l.action.DB())), id)
+	}
+	return cloneEntity(m), nil
+}
+
+// LoadMany 加载多条数据, 返回结果和错误与ids一一对应
+func (l *Loader[T]) LoadMany(ctx context.Context, ids ...uint32) ([]*T, []error) {
+	ms := make([]*T, len(ids))
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id uint32) {
+			defer wg.Done()
+			ms[i], errs[i] = l.Load(ctx, id)
+		}(i, id)
+	}
+	wg.Wait()
+	return ms, errs
+}
+
+// batchFor 获取id所在的批次, 优先使用请求级别的缓存
+func (l *Loader[T]) batchFor(ctx context.Context, id uint32) *loaderBatch[T] {
+	memo, _ := ctx.Value(loaderMemoCtxKey{}).(*loaderMemo)
+	if memo == nil {
+		return l.enqueue(ctx, id)
+	}
+
+	key := loaderMemoKey{loader: l, id: id}
+	memo.mu.Lock()
+	defer memo.mu.Unlock()
+	if batch, ok := memo.m[key].(*loaderBatch[T]); ok && !batch.failed() {
+		return batch
+	}
+	batch := l.enqueue(ctx, id)
+	memo.m[key] = batch
+	return batch
+}
+
+// failed 批次是否已经执行完并且失败, 失败可能是暂时的, 之后的调用需要重新加载
+func (b *loaderBatch[T]) failed() bool {
+	select {
+	case <-b.done:
+		return b.err != nil
+	default:
+		return false
+	}
+}
+
+// enqueue 把id加入ctx对应的当前批次, 达到maxBatch时立即执行
+func (l *Loader[T]) enqueue(ctx context.Context, id uint32) *loaderBatch[T] {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := loaderBatchKey(ctx)
+	batch := l.batches[key]
+	if batch == nil {
+		batch = &loaderBatch[T]{
+			ctx:  context.WithoutCancel(ctx),
+			keys: make(map[uint32]struct{}),
+			done: make(chan struct{}),
+		}
+		l.batches[key] = batch
+		time.AfterFunc(l.wait, func() {
+			l.mu.Lock()
+			if l.batches[key] != batch {
+				l.mu.Unlock()
+				return
+			}
+			delete(l.batches, key)
+			l.mu.Unlock()
+			l.dispatch(batch)
+		})
+	}
+
+	if _, ok := batch.keys[id]; !ok {
+		batch.keys[id] = struct{}{}
+		batch.ids = append(batch.ids, id)
+	}
+	if len(batch.ids) >= l.maxBatch {
+		delete(l.batches, key)
+		go l.dispatch(batch)
+	}
+	return batch
+}
+
+// loaderBatchKey 批次的分组, 按操作者分组, 操作者不可比较时不和其他调用合并
+func loaderBatchKey(ctx context.Context) any {
+	actor, ok := GetActor(ctx)
+	if !ok {
+		return nil
+	}
+	if reflect.ValueOf(actor).Comparable() {
+		return actor
+	}
+	return ctx
+}
+
+// dispatch 执行一次批量查询, 并把结果按ID分发
+func (l *Loader[T]) dispatch(batch *loaderBatch[T]) {
+	defer close(batch.done)
+
+	// 批量的大小已经由maxBatch限制, 不受List的行数上限约束
+	ms, err := l.action.WithContext(batch.ctx).List(nil, WhereID(batch.ids...), AllowUnbounded)
+	if err != nil {
+		batch.err = err
+		return
+	}
+
+	l.pkOnce.Do(func() { l.pk = primaryKeyOf[T](l.action.DB()) })
+	batch.results = make(map[uint32]*T, len(ms))
+	for _, m := range ms {
+		if id, ok := l.pk(m); ok {
+			batch.results[id] = m
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// newLoaderDB 返回id为1到3的用户
+func newLoaderDB(t *testing.T) (IAction[User], *fakeDB) {
+	db, fake := newFakeDB(t)
+	fake.on("FROM `users`").returns([]string{"id", "name"},
+		[]driver.Value{int64(1), "a"}, []driver.Value{int64(2), "b"}, []driver.Value{int64(3), "c"})
+	return NewAction[User](WithDB[User](db)), fake
+}
+
+// loadedIDs 每次批量查询的ID
+func loadedIDs(fake *fakeDB) [][]uint32 {
+	var batches [][]uint32
+	for _, stmt := range fake.executed("FROM `users`") {
+		var ids []uint32
+		for _, arg := range stmt.Args {
+			if id, ok := arg.(uint32); ok {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		batches = append(batches, ids)
+	}
+	return batches
+}
+
+func TestLoaderBatch(t *testing.T) {
+	a, fake := newLoaderDB(t)
+	l := NewLoader[User](a, WithLoaderWait[User](10*time.Millisecond))
+
+	ms, errs := l.LoadMany(context.Background(), 3, 1, 2, 1)
+	for i, want := range []string{"c", "a", "b", "a"} {
+		if errs[i] != nil || ms[i].Name != want {
+			t.Fatalf("LoadMany()[%d] = %+v, %v, want %s", i, ms[i], errs[i], want)
+		}
+	}
+	if ms[1] == ms[3] {
+		t.Fatal("callers loading the same id should get copies")
+	}
+	if batches := loadedIDs(fake); len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("batches = %v, want one query for ids 1, 2, 3", batches)
+	}
+}
+
+func TestLoaderMaxBatch(t *testing.T) {
+	a, fake := newLoaderDB(t)
+	// 窗口足够长, 只有达到maxBatch才会执行
+	l := NewLoader[User](a, WithLoaderWait[User](time.Hour), WithLoaderMaxBatch[User](2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, errs := l.LoadMany(ctx, 1, 2, 3, 4)
+	for i, err := range errs[:3] {
+		if err != nil {
+			t.Fatalf("LoadMany()[%d] = %v", i, err)
+		}
+	}
+	if !errors.Is(errs[3], ErrNotFound) {
+		t.Fatalf("LoadMany()[3] = %v, want ErrNotFound", errs[3])
+	}
+	batches := loadedIDs(fake)
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 2 {
+		t.Fatalf("batches = %v, want two full batches", batches)
+	}
+}
+
+func TestLoaderMemo(t *testing.T) {
+	a, fake := newLoaderDB(t)
+	l := NewLoader[User](a, WithLoaderWait[User](time.Millisecond))
+
+	ctx := WithLoaderMemo(context.Background())
+	first, err := l.Load(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := l.Load(ctx, 1)
+	if err != nil || second == first || second.Name != "a" {
+		t.Fatalf("memoized Load() = %+v, %v, want a copy of %+v", second, err, first)
+	}
+	if got := len(loadedIDs(fake)); got != 1 {
+		t.Fatalf("memoized loads executed %d queries, want 1", got)
+	}
+
+	// 没有请求级别缓存的上下文每次都会查询
+	if _, err := l.Load(context.Background(), 1); err != nil || len(loadedIDs(fake)) != 2 {
+		t.Fatalf("Load() without memo = %v, queries = %d, want 2", err, len(loadedIDs(fake)))
+	}
+
+	// 失败的批次不缓存, 之后的调用重新加载
+	errTransient := errors.New("transient")
+	fake.on("FROM `users`").fails(errTransient)
+	if _, err := l.Load(ctx, 2); !errors.Is(err, errTransient) {
+		t.Fatalf("Load() = %v, want the transient error", err)
+	}
+	fake.on("FROM `users`").returns([]string{"id", "name"}, []driver.Value{int64(2), "b"})
+	if m, err := l.Load(ctx, 2); err != nil || m.Name != "b" {
+		t.Fatalf("Load() after a failed batch = %+v, %v, want it reloaded", m, err)
+	}
+}
+
+func TestLoaderActorBatch(t *testing.T) {
+	a, fake := newLoaderDB(t)
+	l := NewLoader[User](a, WithLoaderWait[User](10*time.Millisecond))
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for _, c := range []struct {
+		actor any
+		id    uint32
+	}{{uint32(7), 1}, {uint32(7), 2}, {uint32(8), 3}} {
+		wg.Add(1)
+		go func(actor any, id uint32) {
+			defer wg.Done()
+			if _, err := l.Load(WithActor(ctx, actor), id); err != nil {
+				t.Errorf("Load(%d) = %v", id, err)
+			}
+		}(c.actor, c.id)
+	}
+	wg.Wait()
+
+	batches := loadedIDs(fake)
+	sort.Slice(batches, func(i, j int) bool { return len(batches[i]) > len(batches[j]) })
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 || batches[1][0] != 3 {
+		t.Fatalf("batches = %v, want actors batched separately", batches)
+	}
+}
+
+func TestLoaderNotFound(t *testing.T) {
+	a, _ := newLoaderDB(t)
+	l := NewLoader[User](a, WithLoaderWait[User](time.Millisecond))
+
+	_, err := l.Load(context.Background(), 9)
+	var opErr *OpError
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, gorm.ErrRecordNotFound) || !errors.As(err, &opErr) || opErr.Op != "Load" {
+		t.Fatalf("Load() = %v, want not found error for Load", err)
+	}
+}
@@ -0,0 +1,288 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"gorm.io/gorm"
+)
+
+const gormMetricsTime = "__gorm_metrics_time"
+
+const (
+	metricsBeforeName = "metrics:before"
+	metricsAfterName  = "metrics:after"
+
+	metricsInstrumentationName = "github.com/aide-cloud/gorm-normalize"
+)
+
+const (
+	// OperationKindCreate 新增
+	OperationKindCreate = "create"
+	// OperationKindQuery 查询
+	OperationKindQuery = "query"
+	// OperationKindUpdate 更新
+	OperationKindUpdate = "update"
+	// OperationKindDelete 删除
+	OperationKindDelete = "delete"
+	// OperationKindRow Row/Rows
+	OperationKindRow = "row"
+	// OperationKindRaw Exec/Raw
+	OperationKindRaw = "raw"
+)
+
+// durationBuckets db.client.operation.duration的桶边界(秒), 和数据库客户端语义约定推荐的一致
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+var _ gorm.Plugin = (*MetricsPlugin)(nil)
+
+type (
+	// MetricsPlugin 基于otel metric的指标插件, 记录耗时、影响行数、错误数和连接池状态
+	MetricsPlugin struct {
+		meterProvider metric.MeterProvider
+		classifier    func(err error) string
+
+		duration     metric.Float64Histogram
+		rowsAffected metric.Int64Counter
+		errorCount   metric.Int64Counter
+		registration metric.Registration
+	}
+
+	MetricsOption func(*MetricsPlugin)
+)
+
+// NewMetricsPlugin 创建指标插件, 默认使用全局MeterProvider
+func NewMetricsPlugin(opts ...MetricsOption) *MetricsPlugin {
+	p := &MetricsPlugin{}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// WithMeterProvider 设置MeterProvider
+func WithMeterProvider(mp metric.MeterProvider) MetricsOption {
+	return func(p *MetricsPlugin) {
+		p.meterProvider = mp
+	}
+}
+
+// WithErrorClassifier 设置错误分类, 分类结果作为error.type标签, 默认使用ClassifyError
+func WithErrorClassifier(classifier func(err error) string) MetricsOption {
+	return func(p *MetricsPlugin) {
+		if classifier != nil {
+			p.classifier = classifier
+		}
+	}
+}
+
+// ErrorClass 错误分类, 不知道方言时依次尝试所有已注册的错误转换
+func ErrorClass(err error) string {
+	return ClassifyError(nil, err)
+}
+
+// ClassifyError 按db的方言分类错误, 未设置WithErrorClassifier时作为error.type标签
+func ClassifyError(db *gorm.DB, err error) string {
+	if err == nil {
+		return ""
+	}
+	kind, _ := TranslateError(db, err)
+	switch {
+	case kind == nil:
+	case errors.Is(kind, ErrNotFound):
+		return "not_found"
+	case errors.Is(kind, ErrDuplicateKey):
+		return "duplicate_key"
+	case errors.Is(kind, ErrForeignKeyViolation):
+		return "foreign_key_violation"
+	case errors.Is(kind, ErrDeadlock):
+		return "deadlock"
+	case errors.Is(kind, ErrLockTimeout):
+		return "lock_timeout"
+	case errors.Is(kind, ErrSerializationFailure):
+		return "serialization_failure"
+	case errors.Is(kind, ErrDataTooLong):
+		return "data_too_long"
+	case errors.Is(kind, ErrConnection):
+		return "connection"
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	default:
+		return "other"
+	}
+}
+
+func (p *MetricsPlugin) Name() string {
+	return "metricsPlugin"
+}
+
+func (p *MetricsPlugin) Initialize(db *gorm.DB) (err error) {
+	mp := p.meterProvider
+	if mp == nil {
+		mp = otel.GetMeterProvider()
+	}
+	meter := mp.Meter(metricsInstrumentationName)
+
+	if p.duration, err = meter.Float64Histogram("db.client.operation.duration",
+		metric.WithDescription("Duration of database client operations."),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(durationBuckets...),
+	); err != nil {
+		return err
+	}
+	if p.rowsAffected, err = meter.Int64Counter("db.client.rows_affected",
+		metric.WithDescription("Number of rows affected or returned by database client operations."),
+		metric.WithUnit("{row}"),
+	); err != nil {
+		return err
+	}
+	if p.errorCount, err = meter.Int64Counter("db.client.errors",
+		metric.WithDescription("Number of failed database client operations."),
+		metric.WithUnit("{error}"),
+	); err != nil {
+		return err
+	}
+	if err = p.registerPoolMetrics(meter, db); err != nil {
+		return err
+	}
+
+	cb := db.Callback()
+	if err = cb.Create().Before("gorm:before_create").Register(metricsBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Query().Before("gorm:query").Register(metricsBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Delete().Before("gorm:before_delete").Register(metricsBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Update().Before("gorm:setup_reflect_value").Register(metricsBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Row().Before("gorm:row").Register(metricsBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Raw().Before("gorm:raw").Register(metricsBeforeName, p.before); err != nil {
+		return err
+	}
+
+	if err = cb.Create().After("gorm:after_create").Register(metricsAfterName, p.after(OperationKindCreate)); err != nil {
+		return err
+	}
+	if err = cb.Query().After("gorm:after_query").Register(metricsAfterName, p.after(OperationKindQuery)); err != nil {
+		return err
+	}
+	if err = cb.Delete().After("gorm:after_delete").Register(metricsAfterName, p.after(OperationKindDelete)); err != nil {
+		return err
+	}
+	if err = cb.Update().After("gorm:after_update").Register(metricsAfterName, p.after(OperationKindUpdate)); err != nil {
+		return err
+	}
+	if err = cb.Row().After("gorm:row").Register(metricsAfterName, p.after(OperationKindRow)); err != nil {
+		return err
+	}
+	if err = cb.Raw().After("gorm:raw").Register(metricsAfterName, p.after(OperationKindRaw)); err != nil {
+		return err
+	}
+	return
+}
+
+// registerPoolMetrics 注册连接池指标, 连接不是*sql.DB时跳过
+func (p *MetricsPlugin) registerPoolMetrics(meter metric.Meter, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil
+	}
+
+	maxOpen, err := meter.Int64ObservableGauge("db.client.connections.max", metric.WithUnit("{connection}"))
+	if err != nil {
+		return err
+	}
+	usage, err := meter.Int64ObservableGauge("db.client.connections.usage", metric.WithUnit("{connection}"))
+	if err != nil {
+		return err
+	}
+	waitCount, err := meter.Int64ObservableCounter("db.client.connections.wait_count", metric.WithUnit("{wait}"))
+	if err != nil {
+		return err
+	}
+	waitDuration, err := meter.Float64ObservableCounter("db.client.connections.wait_time", metric.WithUnit("s"))
+	if err != nil {
+		return err
+	}
+	closed, err := meter.Int64ObservableCounter("db.client.connections.closed", metric.WithUnit("{connection}"))
+	if err != nil {
+		return err
+	}
+
+	p.registration, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		stats := sqlDB.Stats()
+		o.ObserveInt64(maxOpen, int64(stats.MaxOpenConnections))
+		o.ObserveInt64(usage, int64(stats.InUse), metric.WithAttributes(attribute.String("state", "used")))
+		o.ObserveInt64(usage, int64(stats.Idle), metric.WithAttributes(attribute.String("state", "idle")))
+		o.ObserveInt64(waitCount, stats.WaitCount)
+		o.ObserveFloat64(waitDuration, stats.WaitDuration.Seconds())
+		o.ObserveInt64(closed, stats.MaxIdleClosed, metric.WithAttributes(attribute.String("reason", "max_idle")))
+		o.ObserveInt64(closed, stats.MaxIdleTimeClosed, metric.WithAttributes(attribute.String("reason", "max_idle_time")))
+		o.ObserveInt64(closed, stats.MaxLifetimeClosed, metric.WithAttributes(attribute.String("reason", "max_lifetime")))
+		return nil
+	}, maxOpen, usage, waitCount, waitDuration, closed)
+	return err
+}
+
+// Close 注销连接池指标的回调
+func (p *MetricsPlugin) Close() error {
+	if p.registration == nil {
+		return nil
+	}
+	return p.registration.Unregister()
+}
+
+func (p *MetricsPlugin) before(db *gorm.DB) {
+	if db.DryRun {
+		return
+	}
+	db.InstanceSet(gormMetricsTime, time.Now())
+}
+
+func (p *MetricsPlugin) after(kind string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		_start, isExist := db.InstanceGet(gormMetricsTime)
+		if !isExist {
+			return
+		}
+		start, ok := _start.(time.Time)
+		if !ok {
+			return
+		}
+
+		ctx := db.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		attrs := []attribute.KeyValue{
+			attribute.String("db.sql.table", db.Statement.Table),
+			attribute.String("db.operation", kind),
+		}
+		if db.Error != nil {
+			class := ClassifyError(db, db.Error)
+			if p.classifier != nil {
+				class = p.classifier(db.Error)
+			}
+			attrs = append(attrs, attribute.String("error.type", class))
+			p.errorCount.Add(ctx, 1, metric.WithAttributes(attrs...))
+		}
+		p.duration.Record(ctx, time.Since(start).Seconds(), metric.WithAttributes(attrs...))
+		if db.RowsAffected > 0 {
+			p.rowsAffected.Add(ctx, db.RowsAffected, metric.WithAttributes(attrs...))
+		}
+	}
+}
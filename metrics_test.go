@@ -0,0 +1,53 @@
+package query
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestMetricsPlugin(t *testing.T) {
+	db, _ := newFakeDB(t)
+	reader := metric.NewManualReader()
+	plugin := NewMetricsPlugin(WithMeterProvider(metric.NewMeterProvider(metric.WithReader(reader))))
+	if err := db.Use(plugin); err != nil {
+		t.Fatal(err)
+	}
+	defer plugin.Close()
+
+	if _, err := NewAction[User]().WithDB(db).Count(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewAction[User]().WithDB(db).FirstByID(0); err == nil {
+		t.Fatal("FirstByID(0) should return not found")
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			found[m.Name] = true
+			if m.Name != "db.client.operation.duration" {
+				continue
+			}
+			hist, ok := m.Data.(metricdata.Histogram[float64])
+			if !ok || len(hist.DataPoints) == 0 {
+				t.Fatalf("duration data = %T, want float64 histogram", m.Data)
+			}
+			if bounds := hist.DataPoints[0].Bounds; !reflect.DeepEqual(bounds, durationBuckets) {
+				t.Fatalf("duration bounds = %v, want %v", bounds, durationBuckets)
+			}
+		}
+	}
+	for _, name := range []string{"db.client.operation.duration", "db.client.errors", "db.client.connections.usage"} {
+		if !found[name] {
+			t.Fatalf("metric %s not recorded", name)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package query
 
 import (
+	"context"
+	"reflect"
 	"time"
 
+	"gorm.io/gorm"
 	"gorm.io/plugin/soft_delete"
 )
 
@@ -12,3 +15,43 @@ type BaseModel struct {
 	UpdatedAt time.Time             `gorm:"column:updated_at;type:timestamp;not null;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP;comment:更新时间" json:"updatedAt"`
 	DeletedAt soft_delete.DeletedAt `gorm:"column:deleted_at;type:bigint;not null;default:0;" json:"deletedAt"`
 }
+
+// tableNameOf 获取db对应的表名, 未通过Table指定时从模型T解析
+func tableNameOf[T any](db *gorm.DB) string {
+	if db.Statement.Table != "" {
+		return db.Statement.Table
+	}
+	var m T
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&m); err != nil {
+		return ""
+	}
+	return stmt.Schema.Table
+}
+
+// primaryKeyOf 解析模型T的主键, 返回的函数用于读取实体的主键值, 主键不是整数时总是返回false
+func primaryKeyOf[T any](db *gorm.DB) func(m *T) (uint32, bool) {
+	none := func(*T) (uint32, bool) { return 0, false }
+
+	var m T
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&m); err != nil || stmt.Schema.PrioritizedPrimaryField == nil {
+		return none
+	}
+	field := stmt.Schema.PrioritizedPrimaryField
+	return func(m *T) (uint32, bool) {
+		v, isZero := field.ValueOf(context.Background(), reflect.ValueOf(m).Elem())
+		if isZero {
+			return 0, false
+		}
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return uint32(rv.Int()), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return uint32(rv.Uint()), true
+		default:
+			return 0, false
+		}
+	}
+}
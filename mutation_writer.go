@@ -0,0 +1,87 @@
+package query
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// mutationWriter 变更的执行流程, 组合校验、审计、发件箱和生命周期事件, IOperationMutation和IOperationMutationX共用
+//
+// 需要事务时通过transaction开启, 嵌套的各层共用最外层事务和提交后回调, 设置了重试策略时整个流程作为一个整体重试
+type mutationWriter[T any] struct {
+	auditor *Auditor
+	outbox  *Outbox
+	retry   *RetryPolicy
+	guard   *Guard
+}
+
+// create 新增, ms为待新增的数据, batch为true时校验错误带上数据下标
+func (w *mutationWriter[T]) create(ctx context.Context, db *gorm.DB, ms []*T, batch bool, exec func(tx *gorm.DB) error) error {
+	if err := validateEntities(ctx, ms, batch); err != nil {
+		return err
+	}
+	if db.DryRun {
+		return exec(db)
+	}
+	err := w.retry.mutate(ctx, db, func(db *gorm.DB) error {
+		return outboxWrite(ctx, w.outbox, db, func(tx *gorm.DB) error {
+			return auditCreate(ctx, w.auditor, tx, ms, func(tx *gorm.DB) error {
+				return lifecycleCreate(ctx, tx, ms, exec)
+			})
+		})
+	})
+	return translateKnown[T](ctx, db, err)
+}
+
+// write 更新或删除并返回影响的行数, values为更新的值, exec只需执行变更, wheres和策略条件由execWithPolicy附加
+//
+// values为带主键的*T时按主键限定; wheres中设置了ExpectRows/ErrIfNoRows时在事务中执行, 行数不符合预期时回滚; 没有任何条件时由guard决定是否执行, 允许时开启AllowGlobalUpdate;
+// DryRun时只渲染变更本身的语句
+func (w *mutationWriter[T]) write(ctx context.Context, db *gorm.DB, action string, kind PolicyKind, wheres []ScopeMethod, values any, exec func(tx *gorm.DB) *gorm.DB) (int64, error) {
+	if err := validateUpdate[T](ctx, values); err != nil {
+		return 0, err
+	}
+	if pk, ok := primaryKeyWhere[T](db, values); ok {
+		wheres = append(wheres[:len(wheres):len(wheres)], pk)
+	}
+	unbounded, err := guardWrite[T](ctx, w.guard, db, wheres)
+	if err != nil {
+		return 0, err
+	}
+	if unbounded {
+		db = db.Session(&gorm.Session{AllowGlobalUpdate: true})
+	}
+	if db.DryRun {
+		return execWithPolicy[T](ctx, db, kind, wheres, exec)
+	}
+	expected, expect := expectedRows(db, wheres)
+	var rows int64
+	run := func(tx *gorm.DB) error {
+		return lifecycleWrite[T](ctx, tx, action, kind, wheres, values, func(tx *gorm.DB) error {
+			n, err := execWithPolicy[T](ctx, tx, kind, wheres, exec)
+			if err != nil {
+				return err
+			}
+			rows = n
+			if expect {
+				return checkRows[T](ctx, tx, expected, n)
+			}
+			return nil
+		})
+	}
+	err = w.retry.mutate(ctx, db, func(db *gorm.DB) error {
+		return outboxWrite(ctx, w.outbox, db, func(tx *gorm.DB) error {
+			return auditWrite[T](ctx, w.auditor, tx, action, kind, wheres, func(tx *gorm.DB) error {
+				if expect {
+					return transaction(tx, run)
+				}
+				return run(tx)
+			})
+		})
+	})
+	if err != nil {
+		return 0, translateKnown[T](ctx, db, err)
+	}
+	return rows, nil
+}
@@ -26,6 +26,11 @@ type IOperationQuery[T any] interface {
 	Count(wheres ...ScopeMethod) (int64, error)
 	// CountWithTrashed 查询数量(包含软删除数据)
 	CountWithTrashed(wheres ...ScopeMethod) (int64, error)
+
+	// Explain 查询多条数据的执行计划
+	Explain(wheres ...ScopeMethod) (*Plan, error)
+	// ExplainList 分页查询的执行计划, 和List实际执行的分页语句一致
+	ExplainList(pgInfo Pagination, wheres ...ScopeMethod) (*Plan, error)
 }
 
 // IOperationMutation 变更操作, 返回error
@@ -50,6 +55,23 @@ type IOperationMutation[T any] interface {
 	ForcedDelete(wheres ...ScopeMethod) error
 	// ForcedDeleteByID 根据ID强制删除数据
 	ForcedDeleteByID(id uint32, wheres ...ScopeMethod) error
+
+	// UpdateRows 更新数据, 返回影响的行数
+	UpdateRows(m *T, wheres ...ScopeMethod) (int64, error)
+	// UpdateMapRows 通过map更新数据, 返回影响的行数
+	UpdateMapRows(m map[string]any, wheres ...ScopeMethod) (int64, error)
+	// UpdateByIDRows 根据ID更新数据, 返回影响的行数
+	UpdateByIDRows(id uint32, m *T, wheres ...ScopeMethod) (int64, error)
+	// UpdateMapByIDRows 根据ID更新数据, 返回影响的行数
+	UpdateMapByIDRows(id uint32, m map[string]any, wheres ...ScopeMethod) (int64, error)
+	// DeleteRows 删除数据, 返回影响的行数
+	DeleteRows(wheres ...ScopeMethod) (int64, error)
+	// DeleteByIDRows 根据ID删除数据, 返回影响的行数
+	DeleteByIDRows(id uint32, wheres ...ScopeMethod) (int64, error)
+	// ForcedDeleteRows 强制删除数据, 返回影响的行数
+	ForcedDeleteRows(wheres ...ScopeMethod) (int64, error)
+	// ForcedDeleteByIDRows 根据ID强制删除数据, 返回影响的行数
+	ForcedDeleteByIDRows(id uint32, wheres ...ScopeMethod) (int64, error)
 }
 
 type IOperation[T any] interface {
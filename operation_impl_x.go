@@ -1,9 +1,13 @@
 package query
 
+import "errors"
+
 type (
 	operationXImpl[T any] struct {
 		IOperationQueryX[T]
 		IOperationMutationX[T]
+
+		state *xState
 	}
 
 	OperationXImplOption[T any] func(*operationXImpl[T])
@@ -11,13 +15,15 @@ type (
 
 // defaultOperationXImpl 默认操作实现
 func defaultOperationXImpl[T any]() *operationXImpl[T] {
+	state := newXState()
 	return &operationXImpl[T]{
-		IOperationQueryX:    NewOperationQueryX[T](),
-		IOperationMutationX: NewOperationMutationX[T](),
+		IOperationQueryX:    bindXState[IOperationQueryX[T]](NewOperationQueryX[T](), state),
+		IOperationMutationX: bindXState[IOperationMutationX[T]](NewOperationMutationX[T](), state),
+		state:               state,
 	}
 }
 
-// NewOperationXImpl 实例化操作
+// NewOperationXImpl 实例化操作, 查询和变更共享错误状态
 func NewOperationXImpl[T any](opts ...OperationXImplOption[T]) IOperationX[T] {
 	o := defaultOperationXImpl[T]()
 	for _, opt := range opts {
@@ -26,24 +32,49 @@ func NewOperationXImpl[T any](opts ...OperationXImplOption[T]) IOperationX[T] {
 	return o
 }
 
-// Err 错误
+// Err 按记录顺序合并的全部错误, 自定义的查询或变更不使用共享的错误状态时, 查询错误在前
 func (o *operationXImpl[T]) Err() error {
-	if o.IOperationQueryX.GetQueryErr() != nil {
-		return o.IOperationQueryX.GetQueryErr()
+	if usesXState(o.IOperationQueryX, o.state) && usesXState(o.IOperationMutationX, o.state) {
+		return o.state.err()
+	}
+	return errors.Join(o.IOperationQueryX.GetQueryErr(), o.IOperationMutationX.GetMutationErr())
+}
+
+// ResetErr 清空已记录的错误, 自定义的查询或变更不使用共享的错误状态, 不受影响
+func (o *operationXImpl[T]) ResetErr() {
+	o.state.reset()
+}
+
+// Run 在独立的错误状态中执行fn, 返回fn中记录的全部错误
+func (o *operationXImpl[T]) Run(fn func(x IOperationX[T])) error {
+	x := o.withXState(newXState())
+	fn(x)
+	return x.Err()
+}
+
+// withXState 返回查询和变更都使用s记录错误的副本
+func (o *operationXImpl[T]) withXState(s *xState) IOperationX[T] {
+	return &operationXImpl[T]{
+		IOperationQueryX:    bindXState(o.IOperationQueryX, s),
+		IOperationMutationX: bindXState(o.IOperationMutationX, s),
+		state:               s,
 	}
-	return o.IOperationMutationX.GetMutationErr()
 }
 
-// WithOperationQueryX 设置查询
+func (o *operationXImpl[T]) getXState() *xState {
+	return o.state
+}
+
+// WithOperationQueryX 设置查询, 支持时使用共享的错误状态
 func WithOperationQueryX[T any](query IOperationQueryX[T]) OperationXImplOption[T] {
 	return func(o *operationXImpl[T]) {
-		o.IOperationQueryX = query
+		o.IOperationQueryX = bindXState(query, o.state)
 	}
 }
 
-// WithOperationMutationX 设置变更
+// WithOperationMutationX 设置变更, 支持时使用共享的错误状态
 func WithOperationMutationX[T any](mutation IOperationMutationX[T]) OperationXImplOption[T] {
 	return func(o *operationXImpl[T]) {
-		o.IOperationMutationX = mutation
+		o.IOperationMutationX = bindXState(mutation, o.state)
 	}
 }
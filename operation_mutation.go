@@ -1,7 +1,10 @@
 package query
 
 import (
+	"context"
+
 	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
 )
 
 type (
@@ -9,20 +12,30 @@ type (
 		IBind[T]
 		Tracer
 		ICtx
+
+		entityCache *EntityCache
+		queryCache  *QueryCache
+		writer      mutationWriter[T]
+		timeouts    Timeouts
 	}
 
 	OperationMutationOption[T any] func(*operationMutation[T])
 )
 
 func (l *operationMutation[T]) Create(m *T) error {
-	ctx := l.GetCtx()
+	ctx := WithOperationName(l.GetCtx(), "Create")
 	if l.IsEnableTrace() {
 		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "Create")
 		defer span.End()
 		ctx = _ctx
 	}
+	ctx, cancel := withTimeout(ctx, l.timeouts.Write)
+	defer cancel()
+	defer l.invalidateCreated(ctx, []*T{m})
 
-	return l.DB().WithContext(ctx).Create(m).Error
+	return l.writer.create(ctx, operationDB(ctx, l.DB()), []*T{m}, false, func(tx *gorm.DB) error {
+		return translateResult[T](ctx, tx.Create(m))
+	})
 }
 
 func (l *operationMutation[T]) BatchCreate(m []*T, batchSize int) error {
@@ -34,64 +47,158 @@ func (l *operationMutation[T]) BatchCreate(m []*T, batchSize int) error {
 		batchSize = 1000
 	}
 
-	ctx := l.GetCtx()
+	ctx := WithOperationName(l.GetCtx(), "BatchCreate")
 	if l.IsEnableTrace() {
 		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "BatchCreate")
 		defer span.End()
 		ctx = _ctx
 	}
-	return l.DB().WithContext(ctx).CreateInBatches(m, batchSize).Error
+	ctx, cancel := withTimeout(ctx, l.timeouts.Batch)
+	defer cancel()
+	defer l.invalidateCreated(ctx, m)
+	return l.writer.create(ctx, operationDB(ctx, l.DB()), m, true, func(tx *gorm.DB) error {
+		return translateResult[T](ctx, tx.CreateInBatches(m, batchSize))
+	})
 }
 
 func (l *operationMutation[T]) Update(m *T, wheres ...ScopeMethod) error {
-	ctx := l.GetCtx()
-	if l.IsEnableTrace() {
-		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "Update")
-		defer span.End()
-		ctx = _ctx
-	}
-	return l.DB().WithContext(ctx).Scopes(wheres...).Updates(m).Error
+	_, err := l.UpdateRows(m, wheres...)
+	return err
+}
+
+func (l *operationMutation[T]) UpdateRows(m *T, wheres ...ScopeMethod) (int64, error) {
+	return l.updates("Update", m, nil, wheres...)
 }
 
 func (l *operationMutation[T]) UpdateMap(m map[string]any, wheres ...ScopeMethod) error {
-	ctx := l.GetCtx()
-	if l.IsEnableTrace() {
-		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "UpdateMap")
-		defer span.End()
-		ctx = _ctx
-	}
-	return l.DB().WithContext(ctx).Scopes(wheres...).Updates(m).Error
+	_, err := l.UpdateMapRows(m, wheres...)
+	return err
+}
+
+func (l *operationMutation[T]) UpdateMapRows(m map[string]any, wheres ...ScopeMethod) (int64, error) {
+	return l.updates("UpdateMap", m, nil, wheres...)
 }
 
 func (l *operationMutation[T]) UpdateByID(id uint32, m *T, wheres ...ScopeMethod) error {
-	return l.Update(m, append(wheres, WhereID(id))...)
+	_, err := l.UpdateByIDRows(id, m, wheres...)
+	return err
+}
+
+func (l *operationMutation[T]) UpdateByIDRows(id uint32, m *T, wheres ...ScopeMethod) (int64, error) {
+	return l.updates("Update", m, []uint32{id}, append(wheres, WhereID(id))...)
 }
 
 func (l *operationMutation[T]) UpdateMapByID(id uint32, m map[string]any, wheres ...ScopeMethod) error {
-	return l.UpdateMap(m, append(wheres, WhereID(id))...)
+	_, err := l.UpdateMapByIDRows(id, m, wheres...)
+	return err
+}
+
+func (l *operationMutation[T]) UpdateMapByIDRows(id uint32, m map[string]any, wheres ...ScopeMethod) (int64, error) {
+	return l.updates("UpdateMap", m, []uint32{id}, append(wheres, WhereID(id))...)
 }
 
 func (l *operationMutation[T]) Delete(wheres ...ScopeMethod) error {
-	ctx := l.GetCtx()
+	_, err := l.DeleteRows(wheres...)
+	return err
+}
+
+func (l *operationMutation[T]) DeleteRows(wheres ...ScopeMethod) (int64, error) {
+	return l.delete("Delete", nil, wheres...)
+}
+
+func (l *operationMutation[T]) DeleteByID(id uint32, wheres ...ScopeMethod) error {
+	_, err := l.DeleteByIDRows(id, wheres...)
+	return err
+}
+
+func (l *operationMutation[T]) DeleteByIDRows(id uint32, wheres ...ScopeMethod) (int64, error) {
+	return l.delete("Delete", []uint32{id}, append(wheres, WhereID(id))...)
+}
+
+func (l *operationMutation[T]) ForcedDelete(wheres ...ScopeMethod) error {
+	_, err := l.ForcedDeleteRows(wheres...)
+	return err
+}
+
+func (l *operationMutation[T]) ForcedDeleteRows(wheres ...ScopeMethod) (int64, error) {
+	return l.delete("Delete", nil, append(wheres, WithTrashed)...)
+}
+
+func (l *operationMutation[T]) ForcedDeleteByID(id uint32, wheres ...ScopeMethod) error {
+	_, err := l.ForcedDeleteByIDRows(id, wheres...)
+	return err
+}
+
+func (l *operationMutation[T]) ForcedDeleteByIDRows(id uint32, wheres ...ScopeMethod) (int64, error) {
+	return l.delete("Delete", []uint32{id}, append(wheres, WhereID(id), WithTrashed)...)
+}
+
+// updates 更新数据并返回影响的行数, ids不为空时只失效对应ID的缓存, 否则失效整张表的缓存
+func (l *operationMutation[T]) updates(spanName string, values any, ids []uint32, wheres ...ScopeMethod) (int64, error) {
+	ctx := WithOperationName(l.GetCtx(), spanName)
 	if l.IsEnableTrace() {
-		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "Delete")
+		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, spanName)
 		defer span.End()
 		ctx = _ctx
 	}
-	var m T
-	return l.DB().WithContext(ctx).Scopes(wheres...).Delete(&m).Error
+	ctx, cancel := withTimeout(ctx, l.timeouts.Write)
+	defer cancel()
+	defer l.invalidate(ctx, ids)
+	return l.writer.write(ctx, operationDB(ctx, l.DB()), AuditActionUpdate, PolicyUpdate, wheres, values, func(tx *gorm.DB) *gorm.DB {
+		return tx.Updates(values)
+	})
 }
 
-func (l *operationMutation[T]) DeleteByID(id uint32, wheres ...ScopeMethod) error {
-	return l.Delete(append(wheres, WhereID(id))...)
+// delete 删除数据并返回影响的行数, ids不为空时只失效对应ID的缓存, 否则失效整张表的缓存
+func (l *operationMutation[T]) delete(spanName string, ids []uint32, wheres ...ScopeMethod) (int64, error) {
+	ctx := WithOperationName(l.GetCtx(), spanName)
+	if l.IsEnableTrace() {
+		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, spanName)
+		defer span.End()
+		ctx = _ctx
+	}
+	ctx, cancel := withTimeout(ctx, l.timeouts.Write)
+	defer cancel()
+	defer l.invalidate(ctx, ids)
+	var m T
+	return l.writer.write(ctx, operationDB(ctx, l.DB()), AuditActionDelete, PolicyDelete, wheres, nil, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&m)
+	})
 }
 
-func (l *operationMutation[T]) ForcedDelete(wheres ...ScopeMethod) error {
-	return l.Delete(append(wheres, WithTrashed)...)
+// invalidate 失效缓存, ids不为空时实体缓存只失效对应ID, 结果缓存总是失效整张表
+func (l *operationMutation[T]) invalidate(ctx context.Context, ids []uint32) {
+	if (l.entityCache == nil && l.queryCache == nil) || l.DB().DryRun {
+		return
+	}
+	table := tableNameOf[T](l.DB())
+	invalidateAfterCommit(ctx, l.DB(), func(ctx context.Context) {
+		l.entityCache.Invalidate(ctx, table, ids...)
+		l.queryCache.Invalidate(ctx, table)
+	})
 }
 
-func (l *operationMutation[T]) ForcedDeleteByID(id uint32, wheres ...ScopeMethod) error {
-	return l.Delete(append(wheres, WhereID(id), WithTrashed)...)
+// invalidateCreated 失效新增数据的缓存, 实体缓存只失效新增的ID(可能有负缓存), 结果缓存失效整张表
+func (l *operationMutation[T]) invalidateCreated(ctx context.Context, ms []*T) {
+	if (l.entityCache == nil && l.queryCache == nil) || l.DB().DryRun {
+		return
+	}
+	table := tableNameOf[T](l.DB())
+	var ids []uint32
+	if l.entityCache != nil {
+		pk := primaryKeyOf[T](l.DB())
+		for _, m := range ms {
+			if id, ok := pk(m); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	invalidateAfterCommit(ctx, l.DB(), func(ctx context.Context) {
+		if len(ids) > 0 {
+			l.entityCache.Invalidate(ctx, table, ids...)
+		}
+		l.queryCache.Invalidate(ctx, table)
+	})
 }
 
 func defaultOperationMutation[T any]() *operationMutation[T] {
@@ -127,3 +234,52 @@ func WithOperationMutationIBind[T any](bind IBind[T]) OperationMutationOption[T]
 		o.IBind = bind
 	}
 }
+
+// WithOperationMutationEntityCache 设置实体缓存, 变更时失效对应缓存
+func WithOperationMutationEntityCache[T any](c *EntityCache) OperationMutationOption[T] {
+	return func(o *operationMutation[T]) {
+		o.entityCache = c
+	}
+}
+
+// WithOperationMutationQueryCache 设置结果缓存, 变更时失效该表的结果缓存
+func WithOperationMutationQueryCache[T any](c *QueryCache) OperationMutationOption[T] {
+	return func(o *operationMutation[T]) {
+		o.queryCache = c
+	}
+}
+
+// WithOperationMutationAuditor 设置审计, 变更和审计记录在同一个事务中写入
+func WithOperationMutationAuditor[T any](a *Auditor) OperationMutationOption[T] {
+	return func(o *operationMutation[T]) {
+		o.writer.auditor = a
+	}
+}
+
+// WithOperationMutationOutbox 设置发件箱, 上下文中的事件和变更在同一个事务中写入
+func WithOperationMutationOutbox[T any](ob *Outbox) OperationMutationOption[T] {
+	return func(o *operationMutation[T]) {
+		o.writer.outbox = ob
+	}
+}
+
+// WithOperationMutationRetry 设置重试策略, 变更在自身开启的事务中整体重试, 标记为幂等时直接重试
+func WithOperationMutationRetry[T any](p *RetryPolicy) OperationMutationOption[T] {
+	return func(o *operationMutation[T]) {
+		o.writer.retry = p
+	}
+}
+
+// WithOperationMutationTimeouts 设置默认超时
+func WithOperationMutationTimeouts[T any](t Timeouts) OperationMutationOption[T] {
+	return func(o *operationMutation[T]) {
+		o.timeouts = t
+	}
+}
+
+// WithOperationMutationGuard 设置防护, 为nil时使用全局默认的防护
+func WithOperationMutationGuard[T any](g *Guard) OperationMutationOption[T] {
+	return func(o *operationMutation[T]) {
+		o.writer.guard = g
+	}
+}
@@ -1,7 +1,10 @@
 package query
 
 import (
+	"context"
+
 	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
 )
 
 type (
@@ -10,24 +13,36 @@ type (
 		Tracer
 		ICtx
 
-		err error
+		entityCache *EntityCache
+		queryCache  *QueryCache
+		writer      mutationWriter[T]
+		timeouts    Timeouts
+		state       *xState
 	}
 
 	OperationMutationXOption[T any] func(*operationMutationX[T])
 )
 
 func (l *operationMutationX[T]) CreateX(m *T) {
-	ctx := l.GetCtx()
+	if l.state.skip("CreateX", true) {
+		return
+	}
+	ctx := WithOperationName(l.GetCtx(), "CreateX")
 	if l.IsEnableTrace() {
 		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "CreateX")
 		defer span.End()
 		ctx = _ctx
 	}
-	l.setErr(l.DB().WithContext(ctx).Create(m).Error)
+	ctx, cancel := withTimeout(ctx, l.timeouts.Write)
+	defer cancel()
+	defer l.invalidateCreated(ctx, []*T{m})
+	l.setErr("CreateX", l.writer.create(ctx, operationDB(ctx, l.DB()), []*T{m}, false, func(tx *gorm.DB) error {
+		return translateResult[T](ctx, tx.Create(m))
+	}))
 }
 
 func (l *operationMutationX[T]) BatchCreateX(m []*T, batchSize int) {
-	if len(m) == 0 {
+	if len(m) == 0 || l.state.skip("BatchCreateX", true) {
 		return
 	}
 
@@ -35,78 +50,194 @@ func (l *operationMutationX[T]) BatchCreateX(m []*T, batchSize int) {
 		batchSize = 1000
 	}
 
-	ctx := l.GetCtx()
+	ctx := WithOperationName(l.GetCtx(), "BatchCreateX")
 	if l.IsEnableTrace() {
 		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "BatchCreateX")
 		defer span.End()
 		ctx = _ctx
 	}
-	l.setErr(l.DB().WithContext(ctx).CreateInBatches(m, batchSize).Error)
+	ctx, cancel := withTimeout(ctx, l.timeouts.Batch)
+	defer cancel()
+	defer l.invalidateCreated(ctx, m)
+	l.setErr("BatchCreateX", l.writer.create(ctx, operationDB(ctx, l.DB()), m, true, func(tx *gorm.DB) error {
+		return translateResult[T](ctx, tx.CreateInBatches(m, batchSize))
+	}))
 }
 
 func (l *operationMutationX[T]) UpdateX(m *T, wheres ...ScopeMethod) {
-	ctx := l.GetCtx()
-	if l.IsEnableTrace() {
-		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "UpdateX")
-		defer span.End()
-		ctx = _ctx
-	}
-	l.setErr(l.DB().WithContext(ctx).Scopes(wheres...).Updates(m).Error)
+	l.updatesX("UpdateX", "UpdateX", m, nil, wheres...)
+}
+
+func (l *operationMutationX[T]) UpdateRowsX(m *T, wheres ...ScopeMethod) int64 {
+	return l.updatesX("UpdateRowsX", "UpdateX", m, nil, wheres...)
 }
 
 func (l *operationMutationX[T]) UpdateMapX(m map[string]any, wheres ...ScopeMethod) {
-	ctx := l.GetCtx()
-	if l.IsEnableTrace() {
-		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "UpdateMapX")
-		defer span.End()
-		ctx = _ctx
-	}
-	l.setErr(l.DB().WithContext(ctx).Scopes(wheres...).Updates(m).Error)
+	l.updatesX("UpdateMapX", "UpdateMapX", m, nil, wheres...)
+}
+
+func (l *operationMutationX[T]) UpdateMapRowsX(m map[string]any, wheres ...ScopeMethod) int64 {
+	return l.updatesX("UpdateMapRowsX", "UpdateMapX", m, nil, wheres...)
 }
 
 func (l *operationMutationX[T]) UpdateByIDX(id uint32, m *T, wheres ...ScopeMethod) {
-	l.UpdateX(m, append(wheres, WhereID(id))...)
+	l.updatesX("UpdateByIDX", "UpdateX", m, []uint32{id}, append(wheres, WhereID(id))...)
+}
+
+func (l *operationMutationX[T]) UpdateByIDRowsX(id uint32, m *T, wheres ...ScopeMethod) int64 {
+	return l.updatesX("UpdateByIDRowsX", "UpdateX", m, []uint32{id}, append(wheres, WhereID(id))...)
 }
 
 func (l *operationMutationX[T]) UpdateMapByIDX(id uint32, m map[string]any, wheres ...ScopeMethod) {
-	l.UpdateMapX(m, append(wheres, WhereID(id))...)
+	l.updatesX("UpdateMapByIDX", "UpdateMapX", m, []uint32{id}, append(wheres, WhereID(id))...)
+}
+
+func (l *operationMutationX[T]) UpdateMapByIDRowsX(id uint32, m map[string]any, wheres ...ScopeMethod) int64 {
+	return l.updatesX("UpdateMapByIDRowsX", "UpdateMapX", m, []uint32{id}, append(wheres, WhereID(id))...)
 }
 
 func (l *operationMutationX[T]) DeleteX(wheres ...ScopeMethod) {
-	ctx := l.GetCtx()
-	if l.IsEnableTrace() {
-		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "DeleteX")
-		defer span.End()
-		ctx = _ctx
-	}
-	var m T
-	l.setErr(l.DB().WithContext(ctx).Scopes(wheres...).Delete(&m).Error)
+	l.deleteX("DeleteX", "DeleteX", nil, wheres...)
+}
+
+func (l *operationMutationX[T]) DeleteRowsX(wheres ...ScopeMethod) int64 {
+	return l.deleteX("DeleteRowsX", "DeleteX", nil, wheres...)
 }
 
 func (l *operationMutationX[T]) DeleteByIDX(id uint32, wheres ...ScopeMethod) {
-	l.DeleteX(append(wheres, WhereID(id))...)
+	l.deleteX("DeleteByIDX", "DeleteX", []uint32{id}, append(wheres, WhereID(id))...)
+}
+
+func (l *operationMutationX[T]) DeleteByIDRowsX(id uint32, wheres ...ScopeMethod) int64 {
+	return l.deleteX("DeleteByIDRowsX", "DeleteX", []uint32{id}, append(wheres, WhereID(id))...)
 }
 
 func (l *operationMutationX[T]) ForcedDeleteX(wheres ...ScopeMethod) {
-	l.DeleteX(append(wheres, WithTrashed)...)
+	l.deleteX("ForcedDeleteX", "DeleteX", nil, append(wheres, WithTrashed)...)
+}
+
+func (l *operationMutationX[T]) ForcedDeleteRowsX(wheres ...ScopeMethod) int64 {
+	return l.deleteX("ForcedDeleteRowsX", "DeleteX", nil, append(wheres, WithTrashed)...)
 }
 
 func (l *operationMutationX[T]) ForcedDeleteByIDX(id uint32, wheres ...ScopeMethod) {
-	l.DeleteX(append(wheres, WhereID(id), WithTrashed)...)
+	l.deleteX("ForcedDeleteByIDX", "DeleteX", []uint32{id}, append(wheres, WhereID(id), WithTrashed)...)
+}
+
+func (l *operationMutationX[T]) ForcedDeleteByIDRowsX(id uint32, wheres ...ScopeMethod) int64 {
+	return l.deleteX("ForcedDeleteByIDRowsX", "DeleteX", []uint32{id}, append(wheres, WhereID(id), WithTrashed)...)
+}
+
+// updatesX 以op的名义执行updates并记录错误, X和RowsX方法共用, 跳过和出错时都记录为调用的方法
+func (l *operationMutationX[T]) updatesX(op, spanName string, values any, ids []uint32, wheres ...ScopeMethod) int64 {
+	if l.state.skip(op, true) {
+		return 0
+	}
+	rows, err := l.updates(spanName, values, ids, wheres...)
+	l.setErr(op, err)
+	return rows
 }
 
-func (l *operationMutationX[T]) setErr(err error) {
-	if err != nil {
-		l.err = err
+// deleteX 以op的名义执行delete并记录错误, X和RowsX方法共用, 跳过和出错时都记录为调用的方法
+func (l *operationMutationX[T]) deleteX(op, spanName string, ids []uint32, wheres ...ScopeMethod) int64 {
+	if l.state.skip(op, true) {
+		return 0
 	}
+	rows, err := l.delete(spanName, ids, wheres...)
+	l.setErr(op, err)
+	return rows
+}
+
+// updates 更新数据并返回影响的行数, ids不为空时只失效对应ID的缓存, 否则失效整张表的缓存
+func (l *operationMutationX[T]) updates(spanName string, values any, ids []uint32, wheres ...ScopeMethod) (int64, error) {
+	ctx := WithOperationName(l.GetCtx(), spanName)
+	if l.IsEnableTrace() {
+		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, spanName)
+		defer span.End()
+		ctx = _ctx
+	}
+	ctx, cancel := withTimeout(ctx, l.timeouts.Write)
+	defer cancel()
+	defer l.invalidate(ctx, ids)
+	return l.writer.write(ctx, operationDB(ctx, l.DB()), AuditActionUpdate, PolicyUpdate, wheres, values, func(tx *gorm.DB) *gorm.DB {
+		return tx.Updates(values)
+	})
+}
+
+// delete 删除数据并返回影响的行数, ids不为空时只失效对应ID的缓存, 否则失效整张表的缓存
+func (l *operationMutationX[T]) delete(spanName string, ids []uint32, wheres ...ScopeMethod) (int64, error) {
+	ctx := WithOperationName(l.GetCtx(), spanName)
+	if l.IsEnableTrace() {
+		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, spanName)
+		defer span.End()
+		ctx = _ctx
+	}
+	ctx, cancel := withTimeout(ctx, l.timeouts.Write)
+	defer cancel()
+	defer l.invalidate(ctx, ids)
+	var m T
+	return l.writer.write(ctx, operationDB(ctx, l.DB()), AuditActionDelete, PolicyDelete, wheres, nil, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&m)
+	})
+}
+
+// invalidate 失效缓存, ids不为空时实体缓存只失效对应ID, 结果缓存总是失效整张表
+func (l *operationMutationX[T]) invalidate(ctx context.Context, ids []uint32) {
+	if (l.entityCache == nil && l.queryCache == nil) || l.DB().DryRun {
+		return
+	}
+	table := tableNameOf[T](l.DB())
+	invalidateAfterCommit(ctx, l.DB(), func(ctx context.Context) {
+		l.entityCache.Invalidate(ctx, table, ids...)
+		l.queryCache.Invalidate(ctx, table)
+	})
+}
+
+// invalidateCreated 失效新增数据的缓存, 实体缓存只失效新增的ID(可能有负缓存), 结果缓存失效整张表
+func (l *operationMutationX[T]) invalidateCreated(ctx context.Context, ms []*T) {
+	if (l.entityCache == nil && l.queryCache == nil) || l.DB().DryRun {
+		return
+	}
+	table := tableNameOf[T](l.DB())
+	var ids []uint32
+	if l.entityCache != nil {
+		pk := primaryKeyOf[T](l.DB())
+		for _, m := range ms {
+			if id, ok := pk(m); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	invalidateAfterCommit(ctx, l.DB(), func(ctx context.Context) {
+		if len(ids) > 0 {
+			l.entityCache.Invalidate(ctx, table, ids...)
+		}
+		l.queryCache.Invalidate(ctx, table)
+	})
+}
+
+// setErr 记录错误
+func (l *operationMutationX[T]) setErr(op string, err error) {
+	l.state.record(op, true, err)
 }
 
 func (l *operationMutationX[T]) GetMutationErr() error {
-	return l.err
+	return l.state.mutationErr()
+}
+
+// withXState 返回使用s记录错误的副本
+func (l *operationMutationX[T]) withXState(s *xState) IOperationMutationX[T] {
+	o := *l
+	o.state = s
+	return &o
+}
+
+func (l *operationMutationX[T]) getXState() *xState {
+	return l.state
 }
 
 func defaultOperationMutationX[T any]() *operationMutationX[T] {
-	return &operationMutationX[T]{}
+	return &operationMutationX[T]{state: newXState()}
 }
 
 // NewOperationMutationX 实例化操作
@@ -138,3 +269,52 @@ func WithOperationMutationXICtx[T any](ctx ICtx) OperationMutationXOption[T] {
 		o.ICtx = ctx
 	}
 }
+
+// WithOperationMutationXEntityCache 设置实体缓存, 变更时失效对应缓存
+func WithOperationMutationXEntityCache[T any](c *EntityCache) OperationMutationXOption[T] {
+	return func(o *operationMutationX[T]) {
+		o.entityCache = c
+	}
+}
+
+// WithOperationMutationXQueryCache 设置结果缓存, 变更时失效该表的结果缓存
+func WithOperationMutationXQueryCache[T any](c *QueryCache) OperationMutationXOption[T] {
+	return func(o *operationMutationX[T]) {
+		o.queryCache = c
+	}
+}
+
+// WithOperationMutationXAuditor 设置审计, 变更和审计记录在同一个事务中写入
+func WithOperationMutationXAuditor[T any](a *Auditor) OperationMutationXOption[T] {
+	return func(o *operationMutationX[T]) {
+		o.writer.auditor = a
+	}
+}
+
+// WithOperationMutationXOutbox 设置发件箱, 上下文中的事件和变更在同一个事务中写入
+func WithOperationMutationXOutbox[T any](ob *Outbox) OperationMutationXOption[T] {
+	return func(o *operationMutationX[T]) {
+		o.writer.outbox = ob
+	}
+}
+
+// WithOperationMutationXRetry 设置重试策略, 变更在自身开启的事务中整体重试, 标记为幂等时直接重试
+func WithOperationMutationXRetry[T any](p *RetryPolicy) OperationMutationXOption[T] {
+	return func(o *operationMutationX[T]) {
+		o.writer.retry = p
+	}
+}
+
+// WithOperationMutationXTimeouts 设置默认超时
+func WithOperationMutationXTimeouts[T any](t Timeouts) OperationMutationXOption[T] {
+	return func(o *operationMutationX[T]) {
+		o.timeouts = t
+	}
+}
+
+// WithOperationMutationXGuard 设置防护, 为nil时使用全局默认的防护
+func WithOperationMutationXGuard[T any](g *Guard) OperationMutationXOption[T] {
+	return func(o *operationMutationX[T]) {
+		o.writer.guard = g
+	}
+}
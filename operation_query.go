@@ -1,7 +1,10 @@
 package query
 
 import (
+	"context"
+
 	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
 )
 
 type (
@@ -9,6 +12,13 @@ type (
 		IBind[T]
 		Tracer
 		ICtx
+
+		entityCache *EntityCache
+		queryCache  *QueryCache
+		coalescer   *Coalescer
+		retry       *RetryPolicy
+		timeouts    Timeouts
+		guard       *Guard
 	}
 
 	OperationQueryOption[T any] func(*operationQuery[T])
@@ -49,18 +59,38 @@ func WithOperationQueryICtx[T any](c ICtx) OperationQueryOption[T] {
 }
 
 func (l *operationQuery[T]) First(wheres ...ScopeMethod) (*T, error) {
-	ctx := l.GetCtx()
+	return l.first(l.GetCtx(), wheres...)
+}
+
+// first 使用ctx查询单条数据
+func (l *operationQuery[T]) first(ctx context.Context, wheres ...ScopeMethod) (*T, error) {
+	ctx = WithOperationName(ctx, "First")
 	if l.IsEnableTrace() {
 		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "First")
 		defer span.End()
 		ctx = _ctx
 	}
-	var m T
-	if err := l.DB().WithContext(ctx).Scopes(wheres...).First(&m).Error; err != nil {
+	ctx, cancel := withTimeout(ctx, l.timeouts.Read)
+	defer cancel()
+	ps, err := policyScopes[T](ctx, PolicyRead)
+	if err != nil {
 		return nil, err
 	}
+	db := operationDB(ctx, l.DB()).Scopes(wheres...).Scopes(ps...)
+	return coalesce(ctx, l.coalescer, db, "first", func(tx *gorm.DB) *gorm.DB {
+		var m T
+		return tx.First(&m)
+	}, func(ctx context.Context) (*T, error) {
+		var m T
+		err := l.retry.query(ctx, db, func() error {
+			return translateResult[T](ctx, db.WithContext(ctx).First(&m))
+		})
+		if err != nil {
+			return nil, err
+		}
 
-	return &m, nil
+		return &m, nil
+	}, cloneEntity[T])
 }
 
 func (l *operationQuery[T]) FirstWithTrashed(wheres ...ScopeMethod) (*T, error) {
@@ -68,7 +98,13 @@ func (l *operationQuery[T]) FirstWithTrashed(wheres ...ScopeMethod) (*T, error)
 }
 
 func (l *operationQuery[T]) FirstByID(id uint32, wheres ...ScopeMethod) (*T, error) {
-	return l.First(append(wheres, WhereID(id))...)
+	// 附加了条件或者行级策略时, 结果和调用方相关, 不走缓存; 链上的状态和事务见sharedCacheable
+	if l.entityCache == nil || len(wheres) > 0 || HasPolicy[T]() || !sharedCacheable(l.DB()) {
+		return l.First(append(wheres, WhereID(id))...)
+	}
+	return loadEntity[T](WithOperationName(l.GetCtx(), "FirstByID"), l.entityCache, l.DB(), id, func(ctx context.Context) (*T, error) {
+		return l.first(ctx, WhereID(id))
+	})
 }
 
 func (l *operationQuery[T]) FirstByIDWithTrashed(id uint32, wheres ...ScopeMethod) (*T, error) {
@@ -76,18 +112,33 @@ func (l *operationQuery[T]) FirstByIDWithTrashed(id uint32, wheres ...ScopeMetho
 }
 
 func (l *operationQuery[T]) Last(wheres ...ScopeMethod) (*T, error) {
-	ctx := l.GetCtx()
+	ctx := WithOperationName(l.GetCtx(), "Last")
 	if l.IsEnableTrace() {
 		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "Last")
 		defer span.End()
 		ctx = _ctx
 	}
-	var m T
-	if err := l.DB().WithContext(ctx).Scopes(wheres...).Last(&m).Error; err != nil {
+	ctx, cancel := withTimeout(ctx, l.timeouts.Read)
+	defer cancel()
+	ps, err := policyScopes[T](ctx, PolicyRead)
+	if err != nil {
 		return nil, err
 	}
+	db := operationDB(ctx, l.DB()).Scopes(wheres...).Scopes(ps...)
+	return coalesce(ctx, l.coalescer, db, "last", func(tx *gorm.DB) *gorm.DB {
+		var m T
+		return tx.Last(&m)
+	}, func(ctx context.Context) (*T, error) {
+		var m T
+		err := l.retry.query(ctx, db, func() error {
+			return translateResult[T](ctx, db.WithContext(ctx).Last(&m))
+		})
+		if err != nil {
+			return nil, err
+		}
 
-	return &m, nil
+		return &m, nil
+	}, cloneEntity[T])
 }
 
 func (l *operationQuery[T]) LastWithTrashed(wheres ...ScopeMethod) (*T, error) {
@@ -103,25 +154,55 @@ func (l *operationQuery[T]) LastByIDWithTrashed(id uint32, wheres ...ScopeMethod
 }
 
 func (l *operationQuery[T]) List(pgInfo Pagination, wheres ...ScopeMethod) ([]*T, error) {
-	ctx := l.GetCtx()
+	ctx := WithOperationName(l.GetCtx(), "List")
 	if l.IsEnableTrace() {
 		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "List")
 		defer span.End()
 		ctx = _ctx
 	}
-	var ms []*T
+	ctx, cancel := withTimeout(ctx, l.timeouts.Read)
+	defer cancel()
+	ps, err := policyScopes[T](ctx, PolicyRead)
+	if err != nil {
+		return nil, err
+	}
+	db, maxRows := l.guard.boundList(operationDB(ctx, l.DB()).Scopes(wheres...).Scopes(ps...), pgInfo, wheres)
+	ms, err := cachedList[T](ctx, l.queryCache, db, pgInfo, func() ([]*T, error) {
+		res, err := coalesce(ctx, l.coalescer, db, "list", func(tx *gorm.DB) *gorm.DB {
+			var ms []*T
+			return tx.Scopes(Paginate(pgInfo)).Find(&ms)
+		}, func(ctx context.Context) (listResult[T], error) {
+			var res listResult[T]
+			err := l.retry.query(ctx, db, func() error {
+				tx := db.WithContext(ctx)
+				if pgInfo != nil {
+					if err := translateResult[T](ctx, tx.Count(&res.total)); err != nil {
+						return err
+					}
+					tx = tx.Scopes(Paginate(pgInfo))
+				}
 
-	db := l.DB().WithContext(ctx).Scopes(wheres...)
-	if pgInfo != nil {
-		var total int64
-		if err := db.WithContext(ctx).Count(&total).Error; err != nil {
+				return translateResult[T](ctx, tx.Find(&res.items))
+			})
+			if err != nil {
+				return res, err
+			}
+
+			return res, nil
+		}, cloneListResult[T])
+		if err != nil {
 			return nil, err
 		}
-		pgInfo.SetTotal(total)
-		db = db.Scopes(Paginate(pgInfo))
-	}
+		if pgInfo != nil {
+			pgInfo.SetTotal(res.total)
+		}
 
-	if err := db.Find(&ms).Error; err != nil {
+		return res.items, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := guardList[T](ctx, l.guard, db, len(ms), maxRows); err != nil {
 		return nil, err
 	}
 
@@ -133,20 +214,109 @@ func (l *operationQuery[T]) ListWithTrashed(pgInfo Pagination, wheres ...ScopeMe
 }
 
 func (l *operationQuery[T]) Count(wheres ...ScopeMethod) (int64, error) {
-	ctx := l.GetCtx()
+	ctx := WithOperationName(l.GetCtx(), "Count")
 	if l.IsEnableTrace() {
 		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "Count")
 		defer span.End()
 		ctx = _ctx
 	}
-	var total int64
-	if err := l.DB().WithContext(ctx).Scopes(wheres...).Count(&total).Error; err != nil {
+	ctx, cancel := withTimeout(ctx, l.timeouts.Read)
+	defer cancel()
+	ps, err := policyScopes[T](ctx, PolicyRead)
+	if err != nil {
 		return 0, err
 	}
+	db := operationDB(ctx, l.DB()).Scopes(wheres...).Scopes(ps...)
+	return cachedCount[T](ctx, l.queryCache, db, func() (int64, error) {
+		return coalesce(ctx, l.coalescer, db, "count", func(tx *gorm.DB) *gorm.DB {
+			var total int64
+			return tx.Count(&total)
+		}, func(ctx context.Context) (int64, error) {
+			var total int64
+			err := l.retry.query(ctx, db, func() error {
+				return translateResult[T](ctx, db.WithContext(ctx).Count(&total))
+			})
+			if err != nil {
+				return 0, err
+			}
 
-	return total, nil
+			return total, nil
+		}, cloneCount)
+	})
 }
 
 func (l *operationQuery[T]) CountWithTrashed(wheres ...ScopeMethod) (int64, error) {
 	return l.Count(append(wheres, WithTrashed)...)
 }
+
+func (l *operationQuery[T]) Explain(wheres ...ScopeMethod) (*Plan, error) {
+	return l.explain("Explain", nil, false, wheres...)
+}
+
+func (l *operationQuery[T]) ExplainList(pgInfo Pagination, wheres ...ScopeMethod) (*Plan, error) {
+	return l.explain("ExplainList", pgInfo, true, wheres...)
+}
+
+// explain 按查询的条件和策略渲染语句并EXPLAIN, list为true时和List一样附加分页或者行数上限
+func (l *operationQuery[T]) explain(spanName string, pgInfo Pagination, list bool, wheres ...ScopeMethod) (*Plan, error) {
+	ctx := WithOperationName(l.GetCtx(), spanName)
+	if l.IsEnableTrace() {
+		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, spanName)
+		defer span.End()
+		ctx = _ctx
+	}
+	ctx, cancel := withTimeout(ctx, l.timeouts.Read)
+	defer cancel()
+	ps, err := policyScopes[T](ctx, PolicyRead)
+	if err != nil {
+		return nil, err
+	}
+	db := operationDB(ctx, l.DB()).Scopes(wheres...).Scopes(ps...)
+	if list {
+		db, _ = l.guard.boundList(db, pgInfo, wheres)
+		db = db.Scopes(Paginate(pgInfo))
+	}
+	return explainQuery[T](ctx, db)
+}
+
+// WithOperationQueryEntityCache 设置实体缓存, FirstByID优先从缓存读取
+func WithOperationQueryEntityCache[T any](c *EntityCache) OperationQueryOption[T] {
+	return func(o *operationQuery[T]) {
+		o.entityCache = c
+	}
+}
+
+// WithOperationQueryQueryCache 设置List/Count结果缓存
+func WithOperationQueryQueryCache[T any](c *QueryCache) OperationQueryOption[T] {
+	return func(o *operationQuery[T]) {
+		o.queryCache = c
+	}
+}
+
+// WithOperationQueryCoalescer 设置请求合并器, 相同SQL的并发查询只访问一次数据库
+func WithOperationQueryCoalescer[T any](c *Coalescer) OperationQueryOption[T] {
+	return func(o *operationQuery[T]) {
+		o.coalescer = c
+	}
+}
+
+// WithOperationQueryRetry 设置重试策略, 事务外的查询因为瞬时错误失败时重试
+func WithOperationQueryRetry[T any](p *RetryPolicy) OperationQueryOption[T] {
+	return func(o *operationQuery[T]) {
+		o.retry = p
+	}
+}
+
+// WithOperationQueryTimeouts 设置默认超时
+func WithOperationQueryTimeouts[T any](t Timeouts) OperationQueryOption[T] {
+	return func(o *operationQuery[T]) {
+		o.timeouts = t
+	}
+}
+
+// WithOperationQueryGuard 设置防护, 不分页的List超出行数上限时按防护的处理方式处理, 为nil时使用全局默认的防护
+func WithOperationQueryGuard[T any](g *Guard) OperationQueryOption[T] {
+	return func(o *operationQuery[T]) {
+		o.guard = g
+	}
+}
@@ -1,7 +1,10 @@
 package query
 
 import (
+	"context"
+
 	"go.opentelemetry.io/otel"
+	"gorm.io/gorm"
 )
 
 type (
@@ -9,32 +12,65 @@ type (
 		IBind[T]
 		Tracer
 		ICtx
-		err error
+
+		entityCache *EntityCache
+		queryCache  *QueryCache
+		coalescer   *Coalescer
+		retry       *RetryPolicy
+		timeouts    Timeouts
+		guard       *Guard
+		state       *xState
 	}
 
 	OperationQueryXOption[T any] func(*operationQueryX[T])
 )
 
-// setErr 设置错误
-func (l *operationQueryX[T]) setErr(err error) {
-	if err != nil {
-		l.err = err
-	}
+// setErr 记录错误
+func (l *operationQueryX[T]) setErr(op string, err error) {
+	l.state.record(op, false, err)
 }
 
 func (l *operationQueryX[T]) FirstX(wheres ...ScopeMethod) *T {
-	ctx := l.GetCtx()
+	if l.state.skip("FirstX", false) {
+		return nil
+	}
+	m, err := l.first(l.GetCtx(), wheres...)
+	l.setErr("FirstX", err)
+	return m
+}
+
+// first 使用ctx查询单条数据
+func (l *operationQueryX[T]) first(ctx context.Context, wheres ...ScopeMethod) (*T, error) {
+	ctx = WithOperationName(ctx, "FirstX")
 	if l.IsEnableTrace() {
 		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "FirstX")
 		defer span.End()
 		ctx = _ctx
 	}
-	var m T
-	if err := l.DB().WithContext(ctx).Scopes(wheres...).First(&m).Error; err != nil {
-		l.setErr(err)
-		return nil
+	ctx, cancel := withTimeout(ctx, l.timeouts.Read)
+	defer cancel()
+	ps, err := policyScopes[T](ctx, PolicyRead)
+	if err != nil {
+		return nil, err
+	}
+	db := operationDB(ctx, l.DB()).Scopes(wheres...).Scopes(ps...)
+	m, err := coalesce(ctx, l.coalescer, db, "first", func(tx *gorm.DB) *gorm.DB {
+		var m T
+		return tx.First(&m)
+	}, func(ctx context.Context) (*T, error) {
+		var m T
+		err := l.retry.query(ctx, db, func() error {
+			return translateResult[T](ctx, db.WithContext(ctx).First(&m))
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &m, nil
+	}, cloneEntity[T])
+	if err != nil {
+		return nil, err
 	}
-	return &m
+	return m, nil
 }
 
 func (l *operationQueryX[T]) FirstWithTrashedX(wheres ...ScopeMethod) *T {
@@ -42,7 +78,18 @@ func (l *operationQueryX[T]) FirstWithTrashedX(wheres ...ScopeMethod) *T {
 }
 
 func (l *operationQueryX[T]) FirstByIDX(id uint32, wheres ...ScopeMethod) *T {
-	return l.FirstX(append(wheres, WhereID(id))...)
+	if l.state.skip("FirstByIDX", false) {
+		return nil
+	}
+	// 附加了条件或者行级策略时, 结果和调用方相关, 不走缓存; 链上的状态和事务见sharedCacheable
+	if l.entityCache == nil || len(wheres) > 0 || HasPolicy[T]() || !sharedCacheable(l.DB()) {
+		return l.FirstX(append(wheres, WhereID(id))...)
+	}
+	m, err := loadEntity[T](WithOperationName(l.GetCtx(), "FirstByIDX"), l.entityCache, l.DB(), id, func(ctx context.Context) (*T, error) {
+		return l.first(ctx, WhereID(id))
+	})
+	l.setErr("FirstByIDX", err)
+	return m
 }
 
 func (l *operationQueryX[T]) FirstByIDWithTrashedX(id uint32, wheres ...ScopeMethod) *T {
@@ -50,18 +97,46 @@ func (l *operationQueryX[T]) FirstByIDWithTrashedX(id uint32, wheres ...ScopeMet
 }
 
 func (l *operationQueryX[T]) LastX(wheres ...ScopeMethod) *T {
-	ctx := l.GetCtx()
+	if l.state.skip("LastX", false) {
+		return nil
+	}
+	m, err := l.last(wheres...)
+	l.setErr("LastX", err)
+	return m
+}
+
+// last 查询最后一条数据
+func (l *operationQueryX[T]) last(wheres ...ScopeMethod) (*T, error) {
+	ctx := WithOperationName(l.GetCtx(), "LastX")
 	if l.IsEnableTrace() {
 		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "LastX")
 		defer span.End()
 		ctx = _ctx
 	}
-	var m T
-	if err := l.DB().WithContext(ctx).Scopes(wheres...).Last(&m).Error; err != nil {
-		l.setErr(err)
-		return nil
+	ctx, cancel := withTimeout(ctx, l.timeouts.Read)
+	defer cancel()
+	ps, err := policyScopes[T](ctx, PolicyRead)
+	if err != nil {
+		return nil, err
+	}
+	db := operationDB(ctx, l.DB()).Scopes(wheres...).Scopes(ps...)
+	m, err := coalesce(ctx, l.coalescer, db, "last", func(tx *gorm.DB) *gorm.DB {
+		var m T
+		return tx.Last(&m)
+	}, func(ctx context.Context) (*T, error) {
+		var m T
+		err := l.retry.query(ctx, db, func() error {
+			return translateResult[T](ctx, db.WithContext(ctx).Last(&m))
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &m, nil
+	}, cloneEntity[T])
+	if err != nil {
+		return nil, err
 	}
-	return &m
+	return m, nil
 }
 
 func (l *operationQueryX[T]) LastWithTrashedX(wheres ...ScopeMethod) *T {
@@ -77,26 +152,60 @@ func (l *operationQueryX[T]) LastByIDWithTrashedX(id uint32, wheres ...ScopeMeth
 }
 
 func (l *operationQueryX[T]) ListX(pgInfo Pagination, wheres ...ScopeMethod) []*T {
-	ctx := l.GetCtx()
+	if l.state.skip("ListX", false) {
+		return nil
+	}
+	ms, err := l.list(pgInfo, wheres...)
+	l.setErr("ListX", err)
+	return ms
+}
+
+// list 查询多条数据, 分页时先查询总数, 总数查询失败时不再查询数据
+func (l *operationQueryX[T]) list(pgInfo Pagination, wheres ...ScopeMethod) ([]*T, error) {
+	ctx := WithOperationName(l.GetCtx(), "ListX")
 	if l.IsEnableTrace() {
 		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "ListX")
 		defer span.End()
 		ctx = _ctx
 	}
-	var ms []*T
-
-	db := l.DB().WithContext(ctx).Scopes(wheres...)
-	if pgInfo != nil {
-		pgInfo.SetTotal(l.CountX(wheres...))
-		db = db.Scopes(Paginate(pgInfo))
+	ctx, cancel := withTimeout(ctx, l.timeouts.Read)
+	defer cancel()
+	ps, err := policyScopes[T](ctx, PolicyRead)
+	if err != nil {
+		return nil, err
 	}
+	db, maxRows := l.guard.boundList(operationDB(ctx, l.DB()).Scopes(wheres...).Scopes(ps...), pgInfo, wheres)
+	ms, err := cachedList[T](ctx, l.queryCache, db, pgInfo, func() ([]*T, error) {
+		if pgInfo != nil {
+			total, err := l.count(wheres...)
+			if err != nil {
+				return nil, err
+			}
+			pgInfo.SetTotal(total)
+		}
+		return coalesce(ctx, l.coalescer, db, "list", func(tx *gorm.DB) *gorm.DB {
+			var ms []*T
+			return tx.Scopes(Paginate(pgInfo)).Find(&ms)
+		}, func(ctx context.Context) ([]*T, error) {
+			var ms []*T
+			err := l.retry.query(ctx, db, func() error {
+				return translateResult[T](ctx, db.WithContext(ctx).Scopes(Paginate(pgInfo)).Find(&ms))
+			})
+			if err != nil {
+				return nil, err
+			}
 
-	if err := db.Find(&ms).Error; err != nil {
-		l.setErr(err)
-		return nil
+			return ms, nil
+		}, cloneEntities[T])
+	})
+	if err == nil {
+		err = guardList[T](ctx, l.guard, db, len(ms), maxRows)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return ms
+	return ms, nil
 }
 
 func (l *operationQueryX[T]) ListWithTrashedX(pgInfo Pagination, wheres ...ScopeMethod) []*T {
@@ -104,18 +213,48 @@ func (l *operationQueryX[T]) ListWithTrashedX(pgInfo Pagination, wheres ...Scope
 }
 
 func (l *operationQueryX[T]) CountX(wheres ...ScopeMethod) int64 {
-	ctx := l.GetCtx()
+	if l.state.skip("CountX", false) {
+		return 0
+	}
+	total, err := l.count(wheres...)
+	l.setErr("CountX", err)
+	return total
+}
+
+// count 查询数量
+func (l *operationQueryX[T]) count(wheres ...ScopeMethod) (int64, error) {
+	ctx := WithOperationName(l.GetCtx(), "CountX")
 	if l.IsEnableTrace() {
 		_ctx, span := otel.Tracer("gorm-normalize").Start(ctx, "CountX")
 		defer span.End()
 		ctx = _ctx
 	}
-	var total int64
-	if err := l.DB().WithContext(ctx).Scopes(wheres...).Count(&total).Error; err != nil {
-		l.setErr(err)
-		return 0
+	ctx, cancel := withTimeout(ctx, l.timeouts.Read)
+	defer cancel()
+	ps, err := policyScopes[T](ctx, PolicyRead)
+	if err != nil {
+		return 0, err
 	}
-	return total
+	db := operationDB(ctx, l.DB()).Scopes(wheres...).Scopes(ps...)
+	total, err := cachedCount[T](ctx, l.queryCache, db, func() (int64, error) {
+		return coalesce(ctx, l.coalescer, db, "count", func(tx *gorm.DB) *gorm.DB {
+			var total int64
+			return tx.Count(&total)
+		}, func(ctx context.Context) (int64, error) {
+			var total int64
+			err := l.retry.query(ctx, db, func() error {
+				return translateResult[T](ctx, db.WithContext(ctx).Count(&total))
+			})
+			if err != nil {
+				return 0, err
+			}
+			return total, nil
+		}, cloneCount)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
 }
 
 func (l *operationQueryX[T]) CountWithTrashedX(wheres ...ScopeMethod) int64 {
@@ -123,11 +262,22 @@ func (l *operationQueryX[T]) CountWithTrashedX(wheres ...ScopeMethod) int64 {
 }
 
 func (l *operationQueryX[T]) GetQueryErr() error {
-	return l.err
+	return l.state.queryErr()
+}
+
+// withXState 返回使用s记录错误的副本
+func (l *operationQueryX[T]) withXState(s *xState) IOperationQueryX[T] {
+	o := *l
+	o.state = s
+	return &o
+}
+
+func (l *operationQueryX[T]) getXState() *xState {
+	return l.state
 }
 
 func defaultOperationQueryX[T any]() *operationQueryX[T] {
-	return &operationQueryX[T]{}
+	return &operationQueryX[T]{state: newXState()}
 }
 
 // NewOperationQueryX 实例化查询操作
@@ -159,3 +309,45 @@ func WithOperationQueryXICtx[T any](c ICtx) OperationQueryXOption[T] {
 		o.ICtx = c
 	}
 }
+
+// WithOperationQueryXEntityCache 设置实体缓存, FirstByIDX优先从缓存读取
+func WithOperationQueryXEntityCache[T any](c *EntityCache) OperationQueryXOption[T] {
+	return func(o *operationQueryX[T]) {
+		o.entityCache = c
+	}
+}
+
+// WithOperationQueryXQueryCache 设置ListX/CountX结果缓存
+func WithOperationQueryXQueryCache[T any](c *QueryCache) OperationQueryXOption[T] {
+	return func(o *operationQueryX[T]) {
+		o.queryCache = c
+	}
+}
+
+// WithOperationQueryXCoalescer 设置请求合并器, 相同SQL的并发查询只访问一次数据库
+func WithOperationQueryXCoalescer[T any](c *Coalescer) OperationQueryXOption[T] {
+	return func(o *operationQueryX[T]) {
+		o.coalescer = c
+	}
+}
+
+// WithOperationQueryXRetry 设置重试策略, 事务外的查询因为瞬时错误失败时重试
+func WithOperationQueryXRetry[T any](p *RetryPolicy) OperationQueryXOption[T] {
+	return func(o *operationQueryX[T]) {
+		o.retry = p
+	}
+}
+
+// WithOperationQueryXTimeouts 设置默认超时
+func WithOperationQueryXTimeouts[T any](t Timeouts) OperationQueryXOption[T] {
+	return func(o *operationQueryX[T]) {
+		o.timeouts = t
+	}
+}
+
+// WithOperationQueryXGuard 设置防护, 不分页的List超出行数上限时按防护的处理方式处理, 为nil时使用全局默认的防护
+func WithOperationQueryXGuard[T any](g *Guard) OperationQueryXOption[T] {
+	return func(o *operationQueryX[T]) {
+		o.guard = g
+	}
+}
@@ -27,6 +27,7 @@ type IOperationQueryX[T any] interface {
 	// CountWithTrashedX 查询数量(包含软删除数据)
 	CountWithTrashedX(wheres ...ScopeMethod) int64
 
+	// GetQueryErr 按记录顺序合并的查询错误
 	GetQueryErr() error
 }
 
@@ -53,12 +54,39 @@ type IOperationMutationX[T any] interface {
 	// ForcedDeleteByIDX 根据ID强制删除数据
 	ForcedDeleteByIDX(id uint32, wheres ...ScopeMethod)
 
+	// UpdateRowsX 更新数据, 返回影响的行数
+	UpdateRowsX(m *T, wheres ...ScopeMethod) int64
+	// UpdateMapRowsX 通过map更新数据, 返回影响的行数
+	UpdateMapRowsX(m map[string]any, wheres ...ScopeMethod) int64
+	// UpdateByIDRowsX 根据ID更新数据, 返回影响的行数
+	UpdateByIDRowsX(id uint32, m *T, wheres ...ScopeMethod) int64
+	// UpdateMapByIDRowsX 根据ID更新数据, 返回影响的行数
+	UpdateMapByIDRowsX(id uint32, m map[string]any, wheres ...ScopeMethod) int64
+	// DeleteRowsX 删除数据, 返回影响的行数
+	DeleteRowsX(wheres ...ScopeMethod) int64
+	// DeleteByIDRowsX 根据ID删除数据, 返回影响的行数
+	DeleteByIDRowsX(id uint32, wheres ...ScopeMethod) int64
+	// ForcedDeleteRowsX 强制删除数据, 返回影响的行数
+	ForcedDeleteRowsX(wheres ...ScopeMethod) int64
+	// ForcedDeleteByIDRowsX 根据ID强制删除数据, 返回影响的行数
+	ForcedDeleteByIDRowsX(id uint32, wheres ...ScopeMethod) int64
+
+	// GetMutationErr 按记录顺序合并的变更错误
 	GetMutationErr() error
 }
 
 // IOperationX 扩展操作, 不返回error
+//
+// 查询和变更共享错误状态, 记录到错误后后续的X方法直接返回零值, 不再访问数据库并记录ErrSkipped, 直到ResetErr;
+// NewAction创建的动作和通过WithContext等链式方法派生的动作各自使用独立的错误状态, 派生的动作不继承原动作的错误;
+// 作为单例共享的动作记录的错误会影响所有使用者, 请求中应当派生新的动作或者使用Run
 type IOperationX[T any] interface {
 	IOperationQueryX[T]
 	IOperationMutationX[T]
+	// Err 按记录顺序合并的全部错误, 由errors.Join合并, 每个错误带有操作名称
 	Err() error
+	// ResetErr 清空已记录的错误, 之后的X方法恢复执行
+	ResetErr()
+	// Run 在独立的错误状态中执行fn, 返回fn中记录的全部错误, 不影响外部的错误状态
+	Run(fn func(x IOperationX[T])) error
 }
@@ -0,0 +1,136 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrSkipped 调用链上已经记录了错误, X方法没有执行
+var ErrSkipped = errors.New("skipped after a previous error")
+
+type (
+	// xState X方法的错误状态, 同一条调用链上的查询和变更共享
+	//
+	// 记录到错误后, 后续的X方法直接返回零值, 不再访问数据库, 并记录ErrSkipped, 直到ResetErr;
+	// 为nil时不记录错误, 也不会跳过
+	xState struct {
+		mu   sync.Mutex
+		errs []xErr
+	}
+
+	// xErr 一次X方法记录的错误
+	xErr struct {
+		mutation bool
+		err      error
+	}
+
+	// xStateBinder 可以绑定xState的X操作, 返回使用该状态的副本
+	xStateBinder[X any] interface {
+		withXState(s *xState) X
+	}
+
+	// xStateHolder 使用xState的X操作
+	xStateHolder interface {
+		getXState() *xState
+	}
+)
+
+// newXState 创建X方法的错误状态
+func newXState() *xState {
+	return &xState{}
+}
+
+// bindXState 返回使用s的x副本, x不支持绑定时原样返回
+func bindXState[X any](x X, s *xState) X {
+	if b, ok := any(x).(xStateBinder[X]); ok {
+		return b.withXState(s)
+	}
+	return x
+}
+
+// usesXState x是否使用s记录错误
+func usesXState(x any, s *xState) bool {
+	h, ok := x.(xStateHolder)
+	return ok && h.getXState() == s
+}
+
+// failed 是否已经记录了错误
+func (s *xState) failed() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.errs) > 0
+}
+
+// skip 已经记录了错误时跳过本次调用, 并把跳过作为op的错误记录下来
+func (s *xState) skip(op string, mutation bool) bool {
+	if !s.failed() {
+		return false
+	}
+	s.record(op, mutation, ErrSkipped)
+	return true
+}
+
+// record 记录错误, 错误的操作名称统一为op
+//
+// 内部操作返回的OpError(例如拦截器包裹的X方法返回First的错误)改写为op, 其他错误附加操作名称
+func (s *xState) record(op string, mutation bool, err error) {
+	if s == nil || err == nil {
+		return
+	}
+	var opErr *OpError
+	if top, ok := err.(*OpError); ok && top.Op != op {
+		renamed := *top
+		renamed.Op = op
+		err = &renamed
+	} else if !errors.As(err, &opErr) || opErr.Op != op {
+		err = fmt.Errorf("%s: %w", op, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = append(s.errs, xErr{mutation: mutation, err: err})
+}
+
+// join 按记录顺序合并错误, filter为nil时合并全部
+func (s *xState) join(filter func(e xErr) bool) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := make([]error, 0, len(s.errs))
+	for _, e := range s.errs {
+		if filter == nil || filter(e) {
+			errs = append(errs, e.err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// queryErr 合并查询错误
+func (s *xState) queryErr() error {
+	return s.join(func(e xErr) bool { return !e.mutation })
+}
+
+// mutationErr 合并变更错误
+func (s *xState) mutationErr() error {
+	return s.join(func(e xErr) bool { return e.mutation })
+}
+
+// err 合并全部错误
+func (s *xState) err() error {
+	return s.join(nil)
+}
+
+// reset 清空错误
+func (s *xState) reset() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs = nil
+}
@@ -0,0 +1,175 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestXState(t *testing.T) {
+	errFirst := errors.New("first failed")
+	errUpdate := errors.New("update failed")
+
+	s := newXState()
+	s.record("FirstX", false, errFirst)
+	s.record("UpdateX", true, errUpdate)
+	s.record("CountX", false, nil)
+	if !s.failed() {
+		t.Fatal("failed() = false after record")
+	}
+	if err := s.err(); !errors.Is(err, errFirst) || !errors.Is(err, errUpdate) {
+		t.Fatalf("err() = %v, want both errors", err)
+	}
+	if err := s.err(); !strings.HasPrefix(err.Error(), "FirstX: first failed\nUpdateX:") {
+		t.Fatalf("err() = %q, want operation names in record order", err)
+	}
+	if err := s.queryErr(); errors.Is(err, errUpdate) {
+		t.Fatalf("queryErr() = %v, should not contain mutation errors", err)
+	}
+	opErr := &OpError{Op: "ListX", Err: errFirst}
+	s.record("ListX", false, opErr)
+	var got *OpError
+	if !errors.As(s.queryErr(), &got) || got != opErr {
+		t.Fatalf("queryErr() = %v, OpError should be kept as is", s.queryErr())
+	}
+
+	s.reset()
+	if s.failed() || s.err() != nil {
+		t.Fatalf("after reset failed() = %v, err() = %v", s.failed(), s.err())
+	}
+}
+
+func TestOperationXRun(t *testing.T) {
+	errDenied := errors.New("denied")
+	o := NewOperationXImpl[User]().(*operationXImpl[User])
+	o.state.record("FirstX", false, errDenied)
+
+	// 已经记录错误, 不会访问未设置的IBind
+	if n := o.CountX(); n != 0 {
+		t.Fatalf("CountX() = %d, want 0", n)
+	}
+	if err := o.Err(); !errors.Is(err, errDenied) {
+		t.Fatalf("Err() = %v, want %v", err, errDenied)
+	}
+
+	err := o.Run(func(x IOperationX[User]) {
+		if x.Err() != nil {
+			t.Fatalf("Run() should start with a fresh state, got %v", x.Err())
+		}
+		x.(*operationXImpl[User]).state.record("DeleteX", true, errDenied)
+		x.DeleteX()
+	})
+	if !errors.Is(err, errDenied) || !strings.HasPrefix(err.Error(), "DeleteX:") {
+		t.Fatalf("Run() = %v", err)
+	}
+	if strings.Contains(o.Err().Error(), "DeleteX") {
+		t.Fatalf("Run() should not leak errors, got %v", o.Err())
+	}
+
+	o.ResetErr()
+	if o.Err() != nil || o.GetQueryErr() != nil || o.GetMutationErr() != nil {
+		t.Fatalf("ResetErr() left %v", o.Err())
+	}
+}
+
+func TestXStatePerChain(t *testing.T) {
+	errBoom := errors.New("boom")
+	db, fake := newFakeDB(t)
+	fake.on("SELECT count(*)").fails(errBoom)
+	a := NewAction[User](WithDB[User](db))
+
+	// NewAction创建的动作也记录错误, 之后的调用被跳过, 直到ResetErr
+	a.CountX()
+	fake.on("SELECT count(*)").returns([]string{"count"}, []driver.Value{int64(3)})
+	if n := a.CountX(); n != 0 || !errors.Is(a.Err(), errBoom) || !errors.Is(a.Err(), ErrSkipped) {
+		t.Fatalf("CountX() on the failed root = %d, %v, want it skipped", n, a.Err())
+	}
+	if len(fake.executed("SELECT count(*)")) != 1 {
+		t.Fatal("skipped CountX() should not query")
+	}
+	if n := a.WithContext(context.Background()).CountX(); n != 3 {
+		t.Fatalf("CountX() on a chain derived from the failed root = %d, want 3", n)
+	}
+	a.ResetErr()
+	if n := a.CountX(); n != 3 || a.Err() != nil {
+		t.Fatalf("CountX() after ResetErr() = %d, %v, want 3", n, a.Err())
+	}
+
+	// 变更失败后, 同一条调用链上的查询被跳过, 跳过的调用记录为查询错误
+	fake.on("UPDATE `users`").fails(errBoom)
+	x := a.WithContext(context.Background())
+	x.DeleteX(WhereID(1))
+	if n := x.CountX(); n != 0 || len(fake.executed("SELECT count(*)")) != 3 {
+		t.Fatalf("CountX() after a failed DeleteX = %d, want it skipped", n)
+	}
+	if err := x.GetMutationErr(); !errors.Is(err, errBoom) || errors.Is(err, ErrSkipped) {
+		t.Fatalf("GetMutationErr() = %v, want only the delete error", err)
+	}
+	if err := x.GetQueryErr(); !errors.Is(err, ErrSkipped) || !strings.HasPrefix(err.Error(), "CountX:") {
+		t.Fatalf("GetQueryErr() = %v, want the skipped CountX", err)
+	}
+
+	if n := a.WithContext(context.Background()).CountX(); n != 3 || a.Err() != nil {
+		t.Fatalf("CountX() on a new chain = %d, root err %v, want 3 and the root unaffected", n, a.Err())
+	}
+}
+
+func TestXStateInterceptorOpName(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.on("SELECT").fails(errors.New("boom"))
+	var ops []string
+	a := NewAction[User](WithDB[User](db), WithInterceptors[User](func(ctx context.Context, inv *Invocation, next Invoker) (any, error) {
+		ops = append(ops, inv.Operation)
+		return next(ctx, inv)
+	}))
+
+	x := a.WithContext(context.Background())
+	x.FirstX(WhereID(1))
+	var opErr *OpError
+	if !errors.As(x.Err(), &opErr) || len(ops) != 1 || opErr.Op != ops[0] {
+		t.Fatalf("FirstX() err = %v, interceptor saw %v, want the same operation name", x.Err(), ops)
+	}
+}
+
+func TestXStateMutationOpName(t *testing.T) {
+	for _, intercepted := range []bool{false, true} {
+		db, fake := newFakeDB(t)
+		fake.on("UPDATE `users`").fails(errors.New("boom"))
+		fake.on("DELETE FROM `users`").fails(errors.New("boom"))
+		opts := []ActionOption[User]{WithDB[User](db)}
+		if intercepted {
+			opts = append(opts, WithInterceptors[User](func(ctx context.Context, inv *Invocation, next Invoker) (any, error) {
+				return next(ctx, inv)
+			}))
+		}
+		a := NewAction[User](opts...)
+
+		// 不返回行数的X方法和RowsX方法分别以自己的名称记录错误和跳过
+		for _, tt := range []struct {
+			op   string
+			call func(x IAction[User])
+		}{
+			{"UpdateMapX", func(x IAction[User]) { x.UpdateMapX(map[string]any{"name": "new"}, WhereID(1)) }},
+			{"UpdateMapRowsX", func(x IAction[User]) { x.UpdateMapRowsX(map[string]any{"name": "new"}, WhereID(1)) }},
+			{"DeleteByIDX", func(x IAction[User]) { x.DeleteByIDX(1) }},
+			{"ForcedDeleteX", func(x IAction[User]) { x.ForcedDeleteX(WhereID(1)) }},
+		} {
+			x := a.WithContext(context.Background())
+			tt.call(x)
+			var opErr *OpError
+			if !errors.As(x.Err(), &opErr) || opErr.Op != tt.op {
+				t.Fatalf("intercepted %v: %s err = %v, want it recorded as %s", intercepted, tt.op, x.Err(), tt.op)
+			}
+			x.ResetErr()
+			fake.on("SELECT count(*)").fails(errors.New("count failed"))
+			x.CountX()
+			tt.call(x)
+			if err := x.GetMutationErr(); !errors.Is(err, ErrSkipped) || !strings.HasPrefix(err.Error(), tt.op+": ") {
+				t.Fatalf("intercepted %v: skipped %s = %v, want it recorded as %s", intercepted, tt.op, err, tt.op)
+			}
+			fake.on("SELECT count(*)").returns([]string{"count"}, []driver.Value{int64(0)})
+		}
+	}
+}
@@ -55,3 +55,76 @@ func WithIAssociation[T any](o IAssociation) ActionOption[T] {
 		a.IAssociation = o
 	}
 }
+
+// WithEntityCache 开启FirstByID的实体缓存, 变更操作会自动失效对应缓存
+func WithEntityCache[T any](c *EntityCache) ActionOption[T] {
+	return func(a *action[T]) {
+		a.entityCache = c
+	}
+}
+
+// WithQueryCache 开启List/Count的结果缓存, 通过当前操作对该表的变更会自动失效结果缓存
+func WithQueryCache[T any](c *QueryCache) ActionOption[T] {
+	return func(a *action[T]) {
+		a.queryCache = c
+	}
+}
+
+// WithCoalescer 开启请求合并, 相同SQL和参数的并发查询只访问一次数据库, 传nil时使用独立的合并器
+func WithCoalescer[T any](c *Coalescer) ActionOption[T] {
+	return func(a *action[T]) {
+		if c == nil {
+			c = NewCoalescer()
+		}
+		a.coalescer = c
+	}
+}
+
+// WithInterceptors 设置拦截器, 按注册顺序由外到内包裹IOperation和IOperationX的每个方法
+func WithInterceptors[T any](interceptors ...Interceptor) ActionOption[T] {
+	return func(a *action[T]) {
+		a.interceptors = append(a.interceptors, interceptors...)
+	}
+}
+
+// WithAudit 开启审计, 通过当前操作的新增、更新和删除都会记录审计日志
+func WithAudit[T any](auditor *Auditor) ActionOption[T] {
+	return func(a *action[T]) {
+		a.auditor = auditor
+	}
+}
+
+// WithOutbox 开启发件箱, 通过WithOutboxEvents添加到上下文中的事件会和变更在同一个事务中写入
+func WithOutbox[T any](outbox *Outbox) ActionOption[T] {
+	return func(a *action[T]) {
+		a.outbox = outbox
+	}
+}
+
+// WithRetry 设置重试策略, 死锁、锁等待超时等瞬时错误按策略重试
+func WithRetry[T any](p *RetryPolicy) ActionOption[T] {
+	return func(a *action[T]) {
+		a.retry = p
+	}
+}
+
+// WithTimeouts 设置读、写、批量操作的默认超时
+func WithTimeouts[T any](t Timeouts) ActionOption[T] {
+	return func(a *action[T]) {
+		a.timeouts = t
+	}
+}
+
+// WithGuard 设置无界读写的防护, 未设置时使用全局默认的防护, 见SetDefaultGuard
+func WithGuard[T any](g *Guard) ActionOption[T] {
+	return func(a *action[T]) {
+		a.guard = g
+	}
+}
+
+// WithDryRun 开启DryRun, 操作只渲染SQL不访问数据库, 查询返回零值, 变更影响的行数为0, 渲染的语句通过gorm logger输出, 见ToSQL
+func WithDryRun[T any](dryRun bool) ActionOption[T] {
+	return func(a *action[T]) {
+		a.dryRun = dryRun
+	}
+}
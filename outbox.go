@@ -0,0 +1,387 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultOutboxTable       = "outbox_messages"
+	defaultRelayInterval     = time.Second
+	defaultRelayBatchSize    = 100
+	defaultRelayMaxAttempts  = 10
+	defaultRelayBackoffBase  = time.Second
+	defaultRelayBackoffLimit = 5 * time.Minute
+	defaultRelayClaimTimeout = time.Minute
+)
+
+const (
+	// OutboxStatusPending 待投递
+	OutboxStatusPending = "pending"
+	// OutboxStatusDone 已投递
+	OutboxStatusDone = "done"
+	// OutboxStatusDead 超过最大重试次数, 不再投递
+	OutboxStatusDead = "dead"
+)
+
+type (
+	// OutboxEvent 领域事件, 以JSON序列化后写入发件箱
+	OutboxEvent interface {
+		Topic() string
+	}
+
+	// OutboxKeyer 事件实现该接口时, Key用于消息分区或去重
+	OutboxKeyer interface {
+		Key() string
+	}
+
+	// Outbox 事务发件箱, 事件和业务变更在同一个事务中写入, 由OutboxRelay异步投递
+	Outbox struct {
+		table string
+	}
+
+	OutboxOption func(*Outbox)
+
+	// OutboxMessage 发件箱消息
+	OutboxMessage struct {
+		ID            uint64     `gorm:"primaryKey" json:"id"`
+		Topic         string     `gorm:"column:topic;type:varchar(128);not null" json:"topic"`
+		Key           string     `gorm:"column:msg_key;type:varchar(128);not null;default:''" json:"key"`
+		Payload       []byte     `gorm:"column:payload;type:blob;not null" json:"payload"`
+		Status        string     `gorm:"column:status;type:varchar(16);not null;index:idx_outbox_pending,priority:1" json:"status"`
+		Attempts      int        `gorm:"column:attempts;not null;default:0" json:"attempts"`
+		NextAttemptAt time.Time  `gorm:"column:next_attempt_at;not null;index:idx_outbox_pending,priority:2" json:"nextAttemptAt"`
+		LastError     string     `gorm:"column:last_error;type:text" json:"lastError"`
+		ProcessedAt   *time.Time `gorm:"column:processed_at" json:"processedAt"`
+		CreatedAt     time.Time  `gorm:"column:created_at;not null" json:"createdAt"`
+	}
+
+	// OutboxPublisher 消息投递, 返回错误时按退避策略重试
+	OutboxPublisher interface {
+		Publish(ctx context.Context, msg *OutboxMessage) error
+	}
+
+	// OutboxPublisherFunc 函数形式的OutboxPublisher
+	OutboxPublisherFunc func(ctx context.Context, msg *OutboxMessage) error
+
+	// ChannelPublisher 投递到channel, 用于测试
+	ChannelPublisher struct {
+		C chan *OutboxMessage
+	}
+
+	// OutboxRelay 发件箱投递, 使用 FOR UPDATE SKIP LOCKED 拉取, 多个实例可以同时运行
+	//
+	// 消息在短事务中认领, 在事务外投递, 投递至少一次: 认领超时前没有更新状态的消息会被重新投递
+	OutboxRelay struct {
+		db        *gorm.DB
+		outbox    *Outbox
+		publisher OutboxPublisher
+
+		interval     time.Duration
+		batchSize    int
+		maxAttempts  int
+		backoffBase  time.Duration
+		backoffLimit time.Duration
+		claimTimeout time.Duration
+	}
+
+	OutboxRelayOption func(*OutboxRelay)
+
+	// outboxBag 上下文中待写入的事件, 被一次变更操作取走
+	outboxBag struct {
+		mu     sync.Mutex
+		events []OutboxEvent
+	}
+
+	outboxBagCtxKey struct{}
+)
+
+// NewOutbox 创建发件箱, 默认表名outbox_messages
+func NewOutbox(opts ...OutboxOption) *Outbox {
+	o := &Outbox{table: defaultOutboxTable}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithOutboxTable 设置发件箱表名
+func WithOutboxTable(table string) OutboxOption {
+	return func(o *Outbox) {
+		o.table = table
+	}
+}
+
+// WithOutboxEvents 在上下文中添加事件, 下一次通过开启了发件箱的IAction执行的变更会在同一个事务中写入这些事件
+//
+// 事件只会被写入一次, 变更失败时事件保留在上下文中
+func WithOutboxEvents(ctx context.Context, events ...OutboxEvent) context.Context {
+	if bag, ok := ctx.Value(outboxBagCtxKey{}).(*outboxBag); ok {
+		bag.put(events...)
+		return ctx
+	}
+	bag := &outboxBag{}
+	bag.put(events...)
+	return context.WithValue(ctx, outboxBagCtxKey{}, bag)
+}
+
+func (b *outboxBag) put(events ...OutboxEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, events...)
+}
+
+func (b *outboxBag) take() []OutboxEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	events := b.events
+	b.events = nil
+	return events
+}
+
+// Migrate 创建或更新发件箱表
+func (o *Outbox) Migrate(db *gorm.DB) error {
+	return db.Table(o.table).AutoMigrate(&OutboxMessage{})
+}
+
+// Enqueue 写入事件, tx一般是业务变更所在的事务
+func (o *Outbox) Enqueue(tx *gorm.DB, events ...OutboxEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	now := time.Now()
+	msgs := make([]*OutboxMessage, 0, len(events))
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("marshal outbox event %s: %w", event.Topic(), err)
+		}
+		msg := &OutboxMessage{
+			Topic:         event.Topic(),
+			Payload:       payload,
+			Status:        OutboxStatusPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		if keyer, ok := event.(OutboxKeyer); ok {
+			msg.Key = keyer.Key()
+		}
+		msgs = append(msgs, msg)
+	}
+	return tx.Session(&gorm.Session{NewDB: true}).Table(o.table).Create(&msgs).Error
+}
+
+// outboxWrite 执行变更, 上下文中有待写入的事件时, 变更和事件在同一个事务中写入
+//
+// 变更失败或者外层的Transaction回滚时, 事件放回上下文; gorm原生的事务无法感知回滚, 返回ErrNativeTransaction
+func outboxWrite(ctx context.Context, o *Outbox, db *gorm.DB, exec func(tx *gorm.DB) error) error {
+	bag, _ := ctx.Value(outboxBagCtxKey{}).(*outboxBag)
+	if o == nil || bag == nil {
+		return exec(db)
+	}
+	if nativeTransaction(db) {
+		return ErrNativeTransaction
+	}
+	events := bag.take()
+	if len(events) == 0 {
+		return exec(db)
+	}
+	restore := sync.OnceFunc(func() { bag.put(events...) })
+	err := transaction(db, func(tx *gorm.DB) error {
+		afterRollback(tx, restore)
+		if err := exec(tx); err != nil {
+			return err
+		}
+		return o.Enqueue(tx, events...)
+	})
+	if err != nil {
+		restore()
+	}
+	return err
+}
+
+// Publish 实现OutboxPublisher
+func (f OutboxPublisherFunc) Publish(ctx context.Context, msg *OutboxMessage) error {
+	return f(ctx, msg)
+}
+
+// NewChannelPublisher 创建投递到channel的OutboxPublisher
+func NewChannelPublisher(size int) *ChannelPublisher {
+	return &ChannelPublisher{C: make(chan *OutboxMessage, size)}
+}
+
+// Publish 实现OutboxPublisher, channel已满时阻塞直到ctx结束
+func (p *ChannelPublisher) Publish(ctx context.Context, msg *OutboxMessage) error {
+	select {
+	case p.C <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NewOutboxRelay 创建发件箱投递, 默认每秒拉取一次, 每次最多100条, 最多重试10次, 退避从1秒开始翻倍, 最长5分钟, 认领1分钟后超时
+func NewOutboxRelay(db *gorm.DB, outbox *Outbox, publisher OutboxPublisher, opts ...OutboxRelayOption) *OutboxRelay {
+	r := &OutboxRelay{
+		db:           db,
+		outbox:       outbox,
+		publisher:    publisher,
+		interval:     defaultRelayInterval,
+		batchSize:    defaultRelayBatchSize,
+		maxAttempts:  defaultRelayMaxAttempts,
+		backoffBase:  defaultRelayBackoffBase,
+		backoffLimit: defaultRelayBackoffLimit,
+		claimTimeout: defaultRelayClaimTimeout,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// WithRelayInterval 设置拉取间隔
+func WithRelayInterval(interval time.Duration) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		if interval > 0 {
+			r.interval = interval
+		}
+	}
+}
+
+// WithRelayBatchSize 设置每次拉取的数量
+func WithRelayBatchSize(size int) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		if size > 0 {
+			r.batchSize = size
+		}
+	}
+}
+
+// WithRelayMaxAttempts 设置最大投递次数, 超过后标记为dead
+func WithRelayMaxAttempts(attempts int) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		if attempts > 0 {
+			r.maxAttempts = attempts
+		}
+	}
+}
+
+// WithRelayBackoff 设置重试退避, 第n次失败后等待 base*2^(n-1), 最长limit
+func WithRelayBackoff(base, limit time.Duration) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		r.backoffBase = base
+		r.backoffLimit = limit
+	}
+}
+
+// WithRelayClaimTimeout 设置认领超时, 超时后还没有更新状态的消息会被重新投递, 需要大于单批的投递耗时
+func WithRelayClaimTimeout(timeout time.Duration) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		if timeout > 0 {
+			r.claimTimeout = timeout
+		}
+	}
+}
+
+// Run 循环投递直到ctx结束
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		n, err := r.ProcessOnce(ctx)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// 拉满一批时说明还有积压, 立即继续
+		if err == nil && n >= r.batchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessOnce 拉取并投递一批消息, 返回处理的消息数量
+//
+// 投递不在事务中进行, 慢的投递不会长时间持有行锁和连接
+func (r *OutboxRelay) ProcessOnce(ctx context.Context) (int, error) {
+	msgs, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, msg := range msgs {
+		if err := r.deliver(ctx, msg); err != nil {
+			return len(msgs), err
+		}
+	}
+	return len(msgs), nil
+}
+
+// claim 在短事务中认领一批待投递的消息, 把next_attempt_at推迟到认领超时, 其他实例在超时前不会拉取
+func (r *OutboxRelay) claim(ctx context.Context) ([]*OutboxMessage, error) {
+	var msgs []*OutboxMessage
+	now := time.Now()
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Table(r.outbox.table).
+			Where("status = ? AND next_attempt_at <= ?", OutboxStatusPending, now).
+			Order("id").
+			Limit(r.batchSize).
+			Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Find(&msgs).Error
+		if err != nil || len(msgs) == 0 {
+			return err
+		}
+		ids := make([]uint64, 0, len(msgs))
+		for _, msg := range msgs {
+			ids = append(ids, msg.ID)
+		}
+		return tx.Session(&gorm.Session{NewDB: true}).Table(r.outbox.table).
+			Where("id IN ?", ids).
+			Update("next_attempt_at", now.Add(r.claimTimeout)).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// deliver 投递一条已认领的消息并更新状态
+func (r *OutboxRelay) deliver(ctx context.Context, msg *OutboxMessage) error {
+	updates := map[string]any{"attempts": msg.Attempts + 1}
+	err := r.publisher.Publish(ctx, msg)
+	now := time.Now()
+	if err != nil {
+		updates["last_error"] = err.Error()
+		if msg.Attempts+1 >= r.maxAttempts {
+			updates["status"] = OutboxStatusDead
+			updates["processed_at"] = now
+		} else {
+			updates["next_attempt_at"] = now.Add(r.backoff(msg.Attempts + 1))
+		}
+	} else {
+		updates["status"] = OutboxStatusDone
+		updates["processed_at"] = now
+	}
+	return r.db.WithContext(ctx).Table(r.outbox.table).Where("id = ? AND status = ?", msg.ID, OutboxStatusPending).Updates(updates).Error
+}
+
+// backoff 第attempts次失败后的等待时间
+func (r *OutboxRelay) backoff(attempts int) time.Duration {
+	d := r.backoffBase
+	for i := 1; i < attempts && d < r.backoffLimit; i++ {
+		d *= 2
+	}
+	if d > r.backoffLimit {
+		d = r.backoffLimit
+	}
+	return d
+}
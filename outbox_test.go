@@ -0,0 +1,118 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type outboxEvent struct {
+	Name string `json:"name"`
+}
+
+func (outboxEvent) Topic() string {
+	return "user.created"
+}
+
+// pendingEvents 上下文中还没有写入的事件
+func pendingEvents(ctx context.Context) int {
+	bag, _ := ctx.Value(outboxBagCtxKey{}).(*outboxBag)
+	if bag == nil {
+		return 0
+	}
+	bag.mu.Lock()
+	defer bag.mu.Unlock()
+	return len(bag.events)
+}
+
+func TestOutboxRelay(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.on("SKIP LOCKED").returns([]string{"id", "topic", "payload", "status", "attempts"},
+		[]driver.Value{int64(1), "a", []byte("{}"), OutboxStatusPending, int64(0)},
+		[]driver.Value{int64(2), "b", []byte("{}"), OutboxStatusPending, int64(2)},
+	)
+
+	var published []uint64
+	publisher := OutboxPublisherFunc(func(ctx context.Context, msg *OutboxMessage) error {
+		// 投递时认领事务已经提交
+		if open := fake.openTxs(); open != 0 {
+			t.Errorf("publish inside %d open transactions", open)
+		}
+		published = append(published, msg.ID)
+		if msg.ID == 2 {
+			return errors.New("broker down")
+		}
+		return nil
+	})
+	relay := NewOutboxRelay(db, NewOutbox(), publisher, WithRelayMaxAttempts(3))
+	n, err := relay.ProcessOnce(context.Background())
+	if err != nil || n != 2 || len(published) != 2 {
+		t.Fatalf("ProcessOnce() = %d, %v, published %v", n, err, published)
+	}
+
+	updates := fake.executed("UPDATE `outbox_messages`")
+	if len(updates) != 3 {
+		t.Fatalf("updates = %v, want claim and two marks", updates)
+	}
+	if claim := updates[0]; !claim.InTx || !strings.Contains(claim.SQL, "next_attempt_at") || !strings.Contains(claim.SQL, "id IN") {
+		t.Fatalf("claim = %+v, want next_attempt_at pushed in the claim transaction", claim)
+	}
+	if done := updates[1]; !containsArg(done.Args, OutboxStatusDone) {
+		t.Errorf("first message mark = %v, want done", done.Args)
+	}
+	if dead := updates[2]; !containsArg(dead.Args, OutboxStatusDead) || !containsArg(dead.Args, "broker down") {
+		t.Errorf("second message mark = %v, want dead with last error", dead.Args)
+	}
+}
+
+func containsArg(args []driver.Value, want any) bool {
+	for _, arg := range args {
+		if arg == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOutboxWriteRollback(t *testing.T) {
+	db, fake := newFakeDB(t)
+	a := NewAction[User](WithDB[User](db), WithOutbox[User](NewOutbox()))
+	ctx := WithOutboxEvents(context.Background(), outboxEvent{Name: "tom"})
+
+	// 外层事务回滚, 事件放回上下文
+	err := Transaction(ctx, db, func(tx *gorm.DB) error {
+		if err := a.WithDB(tx).WithContext(ctx).Create(&User{Name: "tom"}); err != nil {
+			return err
+		}
+		if pendingEvents(ctx) != 0 {
+			t.Error("events should be taken by the mutation")
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) || pendingEvents(ctx) != 1 {
+		t.Fatalf("Transaction() = %v, pending events = %d, want the event restored", err, pendingEvents(ctx))
+	}
+
+	// gorm原生的事务无法感知回滚, 拒绝写入
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return a.WithDB(tx).WithContext(ctx).Create(&User{Name: "tom"})
+	})
+	if !errors.Is(err, ErrNativeTransaction) || pendingEvents(ctx) != 1 {
+		t.Fatalf("Create() in native transaction = %v, pending events = %d", err, pendingEvents(ctx))
+	}
+
+	if err := a.WithContext(ctx).Create(&User{Name: "tom"}); err != nil || pendingEvents(ctx) != 0 {
+		t.Fatalf("Create() = %v, pending events = %d", err, pendingEvents(ctx))
+	}
+	inserts := fake.executed("INSERT INTO `outbox_messages`")
+	if len(inserts) != 2 || !inserts[1].InTx {
+		t.Fatalf("outbox inserts = %v, want the rolled back one and the committed one", inserts)
+	}
+	if commits, rollbacks := fake.txCounts(); commits != 1 || rollbacks != 2 {
+		t.Fatalf("commits %d, rollbacks %d, want 1 and 2", commits, rollbacks)
+	}
+}
@@ -0,0 +1,226 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+var _ IPolicy = (*ReadWritePolicy)(nil)
+var _ IPolicy = (PolicyFunc)(nil)
+
+// ErrPermissionDenied 行级权限拒绝
+var ErrPermissionDenied = errors.New("permission denied")
+
+// PolicyKind 策略作用的操作类型
+type PolicyKind int8
+
+const (
+	// PolicyRead 查询
+	PolicyRead PolicyKind = iota + 1
+	// PolicyUpdate 更新
+	PolicyUpdate
+	// PolicyDelete 删除
+	PolicyDelete
+)
+
+func (k PolicyKind) String() string {
+	switch k {
+	case PolicyRead:
+		return "read"
+	case PolicyUpdate:
+		return "update"
+	case PolicyDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+type (
+	// IPolicy 行级数据权限策略, 根据上下文中的操作者和操作类型返回附加的查询条件
+	//
+	// 返回error表示拒绝本次操作, 返回的条件会和调用方的条件以AND方式组合
+	IPolicy interface {
+		Conditions(ctx context.Context, actor any, kind PolicyKind) ([]ScopeMethod, error)
+	}
+
+	// PolicyFunc 函数式策略
+	PolicyFunc func(ctx context.Context, actor any, kind PolicyKind) ([]ScopeMethod, error)
+
+	// ReadWritePolicy 读写分离的策略, 未设置的操作类型不附加条件
+	ReadWritePolicy struct {
+		Read   PolicyFunc
+		Update PolicyFunc
+		Delete PolicyFunc
+	}
+
+	// PermissionDeniedError 行级权限拒绝错误
+	PermissionDeniedError struct {
+		// Model 模型名称
+		Model string
+		// Kind 操作类型
+		Kind PolicyKind
+		// Actor 操作者
+		Actor any
+		// Reason 拒绝原因, 可能为nil
+		Reason error
+	}
+
+	actorCtxKey struct{}
+)
+
+var (
+	policyMutex    sync.RWMutex
+	policyRegistry = make(map[reflect.Type][]IPolicy)
+)
+
+// Conditions 实现IPolicy
+func (f PolicyFunc) Conditions(ctx context.Context, actor any, kind PolicyKind) ([]ScopeMethod, error) {
+	return f(ctx, actor, kind)
+}
+
+// Conditions 实现IPolicy
+func (p *ReadWritePolicy) Conditions(ctx context.Context, actor any, kind PolicyKind) ([]ScopeMethod, error) {
+	var f PolicyFunc
+	switch kind {
+	case PolicyRead:
+		f = p.Read
+	case PolicyUpdate:
+		f = p.Update
+	case PolicyDelete:
+		f = p.Delete
+	}
+	if f == nil {
+		return nil, nil
+	}
+	return f(ctx, actor, kind)
+}
+
+func (e *PermissionDeniedError) Error() string {
+	msg := fmt.Sprintf("%s: %s on %s", ErrPermissionDenied, e.Kind, e.Model)
+	if e.Reason != nil && !errors.Is(e.Reason, ErrPermissionDenied) {
+		msg += ": " + e.Reason.Error()
+	}
+	return msg
+}
+
+// Unwrap 支持errors.Is(err, ErrPermissionDenied)
+func (e *PermissionDeniedError) Unwrap() []error {
+	if e.Reason == nil {
+		return []error{ErrPermissionDenied}
+	}
+	return []error{ErrPermissionDenied, e.Reason}
+}
+
+// WithActor 在上下文中设置操作者
+func WithActor(ctx context.Context, actor any) context.Context {
+	return context.WithValue(ctx, actorCtxKey{}, actor)
+}
+
+// GetActor 获取上下文中的操作者
+func GetActor(ctx context.Context) (any, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	actor := ctx.Value(actorCtxKey{})
+	return actor, actor != nil
+}
+
+// RegisterPolicy 为模型T注册行级策略, 多个策略之间以AND方式组合
+func RegisterPolicy[T any](policies ...IPolicy) {
+	typ := modelType[T]()
+	policyMutex.Lock()
+	defer policyMutex.Unlock()
+	for _, p := range policies {
+		if p != nil {
+			policyRegistry[typ] = append(policyRegistry[typ], p)
+		}
+	}
+}
+
+// ResetPolicy 清除模型T的行级策略
+func ResetPolicy[T any]() {
+	policyMutex.Lock()
+	defer policyMutex.Unlock()
+	delete(policyRegistry, modelType[T]())
+}
+
+// HasPolicy 模型T是否注册了行级策略
+func HasPolicy[T any]() bool {
+	policyMutex.RLock()
+	defer policyMutex.RUnlock()
+	return len(policyRegistry[modelType[T]()]) > 0
+}
+
+// modelType 获取模型类型
+func modelType[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// policyScopes 获取模型T在当前上下文中需要附加的条件
+func policyScopes[T any](ctx context.Context, kind PolicyKind) ([]ScopeMethod, error) {
+	typ := modelType[T]()
+	policyMutex.RLock()
+	policies := policyRegistry[typ]
+	policyMutex.RUnlock()
+	if len(policies) == 0 {
+		return nil, nil
+	}
+
+	actor, _ := GetActor(ctx)
+	scopes := make([]ScopeMethod, 0, len(policies))
+	for _, p := range policies {
+		conds, err := p.Conditions(ctx, actor, kind)
+		if err != nil {
+			return nil, &PermissionDeniedError{Model: typ.String(), Kind: kind, Actor: actor, Reason: err}
+		}
+		scopes = append(scopes, conds...)
+	}
+	return scopes, nil
+}
+
+// checkWriteDenied 变更未命中任何数据时, 判断是否是被策略过滤掉了
+//
+// 调用方的条件能匹配到数据, 而附加策略条件后一条都匹配不到, 视为无权限, db需要是可复用的会话(例如WithContext之后的DB)
+func checkWriteDenied[T any](ctx context.Context, db *gorm.DB, kind PolicyKind, policies, wheres []ScopeMethod) error {
+	if len(policies) == 0 {
+		return nil
+	}
+	var visible int64
+	if err := db.Scopes(wheres...).Scopes(policies...).Count(&visible).Error; err != nil {
+		return err
+	}
+	if visible > 0 {
+		return nil
+	}
+	var total int64
+	if err := db.Scopes(wheres...).Count(&total).Error; err != nil {
+		return err
+	}
+	if total == 0 {
+		return nil
+	}
+	actor, _ := GetActor(ctx)
+	return &PermissionDeniedError{Model: modelType[T]().String(), Kind: kind, Actor: actor}
+}
+
+// execWithPolicy 附加策略条件后执行变更并返回影响的行数, 未命中数据时检查是否被策略拒绝
+func execWithPolicy[T any](ctx context.Context, db *gorm.DB, kind PolicyKind, wheres []ScopeMethod, exec func(tx *gorm.DB) *gorm.DB) (int64, error) {
+	policies, err := policyScopes[T](ctx, kind)
+	if err != nil {
+		return 0, err
+	}
+	res := exec(db.Scopes(wheres...).Scopes(policies...))
+	if res.Error != nil {
+		return 0, translateResult[T](ctx, res)
+	}
+	if res.RowsAffected == 0 && !db.DryRun {
+		return 0, checkWriteDenied[T](ctx, db, kind, policies, wheres)
+	}
+	return res.RowsAffected, nil
+}
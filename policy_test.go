@@ -0,0 +1,113 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type policyDoc struct {
+	ID      uint32
+	OwnerID uint32
+	Title   string
+}
+
+// ownerPolicy 只能访问自己的数据, 没有操作者时拒绝
+func ownerPolicy(_ context.Context, actor any, _ PolicyKind) ([]ScopeMethod, error) {
+	id, ok := actor.(uint32)
+	if !ok {
+		return nil, errors.New("actor required")
+	}
+	return []ScopeMethod{func(db *gorm.DB) *gorm.DB {
+		return db.Where("owner_id = ?", id)
+	}}, nil
+}
+
+func TestPolicyScopes(t *testing.T) {
+	RegisterPolicy[policyDoc](PolicyFunc(ownerPolicy))
+	defer ResetPolicy[policyDoc]()
+
+	db, fake := newFakeDB(t)
+	fake.on("SELECT * FROM `policy_docs`").returns([]string{"id", "owner_id", "title"}, []driver.Value{int64(1), int64(7), "doc"})
+	fake.on("count(*)").returns([]string{"count(*)"}, []driver.Value{int64(1)})
+
+	a := NewAction[policyDoc](WithDB[policyDoc](db)).WithContext(WithActor(context.Background(), uint32(7)))
+	if _, err := a.First(WhereID(1)); err != nil {
+		t.Fatalf("First() = %v", err)
+	}
+	if _, err := a.List(NewPage(1, 10)); err != nil {
+		t.Fatalf("List() = %v", err)
+	}
+	if _, err := a.Count(); err != nil {
+		t.Fatalf("Count() = %v", err)
+	}
+	if err := a.UpdateMap(map[string]any{"title": "new"}, WhereID(1)); err != nil {
+		t.Fatalf("UpdateMap() = %v", err)
+	}
+	if err := a.Delete(WhereID(1)); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+
+	for _, prefix := range []string{"SELECT * FROM", "SELECT count(*)", "UPDATE", "DELETE"} {
+		stmts := fake.executed(prefix)
+		if len(stmts) == 0 {
+			t.Fatalf("no %s statement executed", prefix)
+		}
+		for _, stmt := range stmts {
+			if !strings.Contains(stmt.SQL, "owner_id = ?") || stmt.Args[len(stmt.Args)-1] != uint32(7) {
+				t.Errorf("%s without policy condition: %s %v", prefix, stmt.SQL, stmt.Args)
+			}
+		}
+	}
+}
+
+func TestPolicyScopesWithoutActor(t *testing.T) {
+	RegisterPolicy[policyDoc](PolicyFunc(ownerPolicy))
+	defer ResetPolicy[policyDoc]()
+
+	_, err := policyScopes[policyDoc](context.Background(), PolicyRead)
+	var denied *PermissionDeniedError
+	if !errors.As(err, &denied) || denied.Kind != PolicyRead || denied.Reason == nil {
+		t.Fatalf("policyScopes() = %v, want PermissionDeniedError with reason", err)
+	}
+
+	db, fake := newFakeDB(t)
+	a := NewAction[policyDoc](WithDB[policyDoc](db))
+	if _, err := a.First(); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("First() = %v, want ErrPermissionDenied", err)
+	}
+	if err := a.Delete(WhereID(1)); !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("Delete() = %v, want ErrPermissionDenied", err)
+	}
+	if stmts := fake.executed(""); len(stmts) != 0 {
+		t.Fatalf("denied operations executed %v", stmts)
+	}
+}
+
+func TestPolicyWriteDenied(t *testing.T) {
+	RegisterPolicy[policyDoc](PolicyFunc(ownerPolicy))
+	defer ResetPolicy[policyDoc]()
+
+	db, fake := newFakeDB(t)
+	// 不附加策略时能匹配到数据, 附加策略后匹配不到
+	fake.on("count(*)").returns([]string{"count(*)"}, []driver.Value{int64(1)})
+	fake.on("owner_id = ?").returns([]string{"count(*)"}, []driver.Value{int64(0)})
+	fake.on("UPDATE").affects(0)
+
+	a := NewAction[policyDoc](WithDB[policyDoc](db)).WithContext(WithActor(context.Background(), uint32(8)))
+	err := a.UpdateMap(map[string]any{"title": "new"}, WhereID(1))
+	var denied *PermissionDeniedError
+	if !errors.As(err, &denied) || denied.Kind != PolicyUpdate || denied.Actor != uint32(8) {
+		t.Fatalf("UpdateMap() = %v, want PermissionDeniedError for update", err)
+	}
+
+	// 数据本身不存在时不是权限问题
+	fake.on("count(*)").returns([]string{"count(*)"}, []driver.Value{int64(0)})
+	if err := a.UpdateMap(map[string]any{"title": "new"}, WhereID(2)); err != nil {
+		t.Fatalf("UpdateMap() on missing row = %v, want nil", err)
+	}
+}
@@ -17,6 +17,7 @@ type (
 		CloseTrace() Tracer
 	}
 
+	// ICtx 操作获取上下文, 动作本身实现了ICtx, 操作在每次调用时读取链上通过WithContext绑定的上下文
 	ICtx interface {
 		GetCtx() context.Context
 	}
@@ -26,6 +27,7 @@ type (
 		IOperation[T]
 		IOperationX[T]
 		IBind[T]
+		IDryRun[T]
 
 		IAssociation
 	}
@@ -35,6 +37,20 @@ type (
 		ctx   context.Context
 		table schema.Tabler
 
+		entityCache *EntityCache
+		queryCache  *QueryCache
+		coalescer   *Coalescer
+		auditor     *Auditor
+		outbox      *Outbox
+		retry       *RetryPolicy
+		timeouts    Timeouts
+		guard       *Guard
+		dryRun      bool
+
+		interceptors []Interceptor
+		// xState X方法的错误状态, NewAction和derive为每个动作创建独立的状态
+		xState *xState
+
 		IAssociation
 		IOperation[T]
 		IOperationX[T]
@@ -60,7 +76,8 @@ func NewCtx(ctx context.Context) ICtx {
 // NewAction 创建GORM操作接口实例
 func NewAction[T any](opts ...ActionOption[T]) IAction[T] {
 	ac := action[T]{
-		ctx: context.Background(),
+		ctx:    context.Background(),
+		xState: newXState(),
 
 		Tracer: NewITracer(),
 	}
@@ -70,14 +87,16 @@ func NewAction[T any](opts ...ActionOption[T]) IAction[T] {
 	}
 
 	if ac.table != nil {
-		ac.db = ac.db.Table(ac.table.TableName())
+		ac.db = reusableSession(ac.db.Table(ac.table.TableName()))
 	}
 
 	if ac.IAssociation == nil {
-		ac.IAssociation = NewDefaultAssociation(ac.db)
+		ac.IAssociation = ac.defaultAssociation()
 	}
 
-	WithICtx[T](NewCtx(ac.ctx))(&ac)
+	ac.registerMaskedColumns()
+
+	WithICtx[T](&ac)(&ac)
 
 	return &ac
 }
@@ -91,6 +110,12 @@ func WithICtx[T any](ctx ICtx) ActionOption[T] {
 					WithOperationQueryICtx[T](ctx),
 					WithOperationQueryTracer[T](a.Tracer),
 					WithOperationQueryIBind[T](a),
+					WithOperationQueryEntityCache[T](a.entityCache),
+					WithOperationQueryQueryCache[T](a.queryCache),
+					WithOperationQueryCoalescer[T](a.coalescer),
+					WithOperationQueryRetry[T](a.retry),
+					WithOperationQueryTimeouts[T](a.timeouts),
+					WithOperationQueryGuard[T](a.guard),
 				),
 			),
 			WithOperationMutation[T](
@@ -98,6 +123,13 @@ func WithICtx[T any](ctx ICtx) ActionOption[T] {
 					WithOperationMutationICtx[T](ctx),
 					WithOperationMutationTracer[T](a.Tracer),
 					WithOperationMutationIBind[T](a),
+					WithOperationMutationEntityCache[T](a.entityCache),
+					WithOperationMutationQueryCache[T](a.queryCache),
+					WithOperationMutationAuditor[T](a.auditor),
+					WithOperationMutationOutbox[T](a.outbox),
+					WithOperationMutationRetry[T](a.retry),
+					WithOperationMutationTimeouts[T](a.timeouts),
+					WithOperationMutationGuard[T](a.guard),
 				),
 			),
 		)
@@ -107,6 +139,12 @@ func WithICtx[T any](ctx ICtx) ActionOption[T] {
 					WithOperationQueryXICtx[T](ctx),
 					WithOperationQueryXTracer[T](a.Tracer),
 					WithOperationQueryXIBind[T](a),
+					WithOperationQueryXEntityCache[T](a.entityCache),
+					WithOperationQueryXQueryCache[T](a.queryCache),
+					WithOperationQueryXCoalescer[T](a.coalescer),
+					WithOperationQueryXRetry[T](a.retry),
+					WithOperationQueryXTimeouts[T](a.timeouts),
+					WithOperationQueryXGuard[T](a.guard),
 				),
 			),
 			WithOperationMutationX[T](
@@ -114,25 +152,108 @@ func WithICtx[T any](ctx ICtx) ActionOption[T] {
 					WithOperationMutationXICtx[T](ctx),
 					WithOperationMutationXTracer[T](a.Tracer),
 					WithOperationMutationXIBind[T](a),
+					WithOperationMutationXEntityCache[T](a.entityCache),
+					WithOperationMutationXQueryCache[T](a.queryCache),
+					WithOperationMutationXAuditor[T](a.auditor),
+					WithOperationMutationXOutbox[T](a.outbox),
+					WithOperationMutationXRetry[T](a.retry),
+					WithOperationMutationXTimeouts[T](a.timeouts),
+					WithOperationMutationXGuard[T](a.guard),
 				),
 			),
 		)
+		if len(a.interceptors) > 0 {
+			// X方法基于未包裹的IOperation实现, 避免拦截器执行两次
+			a.IOperationX = NewInterceptedOperationX[T](a.IOperation, a, ctx, a.interceptors...)
+			a.IOperation = NewInterceptedOperation[T](a.IOperation, a, ctx, a.interceptors...)
+		}
+		a.IOperationX = bindXState(a.IOperationX, a.xState)
+	}
+}
+
+// derive 复制出新的动作并应用fn, 重新创建绑定到新动作的操作, 原动作不受影响
+//
+// 链式方法都通过derive返回新动作, 同一个动作可以作为单例在多个请求和goroutine中使用, 新动作的X方法使用独立的错误状态
+func (a *action[T]) derive(fn func(d *action[T])) IAction[T] {
+	d := *a
+	d.xState = newXState()
+	fn(&d)
+	if _, ok := a.IAssociation.(*defaultAssociation); ok {
+		d.IAssociation = d.defaultAssociation()
+	}
+	d.registerMaskedColumns()
+	WithICtx[T](&d)(&d)
+	return &d
+}
+
+// registerMaskedColumns 把模型上带mask标签的字段注册到动作实际使用的表, WithTable、WithDB、WithModel之后表和模型可能变化, 每次派生都重新注册
+func (a *action[T]) registerMaskedColumns() {
+	if a.db == nil {
+		return
+	}
+	db := a.DB()
+	var m T
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(&m); err == nil {
+		registerModelMaskedColumns(stmt.Schema, db.Statement.Table)
+	}
+	// WithModel指定的模型
+	if model := a.db.Statement.Model; model != nil {
+		stmt := &gorm.Statement{DB: a.db}
+		if err := stmt.Parse(model); err == nil {
+			registerModelMaskedColumns(stmt.Schema, a.db.Statement.Table)
+		}
+	}
+}
+
+// GetCtx 链上绑定的上下文, 动作本身作为所有操作的ICtx, 查询、变更、拦截器和链路追踪都从这里获取上下文
+func (a *action[T]) GetCtx() context.Context {
+	if a.ctx == nil {
+		return context.Background()
+	}
+	return a.ctx
+}
+
+// defaultAssociation 基于当前DB和上下文的默认关联操作, 开启DryRun时使用DryRun会话
+func (a *action[T]) defaultAssociation() IAssociation {
+	if a.db == nil {
+		return NewDefaultAssociation(nil)
+	}
+	db := operationDB(a.GetCtx(), a.db)
+	if a.dryRun {
+		db = dryRunSession(db)
 	}
+	return NewDefaultAssociation(db)
 }
 
-// DB 获取DB, 包含了Table或Model, 用于链式操作
+// reusableSession 链式调用后的DB不能复用, 转换为可复用的会话, 之后的每次调用都基于它的副本
+func reusableSession(db *gorm.DB) *gorm.DB {
+	if db == nil {
+		return nil
+	}
+	return db.Session(&gorm.Session{})
+}
+
+// DB 获取DB, 包含了Table或Model, 用于链式操作, 开启DryRun时返回DryRun会话
 func (a *action[T]) DB() *gorm.DB {
+	var db *gorm.DB
 	if a.table != nil {
-		return a.db.Table(a.table.TableName())
+		db = a.db.Table(a.table.TableName())
+	} else {
+		var m T
+		db = a.db.Model(&m)
 	}
-	var m T
-	return a.db.Model(&m)
+	if a.dryRun {
+		return dryRunSession(db)
+	}
+	return db
 }
 
 // Clauses 设置Clauses
 func (a *action[T]) Clauses(condList ...clause.Expression) IAction[T] {
-	a.db = a.db.Clauses(condList...)
-	return a
+	return a.derive(func(d *action[T]) {
+		d.db = reusableSession(a.db.Clauses(condList...))
+	})
 }
 
 // Order 跳转到排序动作
@@ -142,46 +263,81 @@ func (a *action[T]) Order(column string) IOrder[T] {
 
 // WithDB 设置DB, 一般用于事务, 这里使用事务的DB, 也可以设置新的DB用于链式操作
 func (a *action[T]) WithDB(db *gorm.DB) IAction[T] {
-	a.db = db
-	return a
+	return a.derive(func(d *action[T]) {
+		d.db = reusableSession(db)
+	})
 }
 
-// WithContext 设置上下文Ctx
+// WithContext 设置上下文Ctx, 取消、超时和链路追踪的父span对之后的所有操作生效
 func (a *action[T]) WithContext(ctx context.Context) IAction[T] {
-	a.ctx = ctx
-	return a
+	ctx = a.bindableCtx(ctx)
+	return a.derive(func(d *action[T]) {
+		d.ctx = ctx
+	})
+}
+
+// bindableCtx 替换到链上的上下文, nil视为Background; ToSQL中替换上下文时继续记录语句
+func (a *action[T]) bindableCtx(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if rec := sqlRecorderOf(a.ctx); rec != nil && sqlRecorderOf(ctx) == nil {
+		ctx = context.WithValue(ctx, sqlRecorderCtxKey{}, rec)
+	}
+	return ctx
+}
+
+// operationWithContext 绑定到ctx且不包裹拦截器的操作, 拦截器替换上下文后由拦截器链用它执行操作
+func (a *action[T]) operationWithContext(ctx context.Context) IOperation[T] {
+	ctx = a.bindableCtx(ctx)
+	d := a.derive(func(d *action[T]) {
+		d.ctx = ctx
+		d.interceptors = nil
+	})
+	return d.(*action[T]).IOperation
 }
 
 // WithTable 设置Table, 这里传递的是实现了schema.Tabler接口的结构体
 func (a *action[T]) WithTable(tabler schema.Tabler) IAction[T] {
-	a.table = tabler
-	return a
+	return a.derive(func(d *action[T]) {
+		d.table = tabler
+	})
 }
 
 // WithModel 设置Model, Model规范参考: https://gorm.io/zh_CN/docs/models.html
 func (a *action[T]) WithModel(model any) IAction[T] {
-	a.db = a.db.Model(model)
-	return a
+	return a.derive(func(d *action[T]) {
+		d.db = reusableSession(a.db.Model(model))
+	})
 }
 
 // Preload 预加载, 参考: https://gorm.io/zh_CN/docs/preload.html
 func (a *action[T]) Preload(preloadKey string, wheres ...ScopeMethod) IAction[T] {
-	a.db = a.db.Preload(preloadKey, func(db *gorm.DB) *gorm.DB {
-		return db.Scopes(wheres...)
+	return a.derive(func(d *action[T]) {
+		d.db = reusableSession(a.db.Preload(preloadKey, func(db *gorm.DB) *gorm.DB {
+			return db.Scopes(wheres...)
+		}))
 	})
-	return a
 }
 
 // Joins 设置关联, 参考: https://gorm.io/zh_CN/docs/preload.html#Joins-%E9%A2%84%E5%8A%A0%E8%BD%BD
 func (a *action[T]) Joins(joinsKey string, wheres ...ScopeMethod) IAction[T] {
-	a.db = a.db.Joins(joinsKey, func(db *gorm.DB) *gorm.DB {
-		return db.Scopes(wheres...)
+	return a.derive(func(d *action[T]) {
+		d.db = reusableSession(a.db.Joins(joinsKey, func(db *gorm.DB) *gorm.DB {
+			return db.Scopes(wheres...)
+		}))
 	})
-	return a
 }
 
 // Scopes 设置作用域, 参考: https://gorm.io/zh_CN/docs/scopes.html
+//
+// 作用域在派生时立即应用, 链上的条件和操作传入的条件一样参与无界读写的防护检查
 func (a *action[T]) Scopes(wheres ...ScopeMethod) IAction[T] {
-	a.db = a.db.Scopes(wheres...)
-	return a
+	return a.derive(func(d *action[T]) {
+		db := a.db
+		for _, where := range wheres {
+			db = where(db)
+		}
+		d.db = reusableSession(db)
+	})
 }
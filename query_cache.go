@@ -0,0 +1,207 @@
+package query
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultQueryCacheTTL = 30 * time.Second
+
+	cacheTTLSettingKey = "gorm-normalize:cache_ttl"
+)
+
+// errUncacheablePool 连接池不是指针, 无法作为缓存key的一部分
+var errUncacheablePool = errors.New("connection pool cannot be identified")
+
+type (
+	// QueryCache List/Count结果缓存, 以渲染后的SQL和参数作为key, 以表名作为tag
+	//
+	// 通过IAction对该表的任意变更都会失效该表的所有结果缓存
+	QueryCache struct {
+		cache ICache
+		ttl   time.Duration
+	}
+
+	QueryCacheOption func(*QueryCache)
+
+	// listCacheEntry 列表缓存, 分页时同时缓存总数
+	listCacheEntry[T any] struct {
+		Items []T
+		Total int64
+	}
+
+	cacheBypassCtxKey struct{}
+)
+
+// NewQueryCache 创建结果缓存, 默认缓存30秒
+func NewQueryCache(cache ICache, opts ...QueryCacheOption) *QueryCache {
+	c := &QueryCache{
+		cache: cache,
+		ttl:   defaultQueryCacheTTL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithQueryCacheTTL 设置默认缓存时间
+func WithQueryCacheTTL(ttl time.Duration) QueryCacheOption {
+	return func(c *QueryCache) {
+		c.ttl = ttl
+	}
+}
+
+// CacheTTL 设置本次查询的缓存时间, ttl<=0表示本次查询不缓存
+func CacheTTL(ttl time.Duration) ScopeMethod {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Set(cacheTTLSettingKey, ttl)
+	}
+}
+
+// WithoutCache 跳过缓存, 直接查询数据库
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassCtxKey{}, true)
+}
+
+// IsCacheBypassed 上下文是否跳过缓存
+func IsCacheBypassed(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	bypass, _ := ctx.Value(cacheBypassCtxKey{}).(bool)
+	return bypass
+}
+
+// Invalidate 失效表的所有结果缓存
+func (c *QueryCache) Invalidate(ctx context.Context, table string) {
+	if c == nil || c.cache == nil {
+		return
+	}
+	c.cache.InvalidateTags(ctx, queryCacheTag(table))
+}
+
+// key 通过DryRun渲染SQL生成缓存key, 同时解析本次查询的缓存时间
+//
+// 同一个缓存可能被连接不同数据库的IAction共享, key中包含连接池的标识, 无法区分连接池时不缓存
+func (c *QueryCache) key(db *gorm.DB, table, kind string, exec func(tx *gorm.DB) *gorm.DB) (string, time.Duration, error) {
+	pool, ok := connPoolKey(db.Statement.ConnPool)
+	if !ok {
+		return "", 0, errUncacheablePool
+	}
+	key, res, err := renderKey(db, exec)
+	if err != nil {
+		return "", 0, err
+	}
+	ttl := c.ttl
+	if v, ok := res.Get(cacheTTLSettingKey); ok {
+		if d, ok := v.(time.Duration); ok {
+			ttl = d
+		}
+	}
+	return "query:" + pool + ":" + table + ":" + kind + ":" + key, ttl, nil
+}
+
+// renderKey 通过DryRun渲染SQL, 以SQL和参数的摘要作为key
+func renderKey(db *gorm.DB, exec func(tx *gorm.DB) *gorm.DB) (string, *gorm.DB, error) {
+	res := exec(db.Session(&gorm.Session{DryRun: true}))
+	if res.Error != nil {
+		return "", nil, res.Error
+	}
+	h := sha1.New()
+	h.Write([]byte(res.Statement.SQL.String()))
+	for _, v := range res.Statement.Vars {
+		_, _ = fmt.Fprintf(h, "|%#v", v)
+	}
+	return hex.EncodeToString(h.Sum(nil)), res, nil
+}
+
+// queryCacheable db的结果能否放入结果缓存
+//
+// 预加载不体现在渲染的SQL中, 条件还可能是函数, 无法作为key的一部分; 事务中可能读到未提交的数据; 这两种情况都不缓存
+func queryCacheable(db *gorm.DB) bool {
+	return !db.DryRun && !inTransaction(db) && len(db.Statement.Preloads) == 0
+}
+
+// queryCacheTag 结果缓存tag
+func queryCacheTag(table string) string {
+	return "query:" + table
+}
+
+// cachedList 读穿列表缓存, db为附加了查询条件的DB, 分页总数和列表一起缓存
+func cachedList[T any](ctx context.Context, c *QueryCache, db *gorm.DB, pgInfo Pagination, load func() ([]*T, error)) ([]*T, error) {
+	if c == nil || c.cache == nil || IsCacheBypassed(ctx) || !queryCacheable(db) {
+		return load()
+	}
+	table := tableNameOf[T](db)
+	key, ttl, err := c.key(db, table, "list", func(tx *gorm.DB) *gorm.DB {
+		var ms []*T
+		return tx.Scopes(Paginate(pgInfo)).Find(&ms)
+	})
+	if err != nil || ttl <= 0 {
+		return load()
+	}
+
+	if v, ok := c.cache.Get(ctx, key); ok {
+		if entry, ok := v.(*listCacheEntry[T]); ok {
+			if pgInfo != nil {
+				pgInfo.SetTotal(entry.Total)
+			}
+			ms := make([]*T, 0, len(entry.Items))
+			for i := range entry.Items {
+				m := entry.Items[i]
+				ms = append(ms, &m)
+			}
+			return ms, nil
+		}
+	}
+
+	ms, err := load()
+	if err != nil {
+		return nil, err
+	}
+	entry := &listCacheEntry[T]{Items: make([]T, 0, len(ms))}
+	if pgInfo != nil {
+		entry.Total = pgInfo.GetTotal()
+	}
+	for _, m := range ms {
+		entry.Items = append(entry.Items, *m)
+	}
+	c.cache.Set(ctx, key, entry, ttl, queryCacheTag(table))
+	return ms, nil
+}
+
+// cachedCount 读穿数量缓存
+func cachedCount[T any](ctx context.Context, c *QueryCache, db *gorm.DB, load func() (int64, error)) (int64, error) {
+	if c == nil || c.cache == nil || IsCacheBypassed(ctx) || !queryCacheable(db) {
+		return load()
+	}
+	table := tableNameOf[T](db)
+	key, ttl, err := c.key(db, table, "count", func(tx *gorm.DB) *gorm.DB {
+		var total int64
+		return tx.Count(&total)
+	})
+	if err != nil || ttl <= 0 {
+		return load()
+	}
+
+	if v, ok := c.cache.Get(ctx, key); ok {
+		if total, ok := v.(int64); ok {
+			return total, nil
+		}
+	}
+
+	total, err := load()
+	if err != nil {
+		return 0, err
+	}
+	c.cache.Set(ctx, key, total, ttl, queryCacheTag(table))
+	return total, nil
+}
@@ -0,0 +1,145 @@
+package query
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestQueryCacheInvalidation(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.on("SELECT * FROM `users`").returns([]string{"id", "name"}, []driver.Value{int64(1), "a"}, []driver.Value{int64(2), "b"})
+	fake.on("count(*)").returns([]string{"count(*)"}, []driver.Value{int64(12)})
+	a := NewAction[User](WithDB[User](db), WithQueryCache[User](NewQueryCache(NewLRUCache(10))))
+	lists := func() int { return len(fake.executed("SELECT * FROM `users`")) }
+	counts := func() int { return len(fake.executed("count(*)")) }
+
+	// 分页总数和列表一起缓存
+	for i := 0; i < 2; i++ {
+		page := NewPage(1, 2)
+		ms, err := a.List(page)
+		if err != nil || len(ms) != 2 || page.GetTotal() != 12 {
+			t.Fatalf("List() = %d rows, total %d, %v", len(ms), page.GetTotal(), err)
+		}
+	}
+	if lists() != 1 || counts() != 1 {
+		t.Fatalf("cached List executed %d lists and %d counts, want 1 and 1", lists(), counts())
+	}
+	if _, err := a.List(NewPage(2, 2)); err != nil || lists() != 2 {
+		t.Fatalf("another page should not hit the cache, lists = %d, err = %v", lists(), err)
+	}
+
+	mutations := map[string]func() error{
+		"Create": func() error { return a.Create(&User{Name: "c"}) },
+		"Update": func() error { return a.UpdateMap(map[string]any{"name": "d"}, WhereID(1)) },
+		"Delete": func() error { return a.Delete(WhereID(2)) },
+	}
+	for _, name := range []string{"Create", "Update", "Delete"} {
+		if _, err := a.List(NewPage(1, 2)); err != nil {
+			t.Fatal(err)
+		}
+		before := lists()
+		if err := mutations[name](); err != nil {
+			t.Fatalf("%s() = %v", name, err)
+		}
+		if _, err := a.List(NewPage(1, 2)); err != nil || lists() != before+1 {
+			t.Fatalf("%s should invalidate the list cache, lists = %d, want %d", name, lists(), before+1)
+		}
+		before = counts()
+		if _, err := a.Count(); err != nil || counts() != before+1 {
+			t.Fatalf("Count after %s = %d queries, want %d", name, counts(), before+1)
+		}
+	}
+}
+
+func TestQueryCacheBypass(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.on("FROM `cache_owners`").returns([]string{"id", "name"}, []driver.Value{int64(1), "a"})
+	fake.on("FROM `cache_pets`").returns([]string{"id", "cache_owner_id"}, []driver.Value{int64(9), int64(1)})
+	a := NewAction[cacheOwner](WithDB[cacheOwner](db), WithQueryCache[cacheOwner](NewQueryCache(NewLRUCache(10))))
+
+	if ms, err := a.List(nil); err != nil || len(ms[0].Pets) != 0 {
+		t.Fatalf("List() = %+v, %v", ms, err)
+	}
+	// 预加载不体现在SQL中, 不能复用不带预加载的缓存
+	ms, err := a.Preload("Pets").List(nil)
+	if err != nil || len(ms) != 1 || len(ms[0].Pets) != 1 {
+		t.Fatalf("Preload List() = %+v, %v, want preloaded pets", ms, err)
+	}
+	if ms, _ := a.List(nil); len(ms[0].Pets) != 0 {
+		t.Fatalf("plain List() served preloaded rows: %+v", ms)
+	}
+
+	before := len(fake.executed("FROM `cache_owners`"))
+	_ = db.Transaction(func(tx *gorm.DB) error {
+		_, err := a.WithDB(tx).List(nil)
+		return err
+	})
+	_ = Transaction(context.Background(), db, func(tx *gorm.DB) error {
+		_, err := a.WithDB(tx).List(nil)
+		return err
+	})
+	if got := len(fake.executed("FROM `cache_owners`")); got != before+2 {
+		t.Fatalf("List in transaction should bypass the cache, executed %d, want %d", got-before, 2)
+	}
+}
+
+func TestQueryCacheSharedPools(t *testing.T) {
+	queryCache := NewQueryCache(NewLRUCache(10))
+	entityCache := NewEntityCache(NewLRUCache(10))
+	columns := []string{"id", "name"}
+	db1, f1 := newFakeDB(t)
+	f1.on("FROM `cache_owners`").returns(columns, []driver.Value{int64(1), "db1"})
+	db2, f2 := newFakeDB(t)
+	f2.on("FROM `cache_owners`").returns(columns, []driver.Value{int64(1), "db2"})
+
+	// 共享的缓存不能把一个数据库的结果返回给另一个数据库
+	for name, db := range map[string]*gorm.DB{"db1": db1, "db2": db2} {
+		a := NewAction[cacheOwner](WithDB[cacheOwner](db), WithQueryCache[cacheOwner](queryCache), WithEntityCache[cacheOwner](entityCache))
+		for i := 0; i < 2; i++ {
+			if ms, err := a.List(nil); err != nil || len(ms) != 1 || ms[0].Name != name {
+				t.Fatalf("%s: List() = %+v, %v", name, ms, err)
+			}
+			if m, err := a.FirstByID(1); err != nil || m.Name != name {
+				t.Fatalf("%s: FirstByID() = %+v, %v", name, m, err)
+			}
+		}
+	}
+	if n1, n2 := len(f1.executed("FROM `cache_owners`")), len(f2.executed("FROM `cache_owners`")); n1 != 2 || n2 != 2 {
+		t.Fatalf("executed %d and %d, want one list and one lookup on each database", n1, n2)
+	}
+
+	// 按ID失效所有数据库中的缓存
+	a := NewAction[cacheOwner](WithDB[cacheOwner](db1), WithQueryCache[cacheOwner](queryCache), WithEntityCache[cacheOwner](entityCache))
+	if err := a.UpdateMap(map[string]any{"name": "x"}, WhereID(1)); err != nil {
+		t.Fatal(err)
+	}
+	b := NewAction[cacheOwner](WithDB[cacheOwner](db2), WithEntityCache[cacheOwner](entityCache))
+	if _, err := b.FirstByID(1); err != nil || len(f2.executed("FROM `cache_owners`")) != 3 {
+		t.Fatalf("FirstByID() on db2 = %v, want the invalidated entry reloaded", err)
+	}
+}
+
+func TestQueryCacheAfterCommit(t *testing.T) {
+	db, fake := newFakeDB(t)
+	fake.on("FROM `users`").returns([]string{"id", "name"}, []driver.Value{int64(1), "old"})
+	a := NewAction[User](WithDB[User](db), WithQueryCache[User](NewQueryCache(NewLRUCache(10))))
+
+	err := Transaction(context.Background(), db, func(tx *gorm.DB) error {
+		if err := a.WithDB(tx).UpdateMap(map[string]any{"name": "new"}, WhereID(1)); err != nil {
+			return err
+		}
+		// 提交前的并发读取把旧数据放回缓存
+		_, err := a.List(nil)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.on("FROM `users`").returns([]string{"id", "name"}, []driver.Value{int64(1), "new"})
+	if ms, err := a.List(nil); err != nil || len(ms) != 1 || ms[0].Name != "new" {
+		t.Fatalf("List() after commit = %+v, %v, want the committed row", ms, err)
+	}
+}
@@ -0,0 +1,390 @@
+package query
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	// maskTagKey 模型字段标签, 例如 `normalize:"mask"` 表示该字段的值在trace和日志中脱敏
+	maskTagKey = "normalize"
+	maskTagVal = "mask"
+
+	defaultMask = "***"
+)
+
+// RedactionMode SQL输出方式
+type RedactionMode int8
+
+const (
+	// RedactParameterized 只输出参数化SQL, 不包含任何参数值, 默认方式
+	RedactParameterized RedactionMode = iota
+	// RedactMasked 输出带参数值的SQL, 敏感列和ValueRedactor命中的值会被脱敏
+	RedactMasked
+	// RedactNone 原样输出带参数值的SQL, 仅用于本地调试
+	RedactNone
+)
+
+var _ logger.Interface = (*redactedLogger)(nil)
+var _ gorm.ParamsFilter = (*redactedLogger)(nil)
+
+type (
+	// ValueRedactor 自定义脱敏, column为参数对应的列名(无法识别时为空), 返回false表示不处理
+	ValueRedactor func(column string, value any) (any, bool)
+
+	// RedactionPolicy 脱敏策略, trace和日志共用
+	RedactionPolicy struct {
+		// Mode 输出方式
+		Mode RedactionMode
+		// Mask 脱敏后的值, 默认***
+		Mask string
+		// Columns 总是脱敏的列, 和模型上带mask标签的字段合并
+		Columns []string
+		// ValueRedactor 自定义脱敏
+		ValueRedactor ValueRedactor
+	}
+
+	// redactedLogger 按脱敏策略过滤gorm日志中的参数
+	redactedLogger struct {
+		logger.Interface
+		policy *RedactionPolicy
+	}
+)
+
+var (
+	defaultRedactionMutex  sync.RWMutex
+	defaultRedactionPolicy = &RedactionPolicy{Mode: RedactParameterized}
+
+	// maskedColumnsByTable 表上需要脱敏的列, 由模型的mask标签注册
+	maskedColumnsByTable sync.Map
+	// registeredMaskSchemas 已注册过的模型和表
+	registeredMaskSchemas sync.Map
+)
+
+type maskSchemaKey struct {
+	schema *schema.Schema
+	table  string
+}
+
+// SetDefaultRedactionPolicy 设置全局默认的脱敏策略
+func SetDefaultRedactionPolicy(p *RedactionPolicy) {
+	if p == nil {
+		return
+	}
+	defaultRedactionMutex.Lock()
+	defer defaultRedactionMutex.Unlock()
+	defaultRedactionPolicy = p
+}
+
+// GetDefaultRedactionPolicy 获取全局默认的脱敏策略
+func GetDefaultRedactionPolicy() *RedactionPolicy {
+	defaultRedactionMutex.RLock()
+	defer defaultRedactionMutex.RUnlock()
+	return defaultRedactionPolicy
+}
+
+// RegisterMaskedColumns 注册表上需要脱敏的列
+func RegisterMaskedColumns(table string, columns ...string) {
+	if table == "" || len(columns) == 0 {
+		return
+	}
+	set := make(map[string]struct{}, len(columns))
+	if v, ok := maskedColumnsByTable.Load(table); ok {
+		for column := range v.(map[string]struct{}) {
+			set[column] = struct{}{}
+		}
+	}
+	for _, column := range columns {
+		set[strings.ToLower(column)] = struct{}{}
+	}
+	maskedColumnsByTable.Store(table, set)
+}
+
+// registerModelMaskedColumns 注册模型上带mask标签的字段, table不为空时同时注册到该表(模型通过Table指定了表名)
+func registerModelMaskedColumns(s *schema.Schema, table string) {
+	if s == nil {
+		return
+	}
+	if table == "" {
+		table = s.Table
+	}
+	if _, loaded := registeredMaskSchemas.LoadOrStore(maskSchemaKey{schema: s, table: table}, struct{}{}); loaded {
+		return
+	}
+	var columns []string
+	for _, field := range s.Fields {
+		if field.DBName != "" && field.Tag.Get(maskTagKey) == maskTagVal {
+			columns = append(columns, field.DBName)
+		}
+	}
+	RegisterMaskedColumns(s.Table, columns...)
+	if table != s.Table {
+		RegisterMaskedColumns(table, columns...)
+	}
+}
+
+// NewRedactedLogger 包裹gorm的logger, 日志中的SQL参数按脱敏策略处理, policy为nil时使用全局默认策略
+func NewRedactedLogger(inner logger.Interface, policy *RedactionPolicy) logger.Interface {
+	return &redactedLogger{Interface: inner, policy: policy}
+}
+
+func (l *redactedLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return &redactedLogger{Interface: l.Interface.LogMode(level), policy: l.policy}
+}
+
+// ParamsFilter 实现gorm.ParamsFilter
+func (l *redactedLogger) ParamsFilter(_ context.Context, sql string, params ...any) (string, []any) {
+	policy := l.policy
+	if policy == nil {
+		policy = GetDefaultRedactionPolicy()
+	}
+	return sql, policy.FilterVars(sql, tableFromSQL(sql), params...)
+}
+
+// Statement 按策略渲染db当前执行的语句
+func (p *RedactionPolicy) Statement(db *gorm.DB) string {
+	sql := db.Statement.SQL.String()
+	if p.Mode == RedactParameterized {
+		return sql
+	}
+	registerModelMaskedColumns(db.Statement.Schema, db.Statement.Table)
+	return p.explain(db.Dialector, sql, db.Statement.Table, db.Statement.Vars...)
+}
+
+// explain 按策略渲染SQL, table为空时从SQL中解析
+func (p *RedactionPolicy) explain(dialector gorm.Dialector, sql, table string, vars ...any) string {
+	if p.Mode == RedactParameterized {
+		return sql
+	}
+	if table == "" {
+		table = tableFromSQL(sql)
+	}
+	return dialector.Explain(sql, p.FilterVars(sql, table, vars...)...)
+}
+
+// FilterVars 按策略处理SQL参数, 参数化方式下返回nil, 渲染出的SQL保留占位符
+//
+// 脱敏方式下, 表上有脱敏列(包括Columns)时无法识别对应列的参数一律脱敏
+func (p *RedactionPolicy) FilterVars(sql, table string, vars ...any) []any {
+	switch p.Mode {
+	case RedactParameterized:
+		return nil
+	case RedactNone:
+		return vars
+	}
+
+	mask := p.Mask
+	if mask == "" {
+		mask = defaultMask
+	}
+	masked := make(map[string]struct{}, len(p.Columns))
+	for _, column := range p.Columns {
+		masked[strings.ToLower(column)] = struct{}{}
+	}
+	if v, ok := maskedColumnsByTable.Load(table); ok {
+		for column := range v.(map[string]struct{}) {
+			masked[column] = struct{}{}
+		}
+	}
+
+	// 表上有脱敏列时, 无法识别对应列的参数(例如 LOWER(email) = ?)也脱敏, 避免漏掉
+	columns := placeholderColumns(sql)
+	filtered := make([]any, len(vars))
+	for i, v := range vars {
+		column, known := columns[i]
+		if _, ok := masked[column]; (ok && column != "") || (!known && len(masked) > 0) {
+			filtered[i] = mask
+			continue
+		}
+		if p.ValueRedactor != nil {
+			if nv, ok := p.ValueRedactor(column, v); ok {
+				filtered[i] = nv
+				continue
+			}
+		}
+		filtered[i] = v
+	}
+	return filtered
+}
+
+// sqlToken SQL词法单元
+type sqlToken struct {
+	text  string
+	ident bool
+}
+
+// tokenizeSQL 简单的SQL分词, 忽略字符串字面量的内容, 标识符去掉引号和表名前缀
+func tokenizeSQL(sql string) []sqlToken {
+	var tokens []sqlToken
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '\'':
+			j := i + 1
+			for j < len(sql) && sql[j] != c {
+				if sql[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			tokens = append(tokens, sqlToken{text: "'"})
+			i = j + 1
+		case c == '`' || c == '"' || isIdentByte(c):
+			var name string
+			for i < len(sql) && (sql[i] == '`' || sql[i] == '"' || isIdentByte(sql[i]) || sql[i] == '.') {
+				if sql[i] == '`' || sql[i] == '"' {
+					j := strings.IndexByte(sql[i+1:], sql[i])
+					if j < 0 {
+						j = len(sql) - i - 1
+					}
+					name = sql[i+1 : i+1+j]
+					i += j + 2
+					continue
+				}
+				if sql[i] == '.' {
+					name = ""
+					i++
+					continue
+				}
+				j := i
+				for j < len(sql) && isIdentByte(sql[j]) {
+					j++
+				}
+				name = sql[i:j]
+				i = j
+			}
+			tokens = append(tokens, sqlToken{text: name, ident: true})
+		case c == '$' && i+1 < len(sql) && sql[i+1] >= '0' && sql[i+1] <= '9':
+			j := i + 1
+			for j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+				j++
+			}
+			tokens = append(tokens, sqlToken{text: sql[i:j]})
+			i = j
+		case strings.HasPrefix(sql[i:], "<>") || strings.HasPrefix(sql[i:], "!=") || strings.HasPrefix(sql[i:], "<=") || strings.HasPrefix(sql[i:], ">="):
+			tokens = append(tokens, sqlToken{text: sql[i : i+2]})
+			i += 2
+		default:
+			tokens = append(tokens, sqlToken{text: string(c)})
+			i++
+		}
+	}
+	return tokens
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// isComparison 标识符之后是否是比较运算, 用于识别参数对应的列
+func isComparison(text string) bool {
+	switch strings.ToUpper(text) {
+	case "=", "<>", "!=", "<", ">", "<=", ">=", "LIKE", "IN", "BETWEEN", "NOT", "IS", "REGEXP":
+		return true
+	}
+	return false
+}
+
+// placeholderColumns 识别SQL中每个参数对应的列名, 下标和参数下标一致
+//
+// 支持 col = ?、col IN (?,?)、col BETWEEN ? AND ?、SET col = ? 以及 INSERT INTO t (a,b) VALUES (?,?);
+// LIMIT、OFFSET这类不对应列的参数为空, 无法识别的参数(例如 LOWER(email) = ?)不在结果中
+func placeholderColumns(sql string) map[int]string {
+	tokens := tokenizeSQL(sql)
+	columns := make(map[int]string)
+
+	var (
+		idx        int
+		current    string
+		attributed bool
+		insertCols []string
+		inValues   bool
+		depth      int
+		pos        int
+	)
+	assign := func(t sqlToken, column string, known bool) {
+		i := idx
+		if strings.HasPrefix(t.text, "$") {
+			n, _ := strconv.Atoi(t.text[1:])
+			i = n - 1
+		} else {
+			idx++
+		}
+		if known {
+			columns[i] = strings.ToLower(column)
+		}
+	}
+
+	for k := 0; k < len(tokens); k++ {
+		t := tokens[k]
+		upper := strings.ToUpper(t.text)
+		switch {
+		case t.ident && upper == "INSERT":
+			insertCols = nil
+		case t.ident && upper == "VALUES" && depth == 0 && insertCols == nil:
+			// INSERT INTO t (a,b) VALUES, 回溯列名
+			for j := k - 1; j >= 0 && tokens[j].text != "("; j-- {
+				if tokens[j].ident {
+					insertCols = append([]string{tokens[j].text}, insertCols...)
+				}
+			}
+			inValues = true
+		case t.ident && (upper == "LIMIT" || upper == "OFFSET"):
+			current, attributed = "", true
+		case t.ident && upper == "ON":
+			// ON DUPLICATE KEY UPDATE / ON CONFLICT 之后按 col = ? 识别
+			inValues = false
+			current, attributed = "", false
+		case t.ident && !isComparison(t.text) && upper != "AND" && upper != "OR":
+			if k+1 < len(tokens) && isComparison(tokens[k+1].text) {
+				current, attributed = t.text, true
+			}
+		case t.text == "(":
+			depth++
+			if inValues && depth == 1 {
+				pos = 0
+			}
+		case t.text == ")":
+			depth--
+			// 函数或表达式的比较, 例如 LOWER(email) = ?, 无法确定对应的列
+			if k+1 < len(tokens) && isComparison(tokens[k+1].text) {
+				current, attributed = "", false
+			}
+		case t.text == ",":
+			if inValues && depth == 1 {
+				pos++
+			}
+		case t.text == "?" || strings.HasPrefix(t.text, "$"):
+			if inValues && depth >= 1 && pos < len(insertCols) {
+				assign(t, insertCols[pos], true)
+			} else if inValues {
+				assign(t, "", false)
+			} else {
+				assign(t, current, attributed)
+			}
+		}
+	}
+	return columns
+}
+
+// tableFromSQL 从SQL中解析主表名
+func tableFromSQL(sql string) string {
+	tokens := tokenizeSQL(sql)
+	for k, t := range tokens {
+		switch strings.ToUpper(t.text) {
+		case "FROM", "INTO", "UPDATE":
+			if k+1 < len(tokens) && tokens[k+1].ident {
+				return tokens[k+1].text
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package query
+
+import (
+	"testing"
+)
+
+type redactAccount struct {
+	ID       uint32
+	Email    string `normalize:"mask"`
+	Nickname string
+}
+
+type redactArchiveTable struct{}
+
+func (redactArchiveTable) TableName() string {
+	return "redact_accounts_archive"
+}
+
+func TestPlaceholderColumns(t *testing.T) {
+	cases := []struct {
+		sql  string
+		want map[int]string
+	}{
+		{
+			sql:  "SELECT * FROM `users` WHERE `users`.`email` = ? AND age BETWEEN ? AND ? AND id IN (?,?) LIMIT ?",
+			want: map[int]string{0: "email", 1: "age", 2: "age", 3: "id", 4: "id", 5: ""},
+		},
+		{
+			sql:  "UPDATE `users` SET `password`=?,`updated_at`=? WHERE `id` = ?",
+			want: map[int]string{0: "password", 1: "updated_at", 2: "id"},
+		},
+		{
+			sql:  "INSERT INTO `users` (`name`,`password`) VALUES (?,?),(?,?)",
+			want: map[int]string{0: "name", 1: "password", 2: "name", 3: "password"},
+		},
+		{
+			sql:  `SELECT * FROM "users" WHERE "password" = $2 AND "name" = $1`,
+			want: map[int]string{1: "password", 0: "name"},
+		},
+		{
+			// 函数包裹的列无法识别, 不能沿用前面的列名
+			sql:  "SELECT * FROM `users` WHERE `name` = ? AND LOWER(email) = ? LIMIT ?",
+			want: map[int]string{0: "name", 2: ""},
+		},
+	}
+	for _, c := range cases {
+		got := placeholderColumns(c.sql)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %v, want %v", c.sql, got, c.want)
+		}
+		for i, column := range c.want {
+			if got[i] != column {
+				t.Errorf("%s: placeholder %d got %q, want %q", c.sql, i, got[i], column)
+			}
+		}
+	}
+}
+
+func TestRedactionPolicyFilterVars(t *testing.T) {
+	RegisterMaskedColumns("redact_users", "password")
+	sql := "UPDATE `redact_users` SET `password`=?,`phone`=? WHERE `id` = ?"
+
+	if vars := (&RedactionPolicy{}).FilterVars(sql, "redact_users", "secret", "123", 1); vars != nil {
+		t.Fatalf("parameterized mode should drop vars, got %v", vars)
+	}
+
+	p := &RedactionPolicy{
+		Mode: RedactMasked,
+		ValueRedactor: func(column string, value any) (any, bool) {
+			if column == "phone" {
+				return "1**", true
+			}
+			return nil, false
+		},
+	}
+	vars := p.FilterVars(sql, tableFromSQL(sql), "secret", "123", 1)
+	if vars[0] != defaultMask || vars[1] != "1**" || vars[2] != 1 {
+		t.Fatalf("unexpected vars %v", vars)
+	}
+}
+
+func TestRedactionPolicyUnattributed(t *testing.T) {
+	RegisterMaskedColumns("redact_users", "password")
+	p := &RedactionPolicy{Mode: RedactMasked}
+
+	// 表上有脱敏列时, 无法识别的参数脱敏, LIMIT不受影响
+	sql := "SELECT * FROM `redact_users` WHERE `name` = ? AND LOWER(password) = ? LIMIT ?"
+	vars := p.FilterVars(sql, "redact_users", "tom", "secret", 10)
+	if vars[0] != "tom" || vars[1] != defaultMask || vars[2] != 10 {
+		t.Fatalf("unexpected vars %v", vars)
+	}
+
+	// 没有脱敏列的表保持原样
+	vars = p.FilterVars("SELECT * FROM `plain` WHERE LOWER(name) = ?", "plain", "tom")
+	if vars[0] != "tom" {
+		t.Fatalf("unexpected vars %v", vars)
+	}
+}
+
+func TestMaskedColumnsOnDerivedAction(t *testing.T) {
+	hasMask := func(table string) bool {
+		v, ok := maskedColumnsByTable.Load(table)
+		if !ok {
+			return false
+		}
+		_, ok = v.(map[string]struct{})["email"]
+		return ok
+	}
+
+	base := NewAction[redactAccount](WithDB[redactAccount](newOfflineDB(t)))
+	if !hasMask("redact_accounts") {
+		t.Fatal("NewAction should register masked columns of the model table")
+	}
+
+	base.WithTable(redactArchiveTable{})
+	if !hasMask("redact_accounts_archive") {
+		t.Fatal("WithTable should register masked columns on the new table")
+	}
+
+	base.WithDB(newOfflineDB(t).Table("redact_accounts_2024"))
+	if !hasMask("redact_accounts_2024") {
+		t.Fatal("WithDB should register masked columns on the table of the new DB")
+	}
+}
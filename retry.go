@@ -0,0 +1,186 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseDelay   = 10 * time.Millisecond
+	defaultRetryMaxDelay    = time.Second
+)
+
+type (
+	// RetryPolicy 瞬时错误的重试策略, 退避时间为 [0, min(maxDelay, baseDelay*2^(n-1))) 之间的随机值
+	//
+	// 查询在事务外失败时重试; 变更只在自身开启的事务中整体重试, 或者通过WithIdempotent标记为幂等时重试;
+	// 已经在外层事务中执行的操作不重试, 由外层事务决定是否重试
+	RetryPolicy struct {
+		maxAttempts int
+		baseDelay   time.Duration
+		maxDelay    time.Duration
+		retryOn     []error
+	}
+
+	RetryOption func(*RetryPolicy)
+
+	idempotentCtxKey struct{}
+)
+
+// NewRetryPolicy 创建重试策略, 默认最多执行3次, 退避从10ms开始, 最长1s, 重试死锁、锁等待超时、序列化失败和连接错误
+func NewRetryPolicy(opts ...RetryOption) *RetryPolicy {
+	p := &RetryPolicy{
+		maxAttempts: defaultRetryMaxAttempts,
+		baseDelay:   defaultRetryBaseDelay,
+		maxDelay:    defaultRetryMaxDelay,
+		retryOn:     []error{ErrDeadlock, ErrLockTimeout, ErrSerializationFailure, ErrConnection},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// WithRetryMaxAttempts 设置最多执行的次数, 包含第一次执行
+func WithRetryMaxAttempts(attempts int) RetryOption {
+	return func(p *RetryPolicy) {
+		if attempts > 0 {
+			p.maxAttempts = attempts
+		}
+	}
+}
+
+// WithRetryBackoff 设置退避, 第n次重试前最多等待 base*2^(n-1), 最长max
+func WithRetryBackoff(base, max time.Duration) RetryOption {
+	return func(p *RetryPolicy) {
+		p.baseDelay = base
+		p.maxDelay = max
+	}
+}
+
+// WithRetryOn 设置可以重试的错误类型, 见ErrXxx; ErrConnection只对查询和幂等操作生效, 连接中断时无法确定变更是否已提交
+func WithRetryOn(kinds ...error) RetryOption {
+	return func(p *RetryPolicy) {
+		p.retryOn = kinds
+	}
+}
+
+// WithIdempotent 标记上下文中的变更是幂等的, 可以在事务外重试
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentCtxKey{}, true)
+}
+
+// IsIdempotent 上下文中的变更是否标记为幂等
+func IsIdempotent(ctx context.Context) bool {
+	idempotent, _ := ctx.Value(idempotentCtxKey{}).(bool)
+	return idempotent
+}
+
+// RetryTransaction 开启事务, 事务因为瞬时错误失败时按策略整体重试, fn可能被执行多次
+//
+// 已经在事务中调用时作为嵌套事务执行, 不重试
+func RetryTransaction(ctx context.Context, db *gorm.DB, policy *RetryPolicy, fn func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	db = db.WithContext(ctx)
+	if inTransaction(db) {
+		return transaction(db, fn, opts...)
+	}
+	return policy.do(ctx, db, false, func() error {
+		return transaction(db, fn, opts...)
+	})
+}
+
+// inTransaction db是否已经在事务中
+func inTransaction(db *gorm.DB) bool {
+	committer, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok && committer != nil
+}
+
+// query 执行查询, 在事务外时按策略重试
+func (p *RetryPolicy) query(ctx context.Context, db *gorm.DB, fn func() error) error {
+	if p == nil || inTransaction(db) {
+		return fn()
+	}
+	return p.do(ctx, db, true, fn)
+}
+
+// mutate 执行变更, 幂等时直接重试, 否则在事务中整体重试, 已经在事务中时不重试
+func (p *RetryPolicy) mutate(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	if p == nil || inTransaction(db) {
+		return fn(db)
+	}
+	if IsIdempotent(ctx) {
+		return p.do(ctx, db, true, func() error {
+			return fn(db)
+		})
+	}
+	return p.do(ctx, db, false, func() error {
+		return transaction(db, fn)
+	})
+}
+
+// do 执行fn, 失败时按策略重试, idempotent为false时不重试连接错误
+func (p *RetryPolicy) do(ctx context.Context, db *gorm.DB, idempotent bool, fn func() error) error {
+	if p == nil {
+		return fn()
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.maxAttempts || !p.retryable(db, err, idempotent) {
+			return err
+		}
+		delay := p.backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			return err
+		}
+		trace.SpanFromContext(ctx).AddEvent("db.retry", trace.WithAttributes(
+			attribute.Int("db.retry.attempt", attempt),
+			attribute.String("db.retry.delay", delay.String()),
+			attribute.String("error.type", ClassifyError(db, err)),
+			attribute.String("error.message", err.Error()),
+		))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable err是否可以重试
+func (p *RetryPolicy) retryable(db *gorm.DB, err error, idempotent bool) bool {
+	kind, _ := TranslateError(db, err)
+	if kind == nil || (errors.Is(kind, ErrConnection) && !idempotent) {
+		return false
+	}
+	for _, retryOn := range p.retryOn {
+		if errors.Is(kind, retryOn) {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff 第attempt次失败后的等待时间
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.baseDelay
+	for i := 1; i < attempt && d < p.maxDelay; i++ {
+		d *= 2
+	}
+	if d > p.maxDelay {
+		d = p.maxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
@@ -0,0 +1,73 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestRetryPolicyDo(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	p := NewRetryPolicy(WithRetryMaxAttempts(3), WithRetryBackoff(time.Millisecond, 2*time.Millisecond))
+
+	var calls int
+	err := p.do(context.Background(), nil, false, func() error {
+		calls++
+		if calls < 3 {
+			return deadlock
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("got err=%v calls=%d, want success after 3 calls", err, calls)
+	}
+
+	calls = 0
+	err = p.do(context.Background(), nil, false, func() error {
+		calls++
+		return mysql.ErrInvalidConn
+	})
+	if !errors.Is(err, mysql.ErrInvalidConn) || calls != 1 {
+		t.Fatalf("connection error must not be retried for non-idempotent operations, calls=%d", calls)
+	}
+
+	calls = 0
+	_ = p.do(context.Background(), nil, true, func() error {
+		calls++
+		return mysql.ErrInvalidConn
+	})
+	if calls != 3 {
+		t.Fatalf("connection error should be retried for idempotent operations, calls=%d", calls)
+	}
+
+	calls = 0
+	_ = p.do(context.Background(), nil, true, func() error {
+		calls++
+		return errors.New("syntax error")
+	})
+	if calls != 1 {
+		t.Fatalf("unknown error must not be retried, calls=%d", calls)
+	}
+}
+
+func TestRetryPolicyDeadline(t *testing.T) {
+	p := NewRetryPolicy(WithRetryMaxAttempts(10), WithRetryBackoff(time.Hour, time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var calls int
+	start := time.Now()
+	_ = p.do(ctx, nil, true, func() error {
+		calls++
+		return &mysql.MySQLError{Number: 1205}
+	})
+	if time.Since(start) > time.Second {
+		t.Fatal("retry must not wait beyond the context deadline")
+	}
+	if calls > 2 {
+		t.Fatalf("unexpected calls %d", calls)
+	}
+}
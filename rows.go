@@ -0,0 +1,61 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+const expectRowsSettingKey = "gorm-normalize:expect_rows"
+
+// errIfNoRows ErrIfNoRows在设置中的值, 表示至少影响一行
+const errIfNoRows int64 = -1
+
+// ErrRowsMismatch 影响的行数和ExpectRows不一致, 变更已回滚
+var ErrRowsMismatch = errors.New("rows affected mismatch")
+
+// ExpectRows 期望Update*/Delete*影响n行, 未影响任何数据时返回ErrNotFound, 行数不一致时回滚并返回ErrRowsMismatch
+//
+// MySQL默认返回实际发生变化的行数, 更新为相同的值时影响行数为0, 需要在DSN中设置clientFoundRows=true
+func ExpectRows(n int64) ScopeMethod {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Set(expectRowsSettingKey, n)
+	}
+}
+
+// ErrIfNoRows Update*/Delete*未影响任何数据时返回ErrNotFound
+func ErrIfNoRows(db *gorm.DB) *gorm.DB {
+	return db.Set(expectRowsSettingKey, errIfNoRows)
+}
+
+// expectedRows 解析wheres中的ExpectRows/ErrIfNoRows, 没有设置时返回false
+func expectedRows(db *gorm.DB, wheres []ScopeMethod) (int64, bool) {
+	tx := db.Session(&gorm.Session{NewDB: true, DryRun: true})
+	for _, where := range wheres {
+		tx = where(tx)
+	}
+	v, ok := tx.Get(expectRowsSettingKey)
+	if !ok {
+		return 0, false
+	}
+	n, ok := v.(int64)
+	return n, ok
+}
+
+// checkRows 检查影响的行数是否符合预期
+func checkRows[T any](ctx context.Context, db *gorm.DB, expected, rows int64) error {
+	if rows == 0 && expected != 0 {
+		return notFoundError(ctx, tableNameOf[T](db))
+	}
+	if expected != errIfNoRows && rows != expected {
+		return &OpError{
+			Op:    GetOperationName(ctx),
+			Table: tableNameOf[T](db),
+			Kind:  ErrRowsMismatch,
+			Err:   fmt.Errorf("expected %d rows affected, got %d", expected, rows),
+		}
+	}
+	return nil
+}
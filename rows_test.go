@@ -0,0 +1,64 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestExpectRowsMismatch(t *testing.T) {
+	var updated int
+	unsubscribe := Subscribe[User](OnUpdated, func(context.Context, *EntityEvent[User]) error {
+		updated++
+		return nil
+	}, WithAfterCommit())
+	defer unsubscribe()
+
+	db, fake := newFakeDB(t)
+	fake.on("UPDATE `users`").affects(3)
+	a := NewAction[User](WithDB[User](db))
+
+	rows, err := a.UpdateMapRows(map[string]any{"name": "new"}, WhereID(1), ExpectRows(1))
+	var opErr *OpError
+	if !errors.Is(err, ErrRowsMismatch) || !errors.As(err, &opErr) || opErr.Op != "UpdateMap" || opErr.Table != "users" {
+		t.Fatalf("UpdateMapRows() = %v, want ErrRowsMismatch from UpdateMap", err)
+	}
+	if rows != 0 {
+		t.Fatalf("UpdateMapRows() rows = %d, mismatched mutation should not report rows", rows)
+	}
+	if stmts := fake.executed("UPDATE `users`"); len(stmts) != 1 || !stmts[0].InTx {
+		t.Fatalf("update = %v, want it executed in a transaction", stmts)
+	}
+	if commits, rollbacks := fake.txCounts(); commits != 0 || rollbacks != 1 {
+		t.Fatalf("commits %d, rollbacks %d, want the mutation rolled back", commits, rollbacks)
+	}
+	if updated != 0 {
+		t.Fatal("rolled back mutation published OnUpdated")
+	}
+
+	x := a.WithContext(context.Background())
+	if n := x.UpdateMapRowsX(map[string]any{"name": "new"}, WhereID(1), ExpectRows(1)); n != 0 || !errors.Is(x.Err(), ErrRowsMismatch) {
+		t.Fatalf("UpdateMapRowsX() = %d, %v, want ErrRowsMismatch", n, x.Err())
+	}
+}
+
+func TestExpectRows(t *testing.T) {
+	db, fake := newFakeDB(t)
+	a := NewAction[User](WithDB[User](db))
+
+	fake.on("UPDATE `users`").affects(0)
+	err := a.Delete(WhereID(1), ErrIfNoRows)
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("Delete() = %v, want ErrNotFound", err)
+	}
+
+	fake.on("UPDATE `users`").affects(2)
+	if rows, err := a.DeleteRows(WhereID(1, 2), ExpectRows(2)); err != nil || rows != 2 {
+		t.Fatalf("DeleteRows() = %d, %v, want 2 rows", rows, err)
+	}
+	if commits, rollbacks := fake.txCounts(); commits != 1 || rollbacks != 1 {
+		t.Fatalf("commits %d, rollbacks %d, want 1 and 1", commits, rollbacks)
+	}
+}
@@ -0,0 +1,61 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+type (
+	// flightGroup 合并相同key的并发调用, 只执行一次
+	flightGroup struct {
+		mu sync.Mutex
+		m  map[string]*flightCall
+	}
+
+	flightCall struct {
+		done chan struct{}
+		val  any
+		err  error
+		dups int
+	}
+)
+
+// Do 执行fn, 相同key的并发调用共享同一次结果
+//
+// fn在独立的goroutine中执行, 调用方的ctx取消只会让当前调用方提前返回, 不会影响其他等待者
+func (g *flightGroup) Do(ctx context.Context, key string, fn func() (any, error)) (v any, err error, shared bool) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*flightCall)
+	}
+	c, ok := g.m[key]
+	if ok {
+		c.dups++
+	} else {
+		c = &flightCall{done: make(chan struct{})}
+		g.m[key] = c
+		go g.doCall(c, key, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, c.err, ok || c.dups > 0
+	case <-ctx.Done():
+		return nil, ctx.Err(), ok
+	}
+}
+
+func (g *flightGroup) doCall(c *flightCall, key string, fn func() (any, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.err = fmt.Errorf("singleflight: panic in %q: %v", key, r)
+		}
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+}
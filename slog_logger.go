@@ -0,0 +1,184 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var _ logger.Interface = (*SlogLogger)(nil)
+var _ gorm.ParamsFilter = (*SlogLogger)(nil)
+
+type (
+	// SlogLogger 基于log/slog的gorm日志, 带trace/span ID、操作名、表名、耗时、行数和错误
+	//
+	// SQL参数按脱敏策略处理, 默认只输出参数化SQL
+	SlogLogger struct {
+		logger               *slog.Logger
+		level                logger.LogLevel
+		slowThreshold        time.Duration
+		ignoreRecordNotFound bool
+		redaction            *RedactionPolicy
+	}
+
+	SlogLoggerOption func(*SlogLogger)
+
+	operationNameCtxKey struct{}
+)
+
+// WithOperationName 在上下文中设置操作名并记录开始时间, IAction的每个操作都会设置, 日志中作为db.operation.name输出
+func WithOperationName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, operationNameCtxKey{}, operationInfo{name: name, start: time.Now(), failed: &failedStatement{}})
+}
+
+// GetOperationName 获取上下文中的操作名
+func GetOperationName(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	info, _ := ctx.Value(operationNameCtxKey{}).(operationInfo)
+	return info.name
+}
+
+// NewSlogLogger 创建slog日志, l为nil时使用slog.Default(), 默认级别Warn, 慢查询阈值200ms
+func NewSlogLogger(l *slog.Logger, opts ...SlogLoggerOption) *SlogLogger {
+	if l == nil {
+		l = slog.Default()
+	}
+	sl := &SlogLogger{
+		logger:        l,
+		level:         logger.Warn,
+		slowThreshold: defaultSlowThreshold,
+	}
+	for _, opt := range opts {
+		opt(sl)
+	}
+	return sl
+}
+
+// WithSlogLevel 设置日志级别, Info级别输出所有SQL
+func WithSlogLevel(level logger.LogLevel) SlogLoggerOption {
+	return func(l *SlogLogger) {
+		l.level = level
+	}
+}
+
+// WithSlogSlowThreshold 设置慢查询阈值, <=0表示不输出慢查询日志
+func WithSlogSlowThreshold(threshold time.Duration) SlogLoggerOption {
+	return func(l *SlogLogger) {
+		l.slowThreshold = threshold
+	}
+}
+
+// WithSlogIgnoreRecordNotFound ErrRecordNotFound不输出错误日志
+func WithSlogIgnoreRecordNotFound() SlogLoggerOption {
+	return func(l *SlogLogger) {
+		l.ignoreRecordNotFound = true
+	}
+}
+
+// WithSlogRedactionPolicy 设置SQL参数的脱敏策略, 默认使用全局默认策略
+func WithSlogRedactionPolicy(policy *RedactionPolicy) SlogLoggerOption {
+	return func(l *SlogLogger) {
+		l.redaction = policy
+	}
+}
+
+func (l *SlogLogger) LogMode(level logger.LogLevel) logger.Interface {
+	nl := *l
+	nl.level = level
+	return &nl
+}
+
+func (l *SlogLogger) Info(ctx context.Context, msg string, args ...any) {
+	if l.level >= logger.Info {
+		l.log(ctx, slog.LevelInfo, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *SlogLogger) Warn(ctx context.Context, msg string, args ...any) {
+	if l.level >= logger.Warn {
+		l.log(ctx, slog.LevelWarn, fmt.Sprintf(msg, args...))
+	}
+}
+
+func (l *SlogLogger) Error(ctx context.Context, msg string, args ...any) {
+	if l.level >= logger.Error {
+		l.log(ctx, slog.LevelError, fmt.Sprintf(msg, args...))
+	}
+}
+
+// Trace 输出SQL日志, 失败的语句Error级别, 慢查询Warn级别, 其余Info级别
+func (l *SlogLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+
+	var level slog.Level
+	switch {
+	case err != nil && l.level >= logger.Error && (!l.ignoreRecordNotFound || !errors.Is(err, gorm.ErrRecordNotFound)):
+		level = slog.LevelError
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= logger.Warn:
+		level = slog.LevelWarn
+	case l.level >= logger.Info:
+		level = slog.LevelInfo
+	default:
+		return
+	}
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+
+	sql, rows := fc()
+	attrs := []slog.Attr{
+		slog.String("db.statement", sql),
+		slog.String("db.sql.table", tableFromSQL(sql)),
+		slog.Duration("elapsed", elapsed),
+		slog.Int64("db.rows_affected", rows),
+	}
+	if name := GetOperationName(ctx); name != "" {
+		attrs = append(attrs, slog.String("db.operation.name", name))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	if caller := callerLocation(); caller != "" {
+		attrs = append(attrs, slog.String("caller", caller))
+	}
+
+	msg := "sql"
+	if level == slog.LevelWarn {
+		msg = "slow sql"
+	}
+	l.log(ctx, level, msg, attrs...)
+}
+
+// ParamsFilter 实现gorm.ParamsFilter, 按脱敏策略处理SQL参数
+func (l *SlogLogger) ParamsFilter(_ context.Context, sql string, params ...any) (string, []any) {
+	policy := l.redaction
+	if policy == nil {
+		policy = GetDefaultRedactionPolicy()
+	}
+	return sql, policy.FilterVars(sql, tableFromSQL(sql), params...)
+}
+
+// log 输出日志, 附带上下文中的trace/span ID
+func (l *SlogLogger) log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if sc := oteltrace.SpanContextFromContext(ctx); sc.IsValid() {
+		attrs = append(attrs,
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+	l.logger.LogAttrs(ctx, level, msg, attrs...)
+}
@@ -0,0 +1,130 @@
+package query
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// slogRecords 解析JSON格式的日志
+func slogRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		record := make(map[string]any)
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestSlogLoggerTraceCorrelation(t *testing.T) {
+	db, fake, recorder := newTracedDB(t)
+	var buf bytes.Buffer
+	db.Logger = NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, nil)), WithSlogLevel(logger.Info))
+	// 软删除执行的是UPDATE
+	fake.on("UPDATE").fails(errors.New("boom"))
+
+	a := NewAction[User](WithDB[User](db))
+	if _, err := a.Count(WhereID(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Delete(WhereID(1)); err == nil {
+		t.Fatal("Delete() should fail")
+	}
+
+	records := slogRecords(t, &buf)
+	if len(records) != 2 {
+		t.Fatalf("records = %v, want count and delete", records)
+	}
+	stmt := endedSpan(recorder, 0, "SELECT users")
+	if stmt == nil {
+		t.Fatal("no statement span")
+	}
+	count := records[0]
+	if count["level"] != "INFO" || count["db.operation.name"] != "Count" || count["db.sql.table"] != "users" {
+		t.Errorf("count record = %v", count)
+	}
+	if count["trace_id"] != stmt.SpanContext().TraceID().String() || count["span_id"] != stmt.SpanContext().SpanID().String() {
+		t.Errorf("count record trace = %v/%v, want the statement span %v", count["trace_id"], count["span_id"], stmt.SpanContext())
+	}
+	// 默认只输出参数化SQL
+	if sql, _ := count["db.statement"].(string); !strings.Contains(sql, "id = ?") {
+		t.Errorf("db.statement = %q, want parameterized SQL", sql)
+	}
+
+	del := records[1]
+	if del["level"] != "ERROR" || del["db.operation.name"] != "Delete" || !strings.Contains(del["error"].(string), "boom") {
+		t.Errorf("delete record = %v", del)
+	}
+}
+
+func TestSlogLoggerWithoutSpan(t *testing.T) {
+	db, _ := newFakeDB(t)
+	var buf bytes.Buffer
+	db.Logger = NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, nil)), WithSlogLevel(logger.Info))
+
+	if _, err := NewAction[User](WithDB[User](db), WithTracer[User](NewITracer().CloseTrace())).Count(); err != nil {
+		t.Fatal(err)
+	}
+	records := slogRecords(t, &buf)
+	if len(records) != 1 {
+		t.Fatalf("records = %v", records)
+	}
+	if _, ok := records[0]["trace_id"]; ok {
+		t.Errorf("record without span has trace_id: %v", records[0])
+	}
+	if records[0]["db.operation.name"] != "Count" {
+		t.Errorf("record = %v, want operation name Count", records[0])
+	}
+}
+
+func TestSlogLoggerCaller(t *testing.T) {
+	db, fake := newFakeDB(t)
+	var buf bytes.Buffer
+	db.Logger = NewSlogLogger(slog.New(slog.NewJSONHandler(&buf, nil)), WithSlogLevel(logger.Info))
+	fake.on("UPDATE").fails(errors.New("boom"))
+
+	var want []string
+	// 嵌套在事务中加深调用栈, 调用位置仍然是这里而不是gorm、errors.go或者事务的源码
+	err := Transaction(context.Background(), db, func(db *gorm.DB) error {
+		tx := NewAction[User](WithDB[User](db))
+		_, file, line, _ := runtime.Caller(0)
+		_, err := tx.Count(WhereID(1))
+		want = append(want, fmt.Sprintf("%s:%d", file, line+1))
+		if err != nil {
+			return err
+		}
+		_, file, line, _ = runtime.Caller(0)
+		err = tx.Delete(WhereID(1))
+		want = append(want, fmt.Sprintf("%s:%d", file, line+1))
+		return err
+	})
+	if err == nil {
+		t.Fatal("Delete() should fail")
+	}
+
+	records := slogRecords(t, &buf)
+	if len(records) != len(want) {
+		t.Fatalf("records = %v, want count and delete", records)
+	}
+	for i, record := range records {
+		if record["caller"] != want[i] {
+			t.Errorf("record %d caller = %v, want %s", i, record["caller"], want[i])
+		}
+	}
+}
@@ -0,0 +1,458 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const gormSlowQueryTime = "__gorm_slow_query_time"
+
+const (
+	slowQueryBeforeName = "slow_query:before"
+	slowQueryAfterName  = "slow_query:after"
+
+	defaultSlowThreshold   = 200 * time.Millisecond
+	defaultExplainInterval = time.Second
+	defaultExplainTimeout  = 3 * time.Second
+)
+
+var _ gorm.Plugin = (*SlowQueryPlugin)(nil)
+
+type (
+	// SlowQueryPlugin 慢查询检测插件, 按操作类型设置阈值, 慢SELECT自动采集执行计划
+	//
+	// EXPLAIN在独立的连接上异步执行, 并按explainInterval限流, 不影响业务语句
+	SlowQueryPlugin struct {
+		threshold       time.Duration
+		thresholds      map[string]time.Duration
+		explain         bool
+		explainInterval time.Duration
+		explainTimeout  time.Duration
+		sinks           []SlowQuerySink
+		redaction       *RedactionPolicy
+
+		sqlDB       *sql.DB
+		lastExplain atomic.Int64
+		explaining  atomic.Bool
+		wg          sync.WaitGroup
+	}
+
+	SlowQueryOption func(*SlowQueryPlugin)
+
+	// SlowQuery 慢查询信息
+	SlowQuery struct {
+		// Kind 操作类型, 见OperationKindXxx
+		Kind string
+		// Table 表名
+		Table string
+		// Fingerprint SQL指纹, 去掉参数值并合并IN列表, 相同结构的语句指纹相同
+		Fingerprint string
+		// SQL 按脱敏策略渲染的语句
+		SQL string
+		// Duration 耗时
+		Duration time.Duration
+		// Rows 影响或返回的行数
+		Rows int64
+		// Caller 业务代码调用位置
+		Caller string
+		// Plan 执行计划, 只有SELECT并且未被限流时才有
+		Plan []map[string]any
+		// ExplainErr 执行计划采集失败的原因
+		ExplainErr error
+		// Err 语句执行错误
+		Err error
+	}
+
+	// SlowQuerySink 慢查询上报, 带执行计划的慢查询在后台协程中上报
+	SlowQuerySink interface {
+		Report(ctx context.Context, q *SlowQuery)
+	}
+
+	// SlowQuerySinkFunc 函数形式的SlowQuerySink
+	SlowQuerySinkFunc func(ctx context.Context, q *SlowQuery)
+
+	// logSlowQuerySink 输出到gorm logger
+	logSlowQuerySink struct {
+		logger logger.Interface
+	}
+
+	// spanSlowQuerySink 作为事件记录到上下文中的span
+	spanSlowQuerySink struct{}
+)
+
+var (
+	fingerprintLiteral = regexp.MustCompile(`'(?:[^'\\]|\\.)*'|\b\d+(?:\.\d+)?\b|\$\d+`)
+	fingerprintInList  = regexp.MustCompile(`(?i)\bIN\s*\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	fingerprintValues  = regexp.MustCompile(`(?i)\bVALUES\s*\(([^()]*)\)(?:\s*,\s*\([^()]*\))*`)
+	fingerprintSpace   = regexp.MustCompile(`\s+`)
+
+	// packageSourceDir 本包源码目录, 用于定位业务代码调用位置
+	packageSourceDir = func() string {
+		_, file, _, _ := runtime.Caller(0)
+		return filepath.Dir(file) + string(filepath.Separator)
+	}()
+)
+
+// Report 实现SlowQuerySink
+func (f SlowQuerySinkFunc) Report(ctx context.Context, q *SlowQuery) {
+	f(ctx, q)
+}
+
+// NewLogSlowQuerySink 输出到gorm logger, 为nil时使用logger.Default
+func NewLogSlowQuerySink(l logger.Interface) SlowQuerySink {
+	if l == nil {
+		l = logger.Default
+	}
+	return &logSlowQuerySink{logger: l}
+}
+
+func (s *logSlowQuerySink) Report(ctx context.Context, q *SlowQuery) {
+	msg := fmt.Sprintf("slow query %s [%s] rows:%d caller:%s fingerprint:%s", q.SQL, q.Duration, q.Rows, q.Caller, q.Fingerprint)
+	if len(q.Plan) > 0 {
+		msg += fmt.Sprintf(" plan:%v", q.Plan)
+	}
+	s.logger.Warn(ctx, msg)
+}
+
+// NewSpanSlowQuerySink 作为事件记录到上下文中的span, 和OpentracingPlugin一起使用时记录到语句的span
+//
+// 带执行计划的慢查询在后台上报, 此时语句的span一般已经结束, 事件记录到一个链接到语句span的新span上
+func NewSpanSlowQuerySink() SlowQuerySink {
+	return spanSlowQuerySink{}
+}
+
+func (spanSlowQuerySink) Report(ctx context.Context, q *SlowQuery) {
+	span := oteltrace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		sc := span.SpanContext()
+		if !sc.IsValid() {
+			return
+		}
+		_, span = span.TracerProvider().Tracer("gorm").Start(oteltrace.ContextWithSpanContext(context.WithoutCancel(ctx), sc), "slow_query",
+			oteltrace.WithSpanKind(oteltrace.SpanKindInternal),
+			oteltrace.WithLinks(oteltrace.Link{SpanContext: sc}),
+		)
+		defer span.End()
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("db.sql.table", q.Table),
+		attribute.String("db.operation", q.Kind),
+		attribute.String("db.statement.fingerprint", q.Fingerprint),
+		attribute.String("elapsed", q.Duration.String()),
+		attribute.Int64("db.rows_affected", q.Rows),
+		attribute.String("code.caller", q.Caller),
+	}
+	if len(q.Plan) > 0 {
+		attrs = append(attrs, attribute.String("db.plan", fmt.Sprint(q.Plan)))
+	}
+	span.AddEvent("slow_query", oteltrace.WithAttributes(attrs...))
+}
+
+// NewSlowQueryPlugin 创建慢查询插件, 默认阈值200ms, 开启EXPLAIN, 每秒最多采集一次执行计划, 输出到logger.Default
+func NewSlowQueryPlugin(opts ...SlowQueryOption) *SlowQueryPlugin {
+	p := &SlowQueryPlugin{
+		threshold:       defaultSlowThreshold,
+		thresholds:      make(map[string]time.Duration),
+		explain:         true,
+		explainInterval: defaultExplainInterval,
+		explainTimeout:  defaultExplainTimeout,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if len(p.sinks) == 0 {
+		p.sinks = []SlowQuerySink{NewLogSlowQuerySink(nil)}
+	}
+	return p
+}
+
+// WithSlowThreshold 设置默认阈值
+func WithSlowThreshold(threshold time.Duration) SlowQueryOption {
+	return func(p *SlowQueryPlugin) {
+		p.threshold = threshold
+	}
+}
+
+// WithSlowThresholdFor 设置某类操作的阈值, kind见OperationKindXxx
+func WithSlowThresholdFor(kind string, threshold time.Duration) SlowQueryOption {
+	return func(p *SlowQueryPlugin) {
+		p.thresholds[kind] = threshold
+	}
+}
+
+// WithExplain 是否采集慢SELECT的执行计划
+func WithExplain(explain bool) SlowQueryOption {
+	return func(p *SlowQueryPlugin) {
+		p.explain = explain
+	}
+}
+
+// WithExplainInterval 设置两次采集执行计划的最小间隔
+func WithExplainInterval(interval time.Duration) SlowQueryOption {
+	return func(p *SlowQueryPlugin) {
+		p.explainInterval = interval
+	}
+}
+
+// WithExplainTimeout 设置采集执行计划的超时时间
+func WithExplainTimeout(timeout time.Duration) SlowQueryOption {
+	return func(p *SlowQueryPlugin) {
+		if timeout > 0 {
+			p.explainTimeout = timeout
+		}
+	}
+}
+
+// WithSlowQuerySink 设置上报方式, 可以设置多个
+func WithSlowQuerySink(sinks ...SlowQuerySink) SlowQueryOption {
+	return func(p *SlowQueryPlugin) {
+		p.sinks = append(p.sinks, sinks...)
+	}
+}
+
+// WithSlowQueryRedactionPolicy 设置上报SQL的脱敏策略, 默认使用全局默认策略
+func WithSlowQueryRedactionPolicy(policy *RedactionPolicy) SlowQueryOption {
+	return func(p *SlowQueryPlugin) {
+		p.redaction = policy
+	}
+}
+
+func (p *SlowQueryPlugin) Name() string {
+	return "slowQueryPlugin"
+}
+
+func (p *SlowQueryPlugin) Initialize(db *gorm.DB) (err error) {
+	if sqlDB, err := db.DB(); err == nil {
+		p.sqlDB = sqlDB
+	}
+
+	cb := db.Callback()
+	if err = cb.Create().Before("gorm:before_create").Register(slowQueryBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Query().Before("gorm:query").Register(slowQueryBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Delete().Before("gorm:before_delete").Register(slowQueryBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Update().Before("gorm:setup_reflect_value").Register(slowQueryBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Row().Before("gorm:row").Register(slowQueryBeforeName, p.before); err != nil {
+		return err
+	}
+	if err = cb.Raw().Before("gorm:raw").Register(slowQueryBeforeName, p.before); err != nil {
+		return err
+	}
+
+	// 在opentracing:after之前执行, 保证span事件记录在语句的span上
+	if err = cb.Create().After("gorm:after_create").Before(callBackAfterName).Register(slowQueryAfterName, p.after(OperationKindCreate)); err != nil {
+		return err
+	}
+	if err = cb.Query().After("gorm:after_query").Before(callBackAfterName).Register(slowQueryAfterName, p.after(OperationKindQuery)); err != nil {
+		return err
+	}
+	if err = cb.Delete().After("gorm:after_delete").Before(callBackAfterName).Register(slowQueryAfterName, p.after(OperationKindDelete)); err != nil {
+		return err
+	}
+	if err = cb.Update().After("gorm:after_update").Before(callBackAfterName).Register(slowQueryAfterName, p.after(OperationKindUpdate)); err != nil {
+		return err
+	}
+	if err = cb.Row().After("gorm:row").Before(callBackAfterName).Register(slowQueryAfterName, p.after(OperationKindRow)); err != nil {
+		return err
+	}
+	if err = cb.Raw().After("gorm:raw").Before(callBackAfterName).Register(slowQueryAfterName, p.after(OperationKindRaw)); err != nil {
+		return err
+	}
+	return
+}
+
+// Wait 等待后台的执行计划采集和上报完成, 一般在关闭数据库连接前调用
+func (p *SlowQueryPlugin) Wait() {
+	p.wg.Wait()
+}
+
+func (p *SlowQueryPlugin) before(db *gorm.DB) {
+	if db.DryRun {
+		return
+	}
+	db.InstanceSet(gormSlowQueryTime, time.Now())
+}
+
+func (p *SlowQueryPlugin) after(kind string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		_start, isExist := db.InstanceGet(gormSlowQueryTime)
+		if !isExist {
+			return
+		}
+		start, ok := _start.(time.Time)
+		if !ok {
+			return
+		}
+		elapsed := time.Since(start)
+		if elapsed < p.thresholdOf(kind) {
+			return
+		}
+
+		ctx := db.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		policy := p.redaction
+		if policy == nil {
+			policy = GetDefaultRedactionPolicy()
+		}
+		sqlStr := db.Statement.SQL.String()
+		q := &SlowQuery{
+			Kind:        kind,
+			Table:       db.Statement.Table,
+			Fingerprint: Fingerprint(sqlStr),
+			SQL:         policy.Statement(db),
+			Duration:    elapsed,
+			Rows:        db.RowsAffected,
+			Caller:      callerLocation(),
+			Err:         db.Error,
+		}
+
+		if !p.shouldExplain(db, kind) {
+			p.report(ctx, q)
+			return
+		}
+		vars := append([]any(nil), db.Statement.Vars...)
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer p.explaining.Store(false)
+			q.Plan, q.ExplainErr = p.explainPlan(context.WithoutCancel(ctx), sqlStr, vars)
+			p.report(ctx, q)
+		}()
+	}
+}
+
+// thresholdOf 操作类型对应的阈值
+func (p *SlowQueryPlugin) thresholdOf(kind string) time.Duration {
+	if threshold, ok := p.thresholds[kind]; ok {
+		return threshold
+	}
+	return p.threshold
+}
+
+// shouldExplain 只对成功执行的SELECT采集执行计划, 同一时间最多一个采集, 并按间隔限流
+func (p *SlowQueryPlugin) shouldExplain(db *gorm.DB, kind string) bool {
+	if !p.explain || p.sqlDB == nil || db.Error != nil || sqlOperation(db, kind) != "SELECT" {
+		return false
+	}
+	now := time.Now().UnixNano()
+	last := p.lastExplain.Load()
+	if now-last < int64(p.explainInterval) || !p.lastExplain.CompareAndSwap(last, now) {
+		return false
+	}
+	return p.explaining.CompareAndSwap(false, true)
+}
+
+// explainPlan 在独立的连接上执行EXPLAIN
+func (p *SlowQueryPlugin) explainPlan(ctx context.Context, sqlStr string, vars []any) ([]map[string]any, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.explainTimeout)
+	defer cancel()
+
+	conn, err := p.sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rows, err := conn.QueryContext(ctx, "EXPLAIN "+sqlStr, vars...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanPlanRows(rows)
+}
+
+// scanPlanRows 把执行计划的每一行转换为 列名->值
+func scanPlanRows(rows *sql.Rows) ([]map[string]any, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var plan []map[string]any
+	for rows.Next() {
+		values := make([]any, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+				continue
+			}
+			row[column] = values[i]
+		}
+		plan = append(plan, row)
+	}
+	return plan, rows.Err()
+}
+
+func (p *SlowQueryPlugin) report(ctx context.Context, q *SlowQuery) {
+	for _, sink := range p.sinks {
+		sink.Report(ctx, q)
+	}
+}
+
+// Fingerprint SQL指纹, 参数值替换为?, IN列表和多行VALUES合并, 空白归一
+func Fingerprint(sqlStr string) string {
+	fp := fingerprintLiteral.ReplaceAllString(sqlStr, "?")
+	fp = fingerprintInList.ReplaceAllString(fp, "IN (...)")
+	fp = fingerprintValues.ReplaceAllString(fp, "VALUES ($1)")
+	fp = fingerprintSpace.ReplaceAllString(fp, " ")
+	return strings.TrimSpace(fp)
+}
+
+// callerLocation 业务代码的调用位置, 跳过gorm和本包的源码
+//
+// 不限制栈深度, 嵌套事务、拦截器和钩子都可能让调用栈很深
+func callerLocation() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(2, pcs)
+	for n == len(pcs) {
+		pcs = make([]uintptr, len(pcs)*2)
+		n = runtime.Callers(2, pcs)
+	}
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if frame.File != "" && !isInternalSource(frame.File) {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// isInternalSource gorm或者本包(不含测试)的源码文件
+func isInternalSource(file string) bool {
+	if strings.Contains(file, "gorm.io/") {
+		return true
+	}
+	return strings.HasPrefix(file, packageSourceDir) && !strings.HasSuffix(file, "_test.go")
+}
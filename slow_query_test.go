@@ -0,0 +1,81 @@
+package query
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// slowQueryEvent span上的慢查询事件属性
+func slowQueryEvent(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	for _, event := range span.Events() {
+		if event.Name != "slow_query" {
+			continue
+		}
+		attrs := make(map[attribute.Key]attribute.Value)
+		for _, kv := range event.Attributes {
+			attrs[kv.Key] = kv.Value
+		}
+		return attrs
+	}
+	return nil
+}
+
+func TestSlowQuerySpanEvent(t *testing.T) {
+	db, _, recorder := newTracedDB(t)
+	plugin := NewSlowQueryPlugin(WithSlowThreshold(0), WithExplain(false), WithSlowQuerySink(NewSpanSlowQuerySink()))
+	if err := db.Use(plugin); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewAction[User](WithDB[User](db)).Count(); err != nil {
+		t.Fatal(err)
+	}
+	span := endedSpan(recorder, 0, "SELECT users")
+	if span == nil {
+		t.Fatal("no statement span")
+	}
+	attrs := slowQueryEvent(span)
+	if attrs == nil || attrs["db.sql.table"].AsString() != "users" || !strings.HasPrefix(attrs["db.statement.fingerprint"].AsString(), "SELECT count(*)") {
+		t.Fatalf("slow query event = %v, want it on the statement span", attrs)
+	}
+}
+
+func TestSlowQueryExplainSpan(t *testing.T) {
+	db, fake, recorder := newTracedDB(t)
+	fake.on("EXPLAIN").returns([]string{"id", "type"}, []driver.Value{int64(1), "ALL"})
+	plugin := NewSlowQueryPlugin(WithSlowThreshold(0), WithSlowQuerySink(NewSpanSlowQuerySink()))
+	if err := db.Use(plugin); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewAction[User](WithDB[User](db)).Count(); err != nil {
+		t.Fatal(err)
+	}
+	plugin.Wait()
+
+	stmt := endedSpan(recorder, 0, "SELECT users")
+	if stmt == nil {
+		t.Fatal("no statement span")
+	}
+	// 执行计划在后台采集, 语句的span可能已经结束, 事件记录在语句的span或者链接到它的span上
+	span := stmt
+	if slowQueryEvent(stmt) == nil {
+		span = endedSpan(recorder, 0, "slow_query")
+		if span == nil {
+			t.Fatal("slow query with plan was dropped")
+		}
+		if links := span.Links(); len(links) != 1 || links[0].SpanContext.SpanID() != stmt.SpanContext().SpanID() {
+			t.Fatalf("slow query span links = %v, want the statement span", links)
+		}
+		if span.Parent().SpanID() != stmt.SpanContext().SpanID() {
+			t.Fatalf("slow query span should be a child of the statement span")
+		}
+	}
+	if attrs := slowQueryEvent(span); !strings.Contains(attrs["db.plan"].AsString(), "ALL") {
+		t.Fatalf("slow query event = %v, want the plan", attrs)
+	}
+}
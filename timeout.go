@@ -0,0 +1,38 @@
+package query
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// Timeouts 操作的默认超时, 在每个操作内部基于上下文派生, 上下文已有更早的截止时间时以上下文为准, 为0时不设置
+	Timeouts struct {
+		// Read First/Last/List/Count等查询
+		Read time.Duration
+		// Write Create/Update*/Delete*
+		Write time.Duration
+		// Batch BatchCreate
+		Batch time.Duration
+	}
+
+	operationTimeoutCtxKey struct{}
+)
+
+// withTimeout 派生带超时的上下文, 超时时间同时保存在上下文中, 合并查询使用不可取消的上下文时重新设置
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	ctx = context.WithValue(ctx, operationTimeoutCtxKey{}, timeout)
+	return context.WithTimeout(ctx, timeout)
+}
+
+// detachContext 去掉上下文的取消和截止时间, 保留操作的默认超时
+func detachContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	detached := context.WithoutCancel(ctx)
+	if timeout, ok := ctx.Value(operationTimeoutCtxKey{}).(time.Duration); ok {
+		return context.WithTimeout(detached, timeout)
+	}
+	return detached, func() {}
+}
@@ -1,10 +1,14 @@
 package query
 
 import (
+	"errors"
+	"strings"
 	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
 	oteltrace "go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
@@ -19,7 +23,21 @@ const (
 
 var _ gorm.Plugin = (*OpentracingPlugin)(nil)
 
-type OpentracingPlugin struct{}
+type (
+	OpentracingPlugin struct {
+		tracerProvider       oteltrace.TracerProvider
+		tracer               oteltrace.Tracer
+		dbSystem             string
+		ignoreRecordNotFound bool
+		sampler              SpanSampler
+		redaction            *RedactionPolicy
+	}
+
+	OpentracingOption func(*OpentracingPlugin)
+
+	// SpanSampler 采样器, 根据语句的耗时和错误决定是否记录span
+	SpanSampler func(elapsed time.Duration, err error) bool
+)
 
 type tracerImpl struct {
 	// 开启trace
@@ -49,8 +67,54 @@ func NewITracer() Tracer {
 }
 
 // NewOpentracingPlugin 创建一个opentracing插件
-func NewOpentracingPlugin() *OpentracingPlugin {
-	return &OpentracingPlugin{}
+func NewOpentracingPlugin(opts ...OpentracingOption) *OpentracingPlugin {
+	op := &OpentracingPlugin{}
+	for _, opt := range opts {
+		opt(op)
+	}
+	return op
+}
+
+// WithTracerProvider 设置TracerProvider, 默认使用全局的TracerProvider
+func WithTracerProvider(tp oteltrace.TracerProvider) OpentracingOption {
+	return func(op *OpentracingPlugin) {
+		op.tracerProvider = tp
+	}
+}
+
+// WithDBSystem 设置db.system属性, 默认使用Dialector的名称
+func WithDBSystem(system string) OpentracingOption {
+	return func(op *OpentracingPlugin) {
+		op.dbSystem = system
+	}
+}
+
+// WithIgnoreRecordNotFound ErrRecordNotFound不视为错误, 不设置span的错误状态
+func WithIgnoreRecordNotFound() OpentracingOption {
+	return func(op *OpentracingPlugin) {
+		op.ignoreRecordNotFound = true
+	}
+}
+
+// WithSpanSampler 设置采样器, 语句执行结束后决定是否记录span
+func WithSpanSampler(sampler SpanSampler) OpentracingOption {
+	return func(op *OpentracingPlugin) {
+		op.sampler = sampler
+	}
+}
+
+// WithRedactionPolicy 设置db.statement的脱敏策略, 默认使用全局默认策略(只记录参数化SQL)
+func WithRedactionPolicy(policy *RedactionPolicy) OpentracingOption {
+	return func(op *OpentracingPlugin) {
+		op.redaction = policy
+	}
+}
+
+// SlowOrFailedSampler 只记录耗时超过threshold或者执行失败的语句
+func SlowOrFailedSampler(threshold time.Duration) SpanSampler {
+	return func(elapsed time.Duration, err error) bool {
+		return err != nil || elapsed >= threshold
+	}
 }
 
 func (op *OpentracingPlugin) Name() string {
@@ -58,83 +122,165 @@ func (op *OpentracingPlugin) Name() string {
 }
 
 func (op *OpentracingPlugin) Initialize(db *gorm.DB) (err error) {
+	tp := op.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	op.tracer = tp.Tracer("gorm")
+	if op.dbSystem == "" && db.Dialector != nil {
+		op.dbSystem = db.Dialector.Name()
+	}
+
 	// 开始前 - 并不是都用相同的方法，可以自己自定义
-	if err = db.Callback().Create().Before("gorm:before_create").Register(callBackBeforeName, before); err != nil {
+	if err = db.Callback().Create().Before("gorm:before_create").Register(callBackBeforeName, op.before(OperationKindCreate)); err != nil {
 		return err
 	}
-	if err = db.Callback().Query().Before("gorm:query").Register(callBackBeforeName, before); err != nil {
+	if err = db.Callback().Query().Before("gorm:query").Register(callBackBeforeName, op.before(OperationKindQuery)); err != nil {
 		return err
 	}
-	if err = db.Callback().Delete().Before("gorm:before_delete").Register(callBackBeforeName, before); err != nil {
+	if err = db.Callback().Delete().Before("gorm:before_delete").Register(callBackBeforeName, op.before(OperationKindDelete)); err != nil {
 		return err
 	}
-	if err = db.Callback().Update().Before("gorm:setup_reflect_value").Register(callBackBeforeName, before); err != nil {
+	if err = db.Callback().Update().Before("gorm:setup_reflect_value").Register(callBackBeforeName, op.before(OperationKindUpdate)); err != nil {
 		return err
 	}
-	if err = db.Callback().Row().Before("gorm:row").Register(callBackBeforeName, before); err != nil {
+	if err = db.Callback().Row().Before("gorm:row").Register(callBackBeforeName, op.before(OperationKindRow)); err != nil {
 		return err
 	}
-	if err = db.Callback().Raw().Before("gorm:raw").Register(callBackBeforeName, before); err != nil {
+	if err = db.Callback().Raw().Before("gorm:raw").Register(callBackBeforeName, op.before(OperationKindRaw)); err != nil {
 		return err
 	}
 
 	// 结束后 - 并不是都用相同的方法，可以自己自定义
-	if err = db.Callback().Create().After("gorm:after_create").Register(callBackAfterName, after); err != nil {
+	if err = db.Callback().Create().After("gorm:after_create").Register(callBackAfterName, op.after(OperationKindCreate)); err != nil {
 		return err
 	}
-	if err = db.Callback().Query().After("gorm:after_query").Register(callBackAfterName, after); err != nil {
+	if err = db.Callback().Query().After("gorm:after_query").Register(callBackAfterName, op.after(OperationKindQuery)); err != nil {
 		return err
 	}
-	if err = db.Callback().Delete().After("gorm:after_delete").Register(callBackAfterName, after); err != nil {
+	if err = db.Callback().Delete().After("gorm:after_delete").Register(callBackAfterName, op.after(OperationKindDelete)); err != nil {
 		return err
 	}
-	if err = db.Callback().Update().After("gorm:after_update").Register(callBackAfterName, after); err != nil {
+	if err = db.Callback().Update().After("gorm:after_update").Register(callBackAfterName, op.after(OperationKindUpdate)); err != nil {
 		return err
 	}
-	if err = db.Callback().Row().After("gorm:row").Register(callBackAfterName, after); err != nil {
+	if err = db.Callback().Row().After("gorm:row").Register(callBackAfterName, op.after(OperationKindRow)); err != nil {
 		return err
 	}
-	if err = db.Callback().Raw().After("gorm:raw").Register(callBackAfterName, after); err != nil {
+	if err = db.Callback().Raw().After("gorm:raw").Register(callBackAfterName, op.after(OperationKindRaw)); err != nil {
 		return err
 	}
 	return
 }
 
-func before(db *gorm.DB) {
-	ctx, span := otel.Tracer("gorm").Start(db.Statement.Context, "gorm")
-	// 利用db实例去传递span
-	db.InstanceSet(gormSpanKey, span)
-	db.InstanceSet(gormTime, time.Now())
-	db.Statement.Context = ctx
+func (op *OpentracingPlugin) before(kind string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.DryRun {
+			return
+		}
+		db.InstanceSet(gormTime, time.Now())
+		// 设置了采样器时, 执行结束后再决定是否创建span
+		if op.sampler != nil {
+			return
+		}
+		ctx, span := op.tracer.Start(db.Statement.Context, op.spanName(db, kind), oteltrace.WithSpanKind(oteltrace.SpanKindClient))
+		// 利用db实例去传递span
+		db.InstanceSet(gormSpanKey, span)
+		db.Statement.Context = ctx
+	}
 }
 
-func after(db *gorm.DB) {
-	_span, isExist := db.InstanceGet(gormSpanKey)
-	if !isExist {
-		return
+func (op *OpentracingPlugin) after(kind string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		_time, isExist := db.InstanceGet(gormTime)
+		if !isExist {
+			return
+		}
+		startTime, ok := _time.(time.Time)
+		if !ok {
+			return
+		}
+		elapsed := time.Since(startTime)
+
+		err := db.Error
+		if op.ignoreRecordNotFound && errors.Is(err, gorm.ErrRecordNotFound) {
+			err = nil
+		}
+
+		var span oteltrace.Span
+		if op.sampler != nil {
+			if !op.sampler(elapsed, err) {
+				return
+			}
+			_, span = op.tracer.Start(db.Statement.Context, op.spanName(db, kind),
+				oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+				oteltrace.WithTimestamp(startTime),
+			)
+		} else {
+			_span, isExist := db.InstanceGet(gormSpanKey)
+			if !isExist {
+				return
+			}
+			if span, ok = _span.(oteltrace.Span); !ok {
+				return
+			}
+		}
+		defer span.End()
+
+		span.SetAttributes(
+			semconv.DBSystemKey.String(op.dbSystem),
+			semconv.DBOperationKey.String(sqlOperation(db, kind)),
+			semconv.DBStatementKey.String(op.statement(db)),
+			attribute.Int64("db.rows_affected", db.RowsAffected),
+			attribute.String("elapsed", elapsed.String()),
+		)
+		if db.Statement.Table != "" {
+			span.SetAttributes(semconv.DBSQLTableKey.String(db.Statement.Table))
+		}
+		// Error
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+}
+
+// statement 按脱敏策略渲染语句
+func (op *OpentracingPlugin) statement(db *gorm.DB) string {
+	policy := op.redaction
+	if policy == nil {
+		policy = GetDefaultRedactionPolicy()
 	}
+	return policy.Statement(db)
+}
 
-	span, ok := _span.(oteltrace.Span)
-	if !ok {
-		return
+// spanName span名称, 例如 SELECT users
+func (op *OpentracingPlugin) spanName(db *gorm.DB, kind string) string {
+	name := sqlOperation(db, kind)
+	if db.Statement.Table != "" {
+		name += " " + db.Statement.Table
 	}
-	defer span.End()
+	return name
+}
 
-	// Error
-	if db.Error != nil {
-		span.SetAttributes(attribute.String("error", db.Error.Error()))
+// sqlOperation SQL操作类型, Row和Raw从SQL语句中解析
+func sqlOperation(db *gorm.DB, kind string) string {
+	switch kind {
+	case OperationKindCreate:
+		return "INSERT"
+	case OperationKindQuery:
+		return "SELECT"
+	case OperationKindUpdate:
+		return "UPDATE"
+	case OperationKindDelete:
+		return "DELETE"
 	}
-	// sql
-	span.SetAttributes(attribute.String("sql", db.Dialector.Explain(db.Statement.SQL.String(), db.Statement.Vars...)))
-	// rows
-	span.SetAttributes(attribute.Int64("rows", db.RowsAffected))
-	// elapsed
-	_time, isExist := db.InstanceGet(gormTime)
-	if !isExist {
-		return
+	sql := strings.TrimSpace(db.Statement.SQL.String())
+	if i := strings.IndexAny(sql, " \t\n"); i > 0 {
+		sql = sql[:i]
 	}
-	startTime, ok := _time.(time.Time)
-	if ok {
-		span.SetAttributes(attribute.String("elapsed", time.Since(startTime).String()))
+	if sql == "" {
+		return strings.ToUpper(kind)
 	}
+	return strings.ToUpper(sql)
 }
@@ -0,0 +1,153 @@
+package query
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+)
+
+// newTracedDB 创建使用opentracing插件的fakeDB, 同时替换全局的TracerProvider记录操作的span
+func newTracedDB(t *testing.T, opts ...OpentracingOption) (*gorm.DB, *fakeDB, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	global := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(global) })
+
+	db, fake := newFakeDB(t)
+	if err := db.Use(NewOpentracingPlugin(append([]OpentracingOption{WithTracerProvider(tp)}, opts...)...)); err != nil {
+		t.Fatal(err)
+	}
+	return db, fake, recorder
+}
+
+// endedSpan 按名称查找第from个之后已结束的span
+func endedSpan(recorder *tracetest.SpanRecorder, from int, name string) sdktrace.ReadOnlySpan {
+	for _, span := range recorder.Ended()[from:] {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+// spanAttrs span属性
+func spanAttrs(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestOpentracingPluginAttributes(t *testing.T) {
+	db, fake, recorder := newTracedDB(t)
+	fake.on("WHERE id = ?").fails(errors.New("boom"))
+	a := NewAction[User](WithDB[User](db))
+
+	if _, err := a.Count(); err != nil {
+		t.Fatal(err)
+	}
+	span := endedSpan(recorder, 0, "SELECT users")
+	if span == nil {
+		t.Fatalf("no SELECT users span in %v", recorder.Ended())
+	}
+	attrs := spanAttrs(span)
+	for key, want := range map[attribute.Key]string{
+		"db.system":    "mysql",
+		"db.operation": "SELECT",
+		"db.sql.table": "users",
+	} {
+		if got := attrs[key].AsString(); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if stmt := attrs["db.statement"].AsString(); !strings.HasPrefix(stmt, "SELECT count(*) FROM `users`") {
+		t.Errorf("db.statement = %q", stmt)
+	}
+	if op := endedSpan(recorder, 0, "Count"); op == nil || span.Parent().SpanID() != op.SpanContext().SpanID() {
+		t.Errorf("statement span should be a child of the Count operation span")
+	}
+	if span.Status().Code == codes.Error {
+		t.Errorf("successful statement has error status %v", span.Status())
+	}
+
+	from := len(recorder.Ended())
+	if _, err := a.FirstByID(1); err == nil {
+		t.Fatal("FirstByID() should fail")
+	}
+	if span := endedSpan(recorder, from, "SELECT users"); span == nil || span.Status().Code != codes.Error || len(span.Events()) == 0 {
+		t.Fatalf("failed statement span = %v, want error status and event", span)
+	}
+}
+
+func TestOpentracingPluginIgnoreRecordNotFound(t *testing.T) {
+	db, _, recorder := newTracedDB(t, WithIgnoreRecordNotFound())
+	if _, err := NewAction[User](WithDB[User](db)).First(); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("First() = %v, want not found", err)
+	}
+	if span := endedSpan(recorder, 0, "SELECT users"); span == nil || span.Status().Code == codes.Error {
+		t.Fatalf("not found statement span = %v, want no error status", span)
+	}
+}
+
+func TestOpentracingPluginSampler(t *testing.T) {
+	db, fake, recorder := newTracedDB(t, WithSpanSampler(SlowOrFailedSampler(time.Hour)))
+	a := NewAction[User](WithDB[User](db))
+
+	if _, err := a.Count(); err != nil {
+		t.Fatal(err)
+	}
+	if span := endedSpan(recorder, 0, "SELECT users"); span != nil {
+		t.Fatalf("fast statement should not be sampled: %v", span)
+	}
+
+	fake.on("count(*)").fails(errors.New("boom"))
+	before := time.Now()
+	if _, err := a.Count(); err == nil {
+		t.Fatal("Count() should fail")
+	}
+	span := endedSpan(recorder, 0, "SELECT users")
+	if span == nil || span.Status().Code != codes.Error {
+		t.Fatalf("failed statement should be sampled with error status: %v", span)
+	}
+	if span.StartTime().Before(before) || span.StartTime().After(span.EndTime()) {
+		t.Errorf("sampled span start = %v, want the statement start time", span.StartTime())
+	}
+}
+
+func TestOpentracingDisabled(t *testing.T) {
+	db, _, recorder := newTracedDB(t)
+
+	// 关闭操作级别的trace, 只保留语句的span
+	a := NewAction[User](WithDB[User](db), WithTracer[User](NewITracer().CloseTrace()))
+	if _, err := a.Count(); err != nil {
+		t.Fatal(err)
+	}
+	if span := endedSpan(recorder, 0, "Count"); span != nil {
+		t.Fatalf("disabled trace recorded operation span %v", span)
+	}
+	if span := endedSpan(recorder, 0, "SELECT users"); span == nil || span.Parent().IsValid() {
+		t.Fatalf("statement span = %v, want a root span", span)
+	}
+
+	// DryRun不执行语句, 不记录span
+	from := len(recorder.Ended())
+	if _, err := a.ToSQL(func(a IAction[User]) error {
+		_, err := a.Count()
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if span := endedSpan(recorder, from, "SELECT users"); span != nil {
+		t.Fatalf("DryRun recorded statement span %v", span)
+	}
+}
@@ -0,0 +1,137 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+const afterCommitSettingKey = "gorm-normalize:after_commit"
+
+// ErrNativeTransaction 在gorm原生的事务(db.Transaction、Begin)中使用了依赖提交结果的功能, 需要改用Transaction开启事务
+var ErrNativeTransaction = errors.New("transaction not started by query.Transaction")
+
+// afterCommitHooks 最外层事务提交后和回滚后执行的回调, 保存在事务DB的Settings中
+type afterCommitHooks struct {
+	mu        sync.Mutex
+	fns       []func()
+	rollbacks []func()
+}
+
+// Transaction 开启事务, 事务中通过IAction触发的提交后回调(例如WithAfterCommit的订阅)会在最外层事务提交后执行
+//
+// 已经在Transaction开启的事务中调用时, 作为嵌套事务执行, 回调仍然在最外层事务提交后执行;
+// 依赖提交结果的功能(WithAfterCommit的订阅、发件箱)只能在Transaction开启的事务中使用, 在gorm原生的事务中返回ErrNativeTransaction
+func Transaction(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	return transaction(db.WithContext(ctx), fn, opts...)
+}
+
+// transaction 开启事务, 最外层事务提交后执行回调, DryRun时不开启事务
+//
+// 嵌套事务(savepoint)回滚时, 其中注册的提交后回调被丢弃, 回滚后回调立即执行
+func transaction(db *gorm.DB, fn func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	if db.DryRun {
+		return fn(db)
+	}
+	// gorm原生的事务无法感知提交, 作为嵌套事务执行, 不创建回调
+	parent := afterCommitHooksOf(db)
+	if parent == nil && inTransaction(db) {
+		return db.Transaction(fn, opts...)
+	}
+
+	hooks := &afterCommitHooks{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Set返回的DB不能复用, 通过Session恢复为可复用的DB
+		return fn(tx.Set(afterCommitSettingKey, hooks).Session(&gorm.Session{}))
+	}, opts...)
+	if err != nil {
+		hooks.rollback()
+		return err
+	}
+	// 嵌套事务的回调交给外层, 最外层提交或回滚时执行
+	if parent != nil {
+		parent.merge(hooks)
+		return nil
+	}
+	hooks.run()
+	return nil
+}
+
+// nativeTransaction db是否在gorm原生的事务中, 这类事务无法感知提交和回滚
+func nativeTransaction(db *gorm.DB) bool {
+	return inTransaction(db) && afterCommitHooksOf(db) == nil
+}
+
+// afterCommit 注册提交后回调, db不在事务中时立即执行
+//
+// gorm原生的事务(db.Transaction、Begin)回滚时回调也会执行, 不注册并返回ErrNativeTransaction
+func afterCommit(db *gorm.DB, fn func()) error {
+	hooks := afterCommitHooksOf(db)
+	if hooks == nil {
+		if inTransaction(db) {
+			return ErrNativeTransaction
+		}
+		fn()
+		return nil
+	}
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.fns = append(hooks.fns, fn)
+	return nil
+}
+
+// afterRollback 注册最外层事务回滚后的回调, db不在Transaction开启的事务中时不注册
+func afterRollback(db *gorm.DB, fn func()) {
+	hooks := afterCommitHooksOf(db)
+	if hooks == nil {
+		return
+	}
+	hooks.mu.Lock()
+	defer hooks.mu.Unlock()
+	hooks.rollbacks = append(hooks.rollbacks, fn)
+}
+
+func afterCommitHooksOf(db *gorm.DB) *afterCommitHooks {
+	v, ok := db.Get(afterCommitSettingKey)
+	if !ok {
+		return nil
+	}
+	hooks, _ := v.(*afterCommitHooks)
+	return hooks
+}
+
+func (h *afterCommitHooks) run() {
+	h.mu.Lock()
+	fns := h.fns
+	h.fns = nil
+	h.mu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+func (h *afterCommitHooks) rollback() {
+	h.mu.Lock()
+	fns := h.rollbacks
+	h.rollbacks = nil
+	h.mu.Unlock()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// merge 合并嵌套事务的回调
+func (h *afterCommitHooks) merge(child *afterCommitHooks) {
+	child.mu.Lock()
+	fns, rollbacks := child.fns, child.rollbacks
+	child.fns, child.rollbacks = nil, nil
+	child.mu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fns = append(h.fns, fns...)
+	h.rollbacks = append(h.rollbacks, rollbacks...)
+}
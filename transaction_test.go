@@ -0,0 +1,135 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// subscribeCreated 以提交后回调的方式订阅User的新增, 返回已经收到的名字
+func subscribeCreated(t *testing.T) func() []string {
+	var (
+		mu    sync.Mutex
+		names []string
+	)
+	unsubscribe := Subscribe[User](OnCreated, func(_ context.Context, event *EntityEvent[User]) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, m := range event.Entities {
+			names = append(names, m.Name)
+		}
+		return nil
+	}, WithAfterCommit())
+	t.Cleanup(unsubscribe)
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), names...)
+	}
+}
+
+func TestAfterCommitRollback(t *testing.T) {
+	received := subscribeCreated(t)
+	db, _ := newFakeDB(t)
+	a := NewAction[User](WithDB[User](db))
+	ctx := context.Background()
+
+	err := Transaction(ctx, db, func(tx *gorm.DB) error {
+		if err := a.WithDB(tx).Create(&User{Name: "rolled back"}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) || len(received()) != 0 {
+		t.Fatalf("Transaction() = %v, received %v, want no hooks after rollback", err, received())
+	}
+
+	err = Transaction(ctx, db, func(tx *gorm.DB) error {
+		if err := a.WithDB(tx).Create(&User{Name: "committed"}); err != nil {
+			return err
+		}
+		if len(received()) != 0 {
+			t.Error("hook ran before commit")
+		}
+		return nil
+	})
+	if err != nil || len(received()) != 1 || received()[0] != "committed" {
+		t.Fatalf("Transaction() = %v, received %v, want the committed entity", err, received())
+	}
+
+	// 不在事务中时立即执行
+	if err := a.Create(&User{Name: "direct"}); err != nil || len(received()) != 2 {
+		t.Fatalf("Create() = %v, received %v", err, received())
+	}
+}
+
+func TestAfterCommitSavepoint(t *testing.T) {
+	received := subscribeCreated(t)
+	db, fake := newFakeDB(t)
+	a := NewAction[User](WithDB[User](db))
+	ctx := context.Background()
+
+	// 内层回滚, 外层提交: 只执行外层的回调
+	err := Transaction(ctx, db, func(tx *gorm.DB) error {
+		if err := Transaction(ctx, tx, func(tx *gorm.DB) error {
+			if err := a.WithDB(tx).Create(&User{Name: "inner"}); err != nil {
+				return err
+			}
+			return errRollback
+		}); !errors.Is(err, errRollback) {
+			t.Errorf("inner Transaction() = %v", err)
+		}
+		return a.WithDB(tx).Create(&User{Name: "outer"})
+	})
+	if err != nil || len(received()) != 1 || received()[0] != "outer" {
+		t.Fatalf("Transaction() = %v, received %v, want only the outer entity", err, received())
+	}
+	if len(fake.executed("ROLLBACK TO SAVEPOINT")) != 1 {
+		t.Fatalf("inner transaction should roll back to a savepoint: %v", fake.executed("SAVEPOINT"))
+	}
+
+	// 内层提交, 外层回滚: 都不执行
+	err = Transaction(ctx, db, func(tx *gorm.DB) error {
+		if err := Transaction(ctx, tx, func(tx *gorm.DB) error {
+			return a.WithDB(tx).Create(&User{Name: "inner"})
+		}); err != nil {
+			return err
+		}
+		if len(received()) != 1 {
+			t.Error("inner hook ran before the outer commit")
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) || len(received()) != 1 {
+		t.Fatalf("Transaction() = %v, received %v, want no new hooks", err, received())
+	}
+}
+
+func TestAfterCommitNativeTransaction(t *testing.T) {
+	received := subscribeCreated(t)
+	db, fake := newFakeDB(t)
+	a := NewAction[User](WithDB[User](db))
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return a.WithDB(tx).Create(&User{Name: "native"})
+	})
+	if !errors.Is(err, ErrNativeTransaction) {
+		t.Fatalf("Create() in db.Transaction = %v, want ErrNativeTransaction", err)
+	}
+
+	tx := db.Begin()
+	if err := a.WithDB(tx).Create(&User{Name: "begin"}); !errors.Is(err, ErrNativeTransaction) {
+		t.Fatalf("Create() after Begin = %v, want ErrNativeTransaction", err)
+	}
+	tx.Rollback()
+
+	if len(received()) != 0 {
+		t.Fatalf("received %v, hooks should not run in native transactions", received())
+	}
+	if commits, _ := fake.txCounts(); commits != 0 {
+		t.Fatalf("native transactions committed %d times, want 0", commits)
+	}
+}
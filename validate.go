@@ -0,0 +1,400 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const validateTagKey = "validate"
+
+const (
+	// ValidationRequired 必填
+	ValidationRequired = "required"
+	// ValidationLen 长度范围, 字符串按字符计算
+	ValidationLen = "len"
+	// ValidationRange 数值范围
+	ValidationRange = "range"
+	// ValidationRegex 正则, 正则中的 ; 需要写成 \;
+	ValidationRegex = "regex"
+	// ValidationEnum 枚举
+	ValidationEnum = "enum"
+)
+
+// ErrValidation 校验失败, ValidationErrors满足errors.Is(err, ErrValidation)
+var ErrValidation = errors.New("validation failed")
+
+type (
+	// IValidator 模型实现该接口时, Create/BatchCreate/Update/UpdateByID会在标签校验通过后调用Validate
+	IValidator interface {
+		Validate(ctx context.Context) error
+	}
+
+	// FieldError 字段校验错误
+	FieldError struct {
+		// Index BatchCreate中出错数据的下标, 其他操作为nil
+		Index *int `json:"index,omitempty"`
+		// Field 字段名, 优先使用json标签
+		Field string `json:"field"`
+		// Code 错误码, 见ValidationXxx, 也可以是Validate返回的自定义错误码
+		Code string `json:"code"`
+		// Param 规则参数, 例如 len=1,32 中的 1,32
+		Param string `json:"param,omitempty"`
+		// Message 错误描述
+		Message string `json:"message"`
+	}
+
+	// ValidationErrors 校验错误列表
+	ValidationErrors []*FieldError
+
+	// fieldRules 字段的校验规则
+	fieldRules struct {
+		index  []int
+		name   string
+		column string
+		rules  []fieldRule
+	}
+
+	fieldRule struct {
+		code  string
+		param string
+		check func(v reflect.Value) bool
+	}
+)
+
+// validateRulesCache 模型类型 -> 字段规则
+var validateRulesCache sync.Map
+
+func (e *FieldError) Error() string {
+	if e.Index != nil {
+		return fmt.Sprintf("[%d].%s: %s", *e.Index, e.Field, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return ErrValidation.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+// Is 实现errors.Is
+func (e ValidationErrors) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// validateEntities 校验新增的数据, batch为true时错误带上数据下标
+func validateEntities[T any](ctx context.Context, ms []*T, batch bool) error {
+	var errs ValidationErrors
+	for i, m := range ms {
+		err := validateEntity(ctx, m, false)
+		if err == nil {
+			continue
+		}
+		var fes ValidationErrors
+		if !errors.As(err, &fes) {
+			if batch {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+			return err
+		}
+		if batch {
+			index := i
+			for _, fe := range fes {
+				fe.Index = &index
+			}
+		}
+		errs = append(errs, fes...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateUpdate 校验更新的值, 结构体只校验非零值字段, map只校验出现的字段
+func validateUpdate[T any](ctx context.Context, values any) error {
+	switch v := values.(type) {
+	case *T:
+		return validateEntity(ctx, v, true)
+	case map[string]any:
+		return validateMap[T](v)
+	}
+	return nil
+}
+
+// validateEntity 按标签校验, 通过后调用Validate, partial为true时跳过零值字段
+func validateEntity[T any](ctx context.Context, m *T, partial bool) error {
+	if m == nil {
+		return nil
+	}
+	rv := reflect.ValueOf(m).Elem()
+	rules, err := rulesOf(rv.Type())
+	if err != nil {
+		return err
+	}
+	var errs ValidationErrors
+	for _, fr := range rules {
+		fv := rv.FieldByIndex(fr.index)
+		if partial && fv.IsZero() {
+			continue
+		}
+		errs = append(errs, fr.check(fv)...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	if validator, ok := any(m).(IValidator); ok {
+		return validator.Validate(ctx)
+	}
+	return nil
+}
+
+// validateMap 按标签校验UpdateMap的值, key可以是列名或者字段名, SQL表达式(例如gorm.Expr("count+1"))不校验
+func validateMap[T any](values map[string]any) error {
+	var m T
+	rules, err := rulesOf(reflect.TypeOf(m))
+	if err != nil {
+		return err
+	}
+	var errs ValidationErrors
+	for _, fr := range rules {
+		v, ok := values[fr.column]
+		if !ok {
+			if v, ok = values[fr.name]; !ok {
+				continue
+			}
+		}
+		if _, ok := v.(clause.Expression); ok {
+			continue
+		}
+		errs = append(errs, fr.check(reflect.ValueOf(v))...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// check 校验字段值, 非必填字段为零值时不校验其他规则
+func (fr *fieldRules) check(v reflect.Value) ValidationErrors {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			v = reflect.Value{}
+			break
+		}
+		v = v.Elem()
+	}
+	zero := !v.IsValid() || v.IsZero()
+
+	var errs ValidationErrors
+	for _, rule := range fr.rules {
+		if rule.code == ValidationRequired {
+			if zero {
+				errs = append(errs, fr.fieldError(rule, "is required"))
+				// 必填校验失败时不再校验其他规则
+				return errs
+			}
+			continue
+		}
+		if zero {
+			continue
+		}
+		if !rule.check(v) {
+			errs = append(errs, fr.fieldError(rule, fmt.Sprintf("does not satisfy %s=%s", rule.code, rule.param)))
+		}
+	}
+	return errs
+}
+
+func (fr *fieldRules) fieldError(rule fieldRule, msg string) *FieldError {
+	return &FieldError{Field: fr.name, Code: rule.code, Param: rule.param, Message: msg}
+}
+
+// rulesOf 解析并缓存模型的校验规则
+func rulesOf(typ reflect.Type) ([]*fieldRules, error) {
+	if v, ok := validateRulesCache.Load(typ); ok {
+		return v.([]*fieldRules), nil
+	}
+	rules, err := parseRules(typ, nil)
+	if err != nil {
+		return nil, err
+	}
+	validateRulesCache.Store(typ, rules)
+	return rules, nil
+}
+
+func parseRules(typ reflect.Type, index []int) ([]*fieldRules, error) {
+	var res []*fieldRules
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		idx := append(append([]int(nil), index...), i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			embedded, err := parseRules(sf.Type, idx)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, embedded...)
+			continue
+		}
+		tag, ok := sf.Tag.Lookup(validateTagKey)
+		if !ok || tag == "" || !sf.IsExported() {
+			continue
+		}
+		fr := &fieldRules{index: idx, name: fieldName(sf), column: columnName(sf)}
+		for _, item := range splitRules(tag) {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			code, param, _ := strings.Cut(item, "=")
+			rule, err := newFieldRule(strings.TrimSpace(code), param)
+			if err != nil {
+				return nil, fmt.Errorf("invalid validate tag on %s.%s: %w", typ.Name(), sf.Name, err)
+			}
+			fr.rules = append(fr.rules, rule)
+		}
+		res = append(res, fr)
+	}
+	return res, nil
+}
+
+// splitRules 按 ; 拆分规则, 规则参数中的 ; 写成 \; (结构体标签中为 \\;), 例如 validate:"regex=^a\\;b$"
+func splitRules(tag string) []string {
+	var (
+		items []string
+		item  strings.Builder
+	)
+	for i := 0; i < len(tag); i++ {
+		switch {
+		case tag[i] == '\\' && i+1 < len(tag) && tag[i+1] == ';':
+			item.WriteByte(';')
+			i++
+		case tag[i] == ';':
+			items = append(items, item.String())
+			item.Reset()
+		default:
+			item.WriteByte(tag[i])
+		}
+	}
+	return append(items, item.String())
+}
+
+// newFieldRule 解析单条规则: required、len=min,max、range=min,max、regex=pattern、enum=a|b|c
+func newFieldRule(code, param string) (fieldRule, error) {
+	rule := fieldRule{code: code, param: param}
+	switch code {
+	case ValidationRequired:
+	case ValidationLen:
+		lo, hi, err := parseBounds(param)
+		if err != nil {
+			return rule, err
+		}
+		rule.check = func(v reflect.Value) bool {
+			var n int
+			switch v.Kind() {
+			case reflect.String:
+				n = utf8.RuneCountInString(v.String())
+			case reflect.Slice, reflect.Array, reflect.Map:
+				n = v.Len()
+			default:
+				return false
+			}
+			return inBounds(float64(n), lo, hi)
+		}
+	case ValidationRange:
+		lo, hi, err := parseBounds(param)
+		if err != nil {
+			return rule, err
+		}
+		rule.check = func(v reflect.Value) bool {
+			switch v.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				return inBounds(float64(v.Int()), lo, hi)
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				return inBounds(float64(v.Uint()), lo, hi)
+			case reflect.Float32, reflect.Float64:
+				return inBounds(v.Float(), lo, hi)
+			default:
+				return false
+			}
+		}
+	case ValidationRegex:
+		re, err := regexp.Compile(param)
+		if err != nil {
+			return rule, err
+		}
+		rule.check = func(v reflect.Value) bool {
+			return v.Kind() == reflect.String && re.MatchString(v.String())
+		}
+	case ValidationEnum:
+		options := make(map[string]struct{})
+		for _, option := range strings.Split(param, "|") {
+			options[option] = struct{}{}
+		}
+		rule.check = func(v reflect.Value) bool {
+			_, ok := options[fmt.Sprint(v.Interface())]
+			return ok
+		}
+	default:
+		return rule, fmt.Errorf("unknown rule %q", code)
+	}
+	return rule, nil
+}
+
+// parseBounds 解析 min,max, 任意一侧可以为空, 只有一个数字时表示精确值
+func parseBounds(param string) (lo, hi *float64, err error) {
+	parse := func(s string) (*float64, error) {
+		if s = strings.TrimSpace(s); s == "" {
+			return nil, nil
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &f, nil
+	}
+	loStr, hiStr, found := strings.Cut(param, ",")
+	if lo, err = parse(loStr); err != nil {
+		return nil, nil, err
+	}
+	if !found {
+		return lo, lo, nil
+	}
+	if hi, err = parse(hiStr); err != nil {
+		return nil, nil, err
+	}
+	return lo, hi, nil
+}
+
+func inBounds(n float64, lo, hi *float64) bool {
+	return (lo == nil || n >= *lo) && (hi == nil || n <= *hi)
+}
+
+// fieldName 错误中的字段名, 优先使用json标签
+func fieldName(sf reflect.StructField) string {
+	if name, _, _ := strings.Cut(sf.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	return sf.Name
+}
+
+// columnName 字段对应的列名, 用于匹配UpdateMap的key
+func columnName(sf reflect.StructField) string {
+	for _, item := range strings.Split(sf.Tag.Get("gorm"), ";") {
+		if key, value, ok := strings.Cut(item, ":"); ok && strings.EqualFold(strings.TrimSpace(key), "column") {
+			return strings.TrimSpace(value)
+		}
+	}
+	return schema.NamingStrategy{}.ColumnName("", sf.Name)
+}
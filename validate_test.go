@@ -0,0 +1,73 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type validateUser struct {
+	Name   string `json:"name" validate:"required;len=2,8"`
+	Age    int    `json:"age" validate:"range=0,150"`
+	Email  string `json:"email" validate:"regex=^[^@]+@[^@]+$"`
+	Status string `gorm:"column:state" json:"status" validate:"enum=on|off"`
+	Code   string `json:"code" validate:"len=3,16;regex=^[a-z]+\\;[0-9]+$"`
+}
+
+func TestValidateEntities(t *testing.T) {
+	ms := []*validateUser{
+		{Name: "tom", Age: 20, Email: "tom@example.com", Status: "on"},
+		{Name: "", Age: 200, Email: "bad", Status: "unknown"},
+	}
+	err := validateEntities(context.Background(), ms, true)
+	if !errors.Is(err, ErrValidation) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ValidationErrors, got %T", err)
+	}
+	codes := make(map[string]string)
+	for _, fe := range errs {
+		if fe.Index == nil || *fe.Index != 1 {
+			t.Fatalf("unexpected index on %v", fe)
+		}
+		codes[fe.Field] = fe.Code
+	}
+	want := map[string]string{"name": ValidationRequired, "age": ValidationRange, "email": ValidationRegex, "status": ValidationEnum}
+	for field, code := range want {
+		if codes[field] != code {
+			t.Errorf("field %s: got code %q, want %q", field, codes[field], code)
+		}
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	// 结构体更新跳过零值字段
+	if err := validateUpdate[validateUser](context.Background(), &validateUser{Age: 30}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := validateUpdate[validateUser](context.Background(), map[string]any{"name": "x", "state": "off"})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Code != ValidationLen || errs[0].Index != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateUpdateMap(t *testing.T) {
+	// SQL表达式交给数据库计算, 不按字段规则校验
+	if err := validateUpdate[validateUser](context.Background(), map[string]any{"age": gorm.Expr("age + ?", 1), "name": gorm.Expr("UPPER(name)")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 正则中转义的 ; 不拆分规则
+	if err := validateUpdate[validateUser](context.Background(), map[string]any{"code": "ab;12"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := validateUpdate[validateUser](context.Background(), map[string]any{"code": "ab12"})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Code != ValidationRegex || errs[0].Param != "^[a-z]+;[0-9]+$" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}